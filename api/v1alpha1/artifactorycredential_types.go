@@ -0,0 +1,98 @@
+package v1alpha1
+
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ArtifactoryCredential type metadata.
+var (
+	ArtifactoryCredentialKind = reflect.TypeOf(ArtifactoryCredential{}).Name()
+)
+
+// ArtifactoryCredentialSpec defines the secrets a tenant requests in the namespace of the ArtifactoryCredential
+type ArtifactoryCredentialSpec struct {
+	// SecretRotatorRef references the SecretRotator whose Artifactory connection and identity issue the tokens.
+	// Its credentialPolicy decides whether the namespace may request the secret types and scopes
+	SecretRotatorRef SecretRotatorReference `json:"secretRotatorRef"`
+
+	// GeneratedSecrets defines the secrets created in the namespace of the ArtifactoryCredential
+	// +kubebuilder:validation:MinItems=1
+	GeneratedSecrets []GeneratedSecret `json:"generatedSecrets"`
+}
+
+// SecretRotatorReference references a SecretRotator
+type SecretRotatorReference struct {
+	// Name of the SecretRotator
+	Name string `json:"name"`
+	// Namespace of the SecretRotator, only set when the SecretRotator CRD is installed namespace scoped
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ArtifactoryCredentialStatus defines the observed state of ArtifactoryCredential
+type ArtifactoryCredentialStatus struct {
+	// Conditions report whether the credentialPolicy of the SecretRotator approved the request and the secrets were written
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// Secrets report the token each generated secret holds
+	// +optional
+	Secrets []SecretStatus `json:"secrets,omitempty"`
+
+	// IssuedTokens are the tokens the secrets of the ArtifactoryCredential currently hold
+	// +optional
+	IssuedTokens []IssuedToken `json:"issuedTokens,omitempty"`
+
+	// SupersededTokens are the tokens replaced by a rotation, waiting for revocation under the tokenRevocation of the SecretRotator
+	// +optional
+	SupersededTokens []IssuedToken `json:"supersededTokens,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=artcred
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Secret Rotator",type=string,JSONPath=`.spec.secretRotatorRef.name`
+// +kubebuilder:printcolumn:name="Approved",type=string,JSONPath=`.status.conditions[?(@.type=="Approved")].status`
+
+// ArtifactoryCredential is the Schema for the artifactorycredentials API, letting a tenant request
+// secrets in its own namespace from a SecretRotator approved by the cluster admins
+type ArtifactoryCredential struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ArtifactoryCredentialSpec   `json:"spec,omitempty"`
+	Status ArtifactoryCredentialStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ArtifactoryCredentialList contains a list of ArtifactoryCredential
+type ArtifactoryCredentialList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ArtifactoryCredential `json:"items"`
+}
+
+func init() {
+
+	SchemeBuilder.Register(&ArtifactoryCredential{}, &ArtifactoryCredentialList{})
+
+}
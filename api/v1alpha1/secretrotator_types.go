@@ -39,6 +39,8 @@ var (
 )
 
 // SecretRotatorSpec defines the desired state of SecretRotator
+// +kubebuilder:validation:XValidation:rule="!has(self.tokenTTL) || !has(self.refreshTime) || duration(self.tokenTTL) > duration(self.refreshTime)",message="tokenTTL must be longer than refreshTime"
+// +kubebuilder:validation:XValidation:rule="has(self.namespaceSelector) || (!has(self.generatedSecrets) && !has(self.secretName) && has(self.credentialPolicy) && has(self.credentialPolicy.enabled) && self.credentialPolicy.enabled)",message="namespaceSelector is required unless the SecretRotator only serves ArtifactoryCredentials through an enabled credentialPolicy"
 type SecretRotatorSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
@@ -57,9 +59,18 @@ type SecretRotatorSpec struct {
 	// GeneratedSecrets defines the secrets to be created
 	GeneratedSecrets []GeneratedSecret `json:"generatedSecrets,omitempty"`
 
-	// NamespaceSelector holding SecretRotatorList of the namespaces
+	// NamespaceSelector holding SecretRotatorList of the namespaces, optional when the SecretRotator only serves ArtifactoryCredentials
+	// +optional
 	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
 
+	// Namespaces restricts the namespaces selected by the NamespaceSelector to these names or glob patterns such as team-*
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// ExcludeNamespaces lists the names or glob patterns of namespaces never provisioned, even if they are selected
+	// +optional
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+
 	// ArtifactoryUrl, URL of Artifactory
 	ArtifactoryUrl string `json:"artifactoryUrl,omitempty"`
 
@@ -72,29 +83,209 @@ type SecretRotatorSpec struct {
 	// RefreshInterval The time in which the controller should reconcile it's objects and recheck namespaces for labels.
 	RefreshInterval *metav1.Duration `json:"refreshTime,omitempty"`
 
+	// TokenTTL is the lifetime of the issued Artifactory tokens, independent of the IAM role max session duration.
+	// Defaults to the IAM role max session duration for the AWS auth types and to the Artifactory default for the OIDC auth types.
+	// +optional
+	TokenTTL *metav1.Duration `json:"tokenTTL,omitempty"`
+
+	// RotateBefore is how long before their expiry the tokens are rotated, a duration such as 10m or a percentage of the token TTL such as 25%.
+	// Defaults to 25%, a shorter refreshTime reconciles earlier.
+	// +kubebuilder:validation:Pattern=`^(([0-9]+(\.[0-9]+)?(ns|us|ms|s|m|h))+|[0-9]+(\.[0-9]+)?%)$`
+	// +optional
+	RotateBefore string `json:"rotateBefore,omitempty"`
+
 	// Security holding tls/ssl certificates details
 	Security SecurityDetails `json:"security,omitempty"`
 
-	// AuthType defines how AWS credentials are resolved for the operator.
-	// +kubebuilder:validation:Enum=auto;webIdentity;podIdentity
+	// DeletionPolicy defines what happens to the generated secrets and the issued tokens when the SecretRotator is deleted.
+	// Delete removes the secrets and revokes the live tokens, Orphan removes the owner references so the secrets outlive the SecretRotator
+	// and Retain leaves the secrets and tokens untouched.
+	// +kubebuilder:validation:Enum=Delete;Retain;Orphan
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+
+	// TokenRevocation holding the revocation details of the tokens superseded by a rotation
+	// +optional
+	TokenRevocation TokenRevocationDetails `json:"tokenRevocation,omitempty"`
+
+	// RestartPolicy holding which workloads are restarted after their secrets were rotated
+	// +optional
+	RestartPolicy RestartPolicyDetails `json:"restartPolicy,omitempty"`
+
+	// CredentialPolicy holding which namespaces may request secrets from this SecretRotator through ArtifactoryCredentials
+	// +optional
+	CredentialPolicy CredentialPolicyDetails `json:"credentialPolicy,omitempty"`
+
+	// AuthType defines how the operator authenticates against Artifactory.
+	// auto, webIdentity and podIdentity resolve AWS credentials, kubernetesOidc exchanges a ServiceAccount token through Artifactory's OIDC integration
+	// gcpWorkloadIdentity exchanges a Google-signed identity token of the bound Google service account
+	// and azureWorkloadIdentity exchanges a Microsoft Entra ID token of the federated managed identity or application.
+	// +kubebuilder:validation:Enum=auto;webIdentity;podIdentity;kubernetesOidc;gcpWorkloadIdentity;azureWorkloadIdentity
 	// +kubebuilder:default=auto
 	// +optional
 	AuthType string `json:"authType,omitempty"`
 
+	// AuthPriority is the order in which the auto auth type detects identity providers, defaults to podIdentity then webIdentity
+	// +kubebuilder:validation:items:Enum=webIdentity;podIdentity;kubernetesOidc;gcpWorkloadIdentity;azureWorkloadIdentity
+	// +optional
+	AuthPriority []string `json:"authPriority,omitempty"`
+
+	// KubernetesOidc holding the OIDC token exchange details, used with authType kubernetesOidc
+	// +optional
+	KubernetesOidc OidcDetails `json:"kubernetesOidc,omitempty"`
+
+	// GcpWorkloadIdentity holding the GCP Workload Identity details, used with authType gcpWorkloadIdentity
+	// +optional
+	GcpWorkloadIdentity GcpWorkloadIdentityDetails `json:"gcpWorkloadIdentity,omitempty"`
+
+	// AzureWorkloadIdentity holding the Azure Workload Identity details, used with authType azureWorkloadIdentity
+	// +optional
+	AzureWorkloadIdentity AzureWorkloadIdentityDetails `json:"azureWorkloadIdentity,omitempty"`
+
 	// AwsRegion holding aws region name
 	// +optional
 	AwsRegion string `json:"awsRegion,omitempty"`
 }
 
 // GeneratedSecret defines an individual secret to be created
+// +kubebuilder:validation:XValidation:rule="self.secretType != 'template' || (has(self.template) && size(self.template) > 0)",message="secretType template needs at least one data key in template"
 type GeneratedSecret struct {
 	// SecretName holding name of the secret
 	SecretName string `json:"secretName"`
-	// SecretType specifies the type of secret (docker or generic)
+	// SecretType specifies the type of secret (docker, generic, basicAuth, npm, maven, gradle, netrc, pip, argocd, flux or template)
 	SecretType string `json:"secretType"`
-	// Scope defines the scope of the secret (optional)
+	// Scope defines the scope of the Artifactory token issued for this secret (optional)
+	// Secrets with different scopes get separately issued tokens, e.g. applied-permissions/groups:readers
 	// +optional
 	Scope string `json:"scope,omitempty"`
+	// Docker holding how the docker config is written, used with secretType docker
+	// +optional
+	Docker *DockerSecretDetails `json:"docker,omitempty"`
+	// Npm holding the npm registries of the .npmrc, used with secretType npm
+	// +optional
+	Npm *NpmSecretDetails `json:"npm,omitempty"`
+	// Maven holding the Maven repositories of the settings.xml, used with secretType maven
+	// +optional
+	Maven *MavenSecretDetails `json:"maven,omitempty"`
+	// Gradle holding the properties of the gradle.properties, used with secretType gradle
+	// +optional
+	Gradle *GradleSecretDetails `json:"gradle,omitempty"`
+	// Pip holding the PyPI repository of the pip.conf, used with secretType pip
+	// +optional
+	Pip *PipSecretDetails `json:"pip,omitempty"`
+	// Argocd holding the Argo CD repository credential, used with secretType argocd
+	// +optional
+	Argocd *ArgocdSecretDetails `json:"argocd,omitempty"`
+	// Flux holding the Flux source credential, used with secretType flux
+	// +optional
+	Flux *FluxSecretDetails `json:"flux,omitempty"`
+	// Template maps the data keys of the secret to Go text/template strings, used with secretType template
+	// The templates receive .Username, .Token, .ArtifactoryURL, .Subdomains, .ExpiresAt and .Namespace
+	// +optional
+	Template map[string]string `json:"template,omitempty"`
+	// DataKeys renames the data keys of the secret, mapping the default key of the secret type to the key written, e.g. token: apiKey
+	// Supported by the Opaque secret types except template, whose data keys are the template keys
+	// +optional
+	DataKeys map[string]string `json:"dataKeys,omitempty"`
+}
+
+// ArgocdSecretDetails defines the Argo CD repository credential written to the secret
+type ArgocdSecretDetails struct {
+	// CredentialType is the Argo CD secret type, repo-creds is a credential template for every repository url it prefixes
+	// and repository declares a single repository
+	// +kubebuilder:validation:Enum=repo-creds;repository
+	// +kubebuilder:default=repo-creds
+	// +optional
+	CredentialType string `json:"credentialType,omitempty"`
+	// RepositoryType is the Argo CD repository type, helm or oci, oci repositories are written as helm repositories with enableOCI
+	// +kubebuilder:validation:Enum=helm;oci
+	// +kubebuilder:default=helm
+	// +optional
+	RepositoryType string `json:"repositoryType,omitempty"`
+	// Repository is the Artifactory repository key of the url, required with credentialType repository.
+	// Without it the url covers every repository of the Artifactory
+	// +optional
+	Repository string `json:"repository,omitempty"`
+	// Project is the Argo CD project the credential is scoped to (optional)
+	// +optional
+	Project string `json:"project,omitempty"`
+}
+
+// DockerSecretDetails defines how the docker config secret is written
+type DockerSecretDetails struct {
+	// Merge writes only the auths entries of the Artifactory url and subdomains into the .dockerconfigjson,
+	// preserving the entries of other writers. The secret may exist without being owned by the SecretRotator,
+	// the owned entries are tracked in the secretrotator.jfrog.com/managed-auths annotation
+	// +optional
+	Merge bool `json:"merge,omitempty"`
+	// ServiceAccounts adds the secret to the imagePullSecrets of the selected ServiceAccounts in every provisioned namespace.
+	// The reference is removed again when the operator deletes the secret
+	// +optional
+	ServiceAccounts *ServiceAccountSelector `json:"serviceAccounts,omitempty"`
+}
+
+// ServiceAccountSelector selects the ServiceAccounts of a namespace by name or label, defaults to the default ServiceAccount
+type ServiceAccountSelector struct {
+	// Names of the ServiceAccounts, defaults to default when neither names nor selector are set
+	// +optional
+	Names []string `json:"names,omitempty"`
+	// Selector of the ServiceAccounts by label, in addition to the names
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// FluxSecretDetails defines the Flux source credential written to the secret
+type FluxSecretDetails struct {
+	// RepositoryType is the type of the Flux sources referencing the secret, helm writes username and password
+	// for HelmRepository http repositories and oci writes a .dockerconfigjson for OCIRepository and oci HelmRepository sources
+	// +kubebuilder:validation:Enum=helm;oci
+	// +kubebuilder:default=helm
+	// +optional
+	RepositoryType string `json:"repositoryType,omitempty"`
+	// IncludeCA bundles the CA certificate of the security certificate secret as caFile and ca.crt, used with repositoryType helm
+	// +optional
+	IncludeCA bool `json:"includeCA,omitempty"`
+	// ReconcileSources annotates the HelmRepository and OCIRepository sources in the namespace referencing the secret
+	// with reconcile.fluxcd.io/requestedAt after every rotation, so Flux picks up the rotated token immediately
+	// +optional
+	ReconcileSources bool `json:"reconcileSources,omitempty"`
+}
+
+// MavenSecretDetails defines the Artifactory repositories written to the settings.xml
+type MavenSecretDetails struct {
+	// Repositories are the repository keys added as servers and as repositories and plugin repositories of the active artifactory profile
+	// +optional
+	Repositories []string `json:"repositories,omitempty"`
+	// MirrorRepository is the repository key added as server and as mirror of all repositories
+	// +optional
+	MirrorRepository string `json:"mirrorRepository,omitempty"`
+}
+
+// GradleSecretDetails defines the properties written to the gradle.properties
+type GradleSecretDetails struct {
+	// Repository is the repository key the url property points at, defaults to the Artifactory context url
+	// +optional
+	Repository string `json:"repository,omitempty"`
+	// PropertyPrefix of the url, user and password properties, defaults to artifactory, e.g. artifactoryUrl, artifactoryUser and artifactoryPassword
+	// +optional
+	PropertyPrefix string `json:"propertyPrefix,omitempty"`
+}
+
+// PipSecretDetails defines the Artifactory PyPI repository written to the pip.conf
+type PipSecretDetails struct {
+	// Repository is the PyPI repository key used as index-url
+	Repository string `json:"repository"`
+}
+
+// NpmSecretDetails defines the Artifactory npm repositories written to the .npmrc
+type NpmSecretDetails struct {
+	// Repository is the npm repository key used as the default registry
+	// +optional
+	Repository string `json:"repository,omitempty"`
+	// PackageScopes map npm package scopes to repository keys, e.g. "@myorg": npm-internal
+	// +optional
+	PackageScopes map[string]string `json:"packageScopes,omitempty"`
 }
 
 // SecurityDetails defines details for certificates, fields are insecureSkipVerify, secret nameand enable flag.
@@ -110,6 +301,73 @@ type SecurityDetails struct {
 	InsecureSkipVerify bool `default:"false" json:"insecureSkipVerify,omitempty"`
 }
 
+// TokenRevocationDetails defines whether and when superseded tokens are revoked.
+// Once every generated secret holds the newly issued tokens, the previously issued tokens are revoked
+// on the first reconciliation after the grace period passed.
+type TokenRevocationDetails struct {
+	// Enabled revokes superseded tokens through Artifactory's token revocation API
+	// +kubebuilder:default:=false
+	// +optional
+	Enabled bool `default:"false" json:"enabled,omitempty"`
+	// GracePeriod lets workloads pick up the rotated secrets before the superseded tokens are revoked, defaults to 5m
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// RestartPolicyDetails defines whether and which workloads are restarted after their secrets were rotated.
+// Workloads are restarted by updating the secretrotator.jfrog.com/secret-hashes annotation of their pod template
+// with the hash of the rotated secret data.
+type RestartPolicyDetails struct {
+	// Enabled restarts the Deployments, StatefulSets and DaemonSets in the provisioned namespaces
+	// referencing a rotated secret through env, envFrom, volumes or imagePullSecrets
+	// +kubebuilder:default:=false
+	// +optional
+	Enabled bool `default:"false" json:"enabled,omitempty"`
+	// Selector additionally restarts the workloads with matching labels after every rotation of a secret in their namespace
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// MaxRestartsPerMinute limits how many workloads are restarted per minute for the SecretRotator, defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxRestartsPerMinute int32 `json:"maxRestartsPerMinute,omitempty"`
+}
+
+// CredentialPolicyDetails defines which namespaces may reference the SecretRotator from an ArtifactoryCredential,
+// and which secret types and token scopes they may request. It is evaluated on every reconciliation of the SecretRotator.
+type CredentialPolicyDetails struct {
+	// Enabled lets ArtifactoryCredentials request secrets issued with the Artifactory connection and identity of the SecretRotator
+	// +kubebuilder:default:=false
+	// +optional
+	Enabled bool `default:"false" json:"enabled,omitempty"`
+	// NamespaceSelector selects the namespaces whose ArtifactoryCredentials may reference the SecretRotator, all namespaces when not set
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// AllowedScopes lists the token scopes, or glob patterns such as applied-permissions/groups:team-*, ArtifactoryCredentials may request.
+	// Only the default scope may be requested when empty, and only the listed scopes otherwise
+	// +optional
+	AllowedScopes []string `json:"allowedScopes,omitempty"`
+	// AllowedSecretTypes lists the secret types ArtifactoryCredentials may request, all secret types but template when empty
+	// +optional
+	AllowedSecretTypes []string `json:"allowedSecretTypes,omitempty"`
+}
+
+// IssuedToken references a token issued by the operator, the token itself is only stored in the generated secrets
+type IssuedToken struct {
+	// TokenID is the token_id reported by Artifactory
+	TokenID string `json:"tokenId"`
+	// Scope is the scope the token was requested with
+	// +optional
+	Scope string `json:"scope,omitempty"`
+	// IssuedAt is when the token was issued
+	IssuedAt metav1.Time `json:"issuedAt"`
+	// ExpiresAt is when the token expires, if Artifactory reported an expiry
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// SupersededAt is when every generated secret was updated with a newer token
+	// +optional
+	SupersededAt *metav1.Time `json:"supersededAt,omitempty"`
+}
+
 // ServiceAccountDetails defines name and namespace of the service account.
 type ServiceAccountDetails struct {
 	// Name of the service account
@@ -118,6 +376,64 @@ type ServiceAccountDetails struct {
 	Namespace string `json:"namespace,omitempty"`
 }
 
+// OidcDetails defines the Artifactory OIDC integration used to exchange an identity token for an Artifactory token.
+type OidcDetails struct {
+	// ProviderName is the name of the OIDC provider configured in the JFrog platform
+	ProviderName string `json:"providerName,omitempty"`
+	// IdentityMappingName is the name of the identity mapping to use, when omitted Artifactory picks the matching one
+	// +optional
+	IdentityMappingName string `json:"identityMappingName,omitempty"`
+	// Audience of the ServiceAccount token, must match the audience configured in the OIDC provider
+	// +optional
+	Audience string `json:"audience,omitempty"`
+	// ProjectKey of the JFrog project the token is exchanged for (optional)
+	// +optional
+	ProjectKey string `json:"projectKey,omitempty"`
+}
+
+// GcpWorkloadIdentityDetails defines the Google service account whose identity token is exchanged with Artifactory.
+type GcpWorkloadIdentityDetails struct {
+	// ProviderName is the name of the OIDC provider configured in the JFrog platform
+	ProviderName string `json:"providerName,omitempty"`
+	// IdentityMappingName is the name of the identity mapping to use, when omitted Artifactory picks the matching one
+	// +optional
+	IdentityMappingName string `json:"identityMappingName,omitempty"`
+	// Audience of the Google identity token, must match the audience configured in the OIDC provider
+	// +optional
+	Audience string `json:"audience,omitempty"`
+	// ProjectKey of the JFrog project the token is exchanged for (optional)
+	// +optional
+	ProjectKey string `json:"projectKey,omitempty"`
+	// ServiceAccountEmail of the bound Google service account, defaults to the iam.gke.io/gcp-service-account annotation of the ServiceAccount
+	// +optional
+	ServiceAccountEmail string `json:"serviceAccountEmail,omitempty"`
+	// WorkloadIdentityProvider is the Google STS audience, e.g. //iam.googleapis.com/projects/<number>/locations/global/workloadIdentityPools/<pool>/providers/<provider>.
+	// When set, the ServiceAccount token is exchanged through Google STS, otherwise the GKE metadata server is used.
+	// +optional
+	WorkloadIdentityProvider string `json:"workloadIdentityProvider,omitempty"`
+}
+
+// AzureWorkloadIdentityDetails defines the Microsoft Entra ID application whose token is exchanged with Artifactory.
+type AzureWorkloadIdentityDetails struct {
+	// ProviderName is the name of the OIDC provider configured in the JFrog platform
+	ProviderName string `json:"providerName,omitempty"`
+	// IdentityMappingName is the name of the identity mapping to use, when omitted Artifactory picks the matching one
+	// +optional
+	IdentityMappingName string `json:"identityMappingName,omitempty"`
+	// ProjectKey of the JFrog project the token is exchanged for (optional)
+	// +optional
+	ProjectKey string `json:"projectKey,omitempty"`
+	// ClientID of the federated managed identity or application, defaults to the azure.workload.identity/client-id annotation of the ServiceAccount
+	// +optional
+	ClientID string `json:"clientId,omitempty"`
+	// TenantID of the Microsoft Entra tenant, defaults to the azure.workload.identity/tenant-id annotation of the ServiceAccount
+	// +optional
+	TenantID string `json:"tenantId,omitempty"`
+	// Scope requested from Microsoft Entra ID, defaults to <clientId>/.default
+	// +optional
+	Scope string `json:"scope,omitempty"`
+}
+
 // SecretMetadata defines metadata fields for the ExternalSecret generated by the SecretOperator.
 type SecretMetadata struct {
 	// +optional
@@ -137,6 +453,49 @@ type SecretNamespaceFailure struct {
 	Reason string `json:"reason,omitempty"`
 }
 
+// GeneratedSecretStatus represents a generated secret and the scope of the token it was issued with.
+type GeneratedSecretStatus struct {
+	// SecretName is the name of the generated secret
+	SecretName string `json:"secretName"`
+
+	// SecretType is the type of the generated secret
+	// +optional
+	SecretType string `json:"secretType,omitempty"`
+
+	// Scope is the scope the secret's Artifactory token was issued with
+	// +optional
+	Scope string `json:"scope,omitempty"`
+}
+
+// SecretStatus reports the token a generated secret in a namespace holds
+type SecretStatus struct {
+	// Namespace of the generated secret
+	Namespace string `json:"namespace"`
+
+	// SecretName is the name of the generated secret
+	SecretName string `json:"secretName"`
+
+	// Scope is the scope the secret's Artifactory token was issued with
+	// +optional
+	Scope string `json:"scope,omitempty"`
+
+	// TokenID is the id of the Artifactory token the secret holds, not the token itself
+	// +optional
+	TokenID string `json:"tokenId,omitempty"`
+
+	// LastRotationTime is when the secret was last written with a new token
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// TokenExpiresAt is when the token the secret holds expires
+	// +optional
+	TokenExpiresAt *metav1.Time `json:"tokenExpiresAt,omitempty"`
+
+	// LastError is the reason the last rotation of the secret failed, empty once it was rotated
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+}
+
 // SecretRotatorStatus defines the observed state of SecretRotator
 type SecretRotatorStatus struct {
 	// Represents the observations of a Memcached's current state.
@@ -163,6 +522,22 @@ type SecretRotatorStatus struct {
 	// +optional
 	SecretManagedByNamespaces map[string][]string `json:"secretManagedByNamespaces,omitempty"`
 
+	// GeneratedSecrets are the generated secrets with the scope their token was issued with
+	// +optional
+	GeneratedSecrets []GeneratedSecretStatus `json:"generatedSecrets,omitempty"`
+
+	// Secrets report the token each generated secret holds, per namespace
+	// +optional
+	Secrets []SecretStatus `json:"secrets,omitempty"`
+
+	// IssuedTokens are the tokens the generated secrets currently hold
+	// +optional
+	IssuedTokens []IssuedToken `json:"issuedTokens,omitempty"`
+
+	// SupersededTokens are the tokens replaced by a rotation, waiting for revocation
+	// +optional
+	SupersededTokens []IssuedToken `json:"supersededTokens,omitempty"`
+
 	// AuthType is the type of authentication used to get the AWS credentials
 	// +optional
 	AuthType string `json:"authType,omitempty"`
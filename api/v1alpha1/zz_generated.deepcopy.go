@@ -25,9 +25,293 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactoryCredential) DeepCopyInto(out *ArtifactoryCredential) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactoryCredential.
+func (in *ArtifactoryCredential) DeepCopy() *ArtifactoryCredential {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactoryCredential)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ArtifactoryCredential) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactoryCredentialList) DeepCopyInto(out *ArtifactoryCredentialList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ArtifactoryCredential, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactoryCredentialList.
+func (in *ArtifactoryCredentialList) DeepCopy() *ArtifactoryCredentialList {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactoryCredentialList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ArtifactoryCredentialList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactoryCredentialSpec) DeepCopyInto(out *ArtifactoryCredentialSpec) {
+	*out = *in
+	out.SecretRotatorRef = in.SecretRotatorRef
+	if in.GeneratedSecrets != nil {
+		in, out := &in.GeneratedSecrets, &out.GeneratedSecrets
+		*out = make([]GeneratedSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactoryCredentialSpec.
+func (in *ArtifactoryCredentialSpec) DeepCopy() *ArtifactoryCredentialSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactoryCredentialSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactoryCredentialStatus) DeepCopyInto(out *ArtifactoryCredentialStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]SecretStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IssuedTokens != nil {
+		in, out := &in.IssuedTokens, &out.IssuedTokens
+		*out = make([]IssuedToken, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SupersededTokens != nil {
+		in, out := &in.SupersededTokens, &out.SupersededTokens
+		*out = make([]IssuedToken, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactoryCredentialStatus.
+func (in *ArtifactoryCredentialStatus) DeepCopy() *ArtifactoryCredentialStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactoryCredentialStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArgocdSecretDetails) DeepCopyInto(out *ArgocdSecretDetails) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArgocdSecretDetails.
+func (in *ArgocdSecretDetails) DeepCopy() *ArgocdSecretDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(ArgocdSecretDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureWorkloadIdentityDetails) DeepCopyInto(out *AzureWorkloadIdentityDetails) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureWorkloadIdentityDetails.
+func (in *AzureWorkloadIdentityDetails) DeepCopy() *AzureWorkloadIdentityDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureWorkloadIdentityDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CredentialPolicyDetails) DeepCopyInto(out *CredentialPolicyDetails) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedScopes != nil {
+		in, out := &in.AllowedScopes, &out.AllowedScopes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedSecretTypes != nil {
+		in, out := &in.AllowedSecretTypes, &out.AllowedSecretTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CredentialPolicyDetails.
+func (in *CredentialPolicyDetails) DeepCopy() *CredentialPolicyDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(CredentialPolicyDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DockerSecretDetails) DeepCopyInto(out *DockerSecretDetails) {
+	*out = *in
+	if in.ServiceAccounts != nil {
+		in, out := &in.ServiceAccounts, &out.ServiceAccounts
+		*out = new(ServiceAccountSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DockerSecretDetails.
+func (in *DockerSecretDetails) DeepCopy() *DockerSecretDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(DockerSecretDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FluxSecretDetails) DeepCopyInto(out *FluxSecretDetails) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FluxSecretDetails.
+func (in *FluxSecretDetails) DeepCopy() *FluxSecretDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(FluxSecretDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GcpWorkloadIdentityDetails) DeepCopyInto(out *GcpWorkloadIdentityDetails) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GcpWorkloadIdentityDetails.
+func (in *GcpWorkloadIdentityDetails) DeepCopy() *GcpWorkloadIdentityDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(GcpWorkloadIdentityDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GeneratedSecret) DeepCopyInto(out *GeneratedSecret) {
 	*out = *in
+	if in.Docker != nil {
+		in, out := &in.Docker, &out.Docker
+		*out = new(DockerSecretDetails)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Npm != nil {
+		in, out := &in.Npm, &out.Npm
+		*out = new(NpmSecretDetails)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Maven != nil {
+		in, out := &in.Maven, &out.Maven
+		*out = new(MavenSecretDetails)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Gradle != nil {
+		in, out := &in.Gradle, &out.Gradle
+		*out = new(GradleSecretDetails)
+		**out = **in
+	}
+	if in.Pip != nil {
+		in, out := &in.Pip, &out.Pip
+		*out = new(PipSecretDetails)
+		**out = **in
+	}
+	if in.Argocd != nil {
+		in, out := &in.Argocd, &out.Argocd
+		*out = new(ArgocdSecretDetails)
+		**out = **in
+	}
+	if in.Flux != nil {
+		in, out := &in.Flux, &out.Flux
+		*out = new(FluxSecretDetails)
+		**out = **in
+	}
+	if in.Template != nil {
+		in, out := &in.Template, &out.Template
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.DataKeys != nil {
+		in, out := &in.DataKeys, &out.DataKeys
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeneratedSecret.
@@ -40,6 +324,152 @@ func (in *GeneratedSecret) DeepCopy() *GeneratedSecret {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeneratedSecretStatus) DeepCopyInto(out *GeneratedSecretStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeneratedSecretStatus.
+func (in *GeneratedSecretStatus) DeepCopy() *GeneratedSecretStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GeneratedSecretStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GradleSecretDetails) DeepCopyInto(out *GradleSecretDetails) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GradleSecretDetails.
+func (in *GradleSecretDetails) DeepCopy() *GradleSecretDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(GradleSecretDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IssuedToken) DeepCopyInto(out *IssuedToken) {
+	*out = *in
+	in.IssuedAt.DeepCopyInto(&out.IssuedAt)
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.SupersededAt != nil {
+		in, out := &in.SupersededAt, &out.SupersededAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuedToken.
+func (in *IssuedToken) DeepCopy() *IssuedToken {
+	if in == nil {
+		return nil
+	}
+	out := new(IssuedToken)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MavenSecretDetails) DeepCopyInto(out *MavenSecretDetails) {
+	*out = *in
+	if in.Repositories != nil {
+		in, out := &in.Repositories, &out.Repositories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MavenSecretDetails.
+func (in *MavenSecretDetails) DeepCopy() *MavenSecretDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(MavenSecretDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NpmSecretDetails) DeepCopyInto(out *NpmSecretDetails) {
+	*out = *in
+	if in.PackageScopes != nil {
+		in, out := &in.PackageScopes, &out.PackageScopes
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NpmSecretDetails.
+func (in *NpmSecretDetails) DeepCopy() *NpmSecretDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(NpmSecretDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OidcDetails) DeepCopyInto(out *OidcDetails) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OidcDetails.
+func (in *OidcDetails) DeepCopy() *OidcDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(OidcDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PipSecretDetails) DeepCopyInto(out *PipSecretDetails) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipSecretDetails.
+func (in *PipSecretDetails) DeepCopy() *PipSecretDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(PipSecretDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestartPolicyDetails) DeepCopyInto(out *RestartPolicyDetails) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestartPolicyDetails.
+func (in *RestartPolicyDetails) DeepCopy() *RestartPolicyDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(RestartPolicyDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretMetadata) DeepCopyInto(out *SecretMetadata) {
 	*out = *in
@@ -143,6 +573,21 @@ func (in *SecretRotatorList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretRotatorReference) DeepCopyInto(out *SecretRotatorReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretRotatorReference.
+func (in *SecretRotatorReference) DeepCopy() *SecretRotatorReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretRotatorReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretRotatorSpec) DeepCopyInto(out *SecretRotatorSpec) {
 	*out = *in
@@ -150,9 +595,21 @@ func (in *SecretRotatorSpec) DeepCopyInto(out *SecretRotatorSpec) {
 	if in.GeneratedSecrets != nil {
 		in, out := &in.GeneratedSecrets, &out.GeneratedSecrets
 		*out = make([]GeneratedSecret, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	in.NamespaceSelector.DeepCopyInto(&out.NamespaceSelector)
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeNamespaces != nil {
+		in, out := &in.ExcludeNamespaces, &out.ExcludeNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.ArtifactorySubdomains != nil {
 		in, out := &in.ArtifactorySubdomains, &out.ArtifactorySubdomains
 		*out = make([]string, len(*in))
@@ -164,7 +621,23 @@ func (in *SecretRotatorSpec) DeepCopyInto(out *SecretRotatorSpec) {
 		*out = new(v1.Duration)
 		**out = **in
 	}
+	if in.TokenTTL != nil {
+		in, out := &in.TokenTTL, &out.TokenTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	out.Security = in.Security
+	in.TokenRevocation.DeepCopyInto(&out.TokenRevocation)
+	in.RestartPolicy.DeepCopyInto(&out.RestartPolicy)
+	in.CredentialPolicy.DeepCopyInto(&out.CredentialPolicy)
+	if in.AuthPriority != nil {
+		in, out := &in.AuthPriority, &out.AuthPriority
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.KubernetesOidc = in.KubernetesOidc
+	out.GcpWorkloadIdentity = in.GcpWorkloadIdentity
+	out.AzureWorkloadIdentity = in.AzureWorkloadIdentity
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretRotatorSpec.
@@ -213,6 +686,32 @@ func (in *SecretRotatorStatus) DeepCopyInto(out *SecretRotatorStatus) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.GeneratedSecrets != nil {
+		in, out := &in.GeneratedSecrets, &out.GeneratedSecrets
+		*out = make([]GeneratedSecretStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]SecretStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IssuedTokens != nil {
+		in, out := &in.IssuedTokens, &out.IssuedTokens
+		*out = make([]IssuedToken, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SupersededTokens != nil {
+		in, out := &in.SupersededTokens, &out.SupersededTokens
+		*out = make([]IssuedToken, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretRotatorStatus.
@@ -225,6 +724,29 @@ func (in *SecretRotatorStatus) DeepCopy() *SecretRotatorStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretStatus) DeepCopyInto(out *SecretStatus) {
+	*out = *in
+	if in.LastRotationTime != nil {
+		in, out := &in.LastRotationTime, &out.LastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.TokenExpiresAt != nil {
+		in, out := &in.TokenExpiresAt, &out.TokenExpiresAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretStatus.
+func (in *SecretStatus) DeepCopy() *SecretStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecurityDetails) DeepCopyInto(out *SecurityDetails) {
 	*out = *in
@@ -254,3 +776,48 @@ func (in *ServiceAccountDetails) DeepCopy() *ServiceAccountDetails {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceAccountSelector) DeepCopyInto(out *ServiceAccountSelector) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceAccountSelector.
+func (in *ServiceAccountSelector) DeepCopy() *ServiceAccountSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceAccountSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TokenRevocationDetails) DeepCopyInto(out *TokenRevocationDetails) {
+	*out = *in
+	if in.GracePeriod != nil {
+		in, out := &in.GracePeriod, &out.GracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TokenRevocationDetails.
+func (in *TokenRevocationDetails) DeepCopy() *TokenRevocationDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(TokenRevocationDetails)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/handler"
+	"artifactory-secrets-rotator/internal/metrics"
+	"artifactory-secrets-rotator/internal/operations"
+	"artifactory-secrets-rotator/internal/resource"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ReviewCredentials returns the ArtifactoryCredentials referencing the SecretRotator, split into the ones approved by its
+// credentialPolicy and the denied ones with the reason
+func (r *SecretRotatorReconciler) ReviewCredentials(ctx context.Context, secretRotator *v1alpha1.SecretRotator) ([]v1alpha1.ArtifactoryCredential, map[*v1alpha1.ArtifactoryCredential]error, error) {
+	approved := []v1alpha1.ArtifactoryCredential{}
+	denied := map[*v1alpha1.ArtifactoryCredential]error{}
+	if !r.credentialsEnabled {
+		return approved, denied, nil
+	}
+
+	credentials := &v1alpha1.ArtifactoryCredentialList{}
+	if err := r.List(ctx, credentials); err != nil {
+		return nil, nil, &operations.ReconcileError{Message: "Failed to list ArtifactoryCredentials", Cause: err}
+	}
+	for i := range credentials.Items {
+		credential := &credentials.Items[i]
+		if !operations.ReferencesSecretRotator(credential, secretRotator) || credential.GetDeletionTimestamp() != nil {
+			continue
+		}
+		namespace := &corev1.Namespace{}
+		if err := r.Get(ctx, types.NamespacedName{Name: credential.Namespace}, namespace); err != nil {
+			denied[credential] = fmt.Errorf("failed to get namespace %s: %w", credential.Namespace, err)
+			continue
+		}
+		if err := operations.ValidateCredentialRequest(secretRotator, credential, namespace); err != nil {
+			denied[credential] = err
+			continue
+		}
+		approved = append(approved, *credential)
+	}
+	return approved, denied, nil
+}
+
+// ManagingCredentials writes the secrets requested by the approved ArtifactoryCredentials with the tokens issued for each of them,
+// and removes the secrets of the denied ones
+func (r *SecretRotatorReconciler) ManagingCredentials(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *v1alpha1.SecretRotator, denied map[*v1alpha1.ArtifactoryCredential]error) error {
+	logger := log.FromContext(ctx)
+
+	if len(tokenDetails.Credentials) > 0 {
+		if err := handler.HandlingToken(ctx, tokenDetails, secretRotator, r.Recorder, r.Client); err != nil {
+			return err
+		}
+	}
+
+	for i := range tokenDetails.Credentials {
+		credential := &tokenDetails.Credentials[i]
+		// the tokens of the SecretRotator were issued, only this ArtifactoryCredential fails
+		if err := tokenDetails.CredentialErrors[operations.CredentialKey(credential)]; err != nil {
+			r.DenyCredential(ctx, secretRotator, credential, "TokenIssuanceFailed", err)
+			continue
+		}
+		secretStatuses := []v1alpha1.SecretStatus{}
+		failedSecrets := []string{}
+		for _, gSecret := range credential.Spec.GeneratedSecrets {
+			err := resource.CreateOrUpdateCredentialSecret(ctx, tokenDetails, secretRotator, credential, r.Client, r.Scheme, gSecret)
+			secretStatuses = append(secretStatuses, resource.NewCredentialSecretStatus(tokenDetails, credential, gSecret, err))
+			if err != nil {
+				logger.Error(err, "Failed to create or update secret of ArtifactoryCredential", "artifactoryCredential", credential.Name, "secret", gSecret.SecretName, "namespace", credential.Namespace)
+				// the ArtifactoryCredential holds its own tokens, its failures do not hold back the revocation of the SecretRotator tokens
+				metrics.SecretWritesTotal.WithLabelValues(credential.Namespace, metrics.ResultFailure).Inc()
+				failedSecrets = append(failedSecrets, fmt.Sprintf("%s (%s): %s", gSecret.SecretName, gSecret.SecretType, err))
+				continue
+			}
+			metrics.SecretWritesTotal.WithLabelValues(credential.Namespace, metrics.ResultSuccess).Inc()
+			tokenDetails.RotatedSecrets[credential.Namespace] = append(tokenDetails.RotatedSecrets[credential.Namespace], gSecret.SecretName)
+		}
+
+		// Delete the secrets removed from the ArtifactoryCredential
+		for _, previous := range credential.Status.Secrets {
+			if slices.ContainsFunc(credential.Spec.GeneratedSecrets, func(gSecret v1alpha1.GeneratedSecret) bool { return gSecret.SecretName == previous.SecretName }) {
+				continue
+			}
+			if err := resource.DeleteCredentialSecret(ctx, credential, secretRotator.Name, previous.SecretName, r.Client); err != nil {
+				logger.Error(err, "Unable to delete outdated secret of ArtifactoryCredential", "artifactoryCredential", credential.Name, "secret", previous.SecretName, "namespace", credential.Namespace)
+				secretStatuses = append(secretStatuses, v1alpha1.SecretStatus{Namespace: credential.Namespace, SecretName: previous.SecretName, LastError: err.Error()})
+			}
+		}
+
+		credential.Status.Secrets = secretStatuses
+		meta.SetStatusCondition(&credential.Status.Conditions, metav1.Condition{Type: operations.TypeApprovedArtifactoryCredential,
+			Status: metav1.ConditionTrue, Reason: "PolicyApproved", ObservedGeneration: credential.Generation,
+			Message: fmt.Sprintf("Approved by the credentialPolicy of SecretRotator %s", secretRotator.Name)})
+		available := metav1.Condition{Type: operations.TypeAvailableArtifactoryCredential,
+			Status: metav1.ConditionTrue, Reason: "Reconciling", ObservedGeneration: credential.Generation,
+			Message: "Secrets managed successfully"}
+		if len(failedSecrets) > 0 {
+			available.Status, available.Reason = metav1.ConditionFalse, "SecretsFailed"
+			available.Message = fmt.Sprintf("Unable to manage secrets %s", strings.Join(failedSecrets, ", "))
+		}
+		meta.SetStatusCondition(&credential.Status.Conditions, available)
+		now := metav1.Now()
+		handler.TrackCredentialTokens(tokenDetails, secretRotator, credential, len(failedSecrets) > 0, now)
+		handler.RevokeSupersededCredentialTokens(ctx, tokenDetails, secretRotator, credential, r.Recorder, now)
+		if err := r.Status().Update(ctx, credential); err != nil {
+			logger.Error(err, "Failed to update ArtifactoryCredential status", "artifactoryCredential", credential.Name, "namespace", credential.Namespace)
+		}
+	}
+
+	for credential, err := range denied {
+		r.DenyCredential(ctx, secretRotator, credential, "PolicyDenied", err)
+	}
+	return nil
+}
+
+// DenyCredential deletes the secrets written for an ArtifactoryCredential the credentialPolicy no longer approves,
+// or whose tokens Artifactory refused to issue, and reports the reason
+func (r *SecretRotatorReconciler) DenyCredential(ctx context.Context, secretRotator *v1alpha1.SecretRotator, credential *v1alpha1.ArtifactoryCredential, reason string, cause error) {
+	logger := log.FromContext(ctx)
+	logger.Info("ArtifactoryCredential denied", "artifactoryCredential", credential.Name, "namespace", credential.Namespace, "reason", reason, "message", cause.Error())
+
+	secretStatuses := []v1alpha1.SecretStatus{}
+	for _, previous := range credential.Status.Secrets {
+		if err := resource.DeleteCredentialSecret(ctx, credential, secretRotator.Name, previous.SecretName, r.Client); err != nil {
+			logger.Error(err, "Unable to delete secret of denied ArtifactoryCredential", "artifactoryCredential", credential.Name, "secret", previous.SecretName, "namespace", credential.Namespace)
+			secretStatuses = append(secretStatuses, v1alpha1.SecretStatus{Namespace: credential.Namespace, SecretName: previous.SecretName, LastError: err.Error()})
+		}
+	}
+
+	credential.Status.Secrets = secretStatuses
+	meta.SetStatusCondition(&credential.Status.Conditions, metav1.Condition{Type: operations.TypeApprovedArtifactoryCredential,
+		Status: metav1.ConditionFalse, Reason: reason, ObservedGeneration: credential.Generation, Message: cause.Error()})
+	meta.SetStatusCondition(&credential.Status.Conditions, metav1.Condition{Type: operations.TypeAvailableArtifactoryCredential,
+		Status: metav1.ConditionFalse, Reason: reason, ObservedGeneration: credential.Generation,
+		Message: "No secrets are written for a denied ArtifactoryCredential"})
+	if err := r.Status().Update(ctx, credential); err != nil {
+		logger.Error(err, "Failed to update ArtifactoryCredential status", "artifactoryCredential", credential.Name, "namespace", credential.Namespace)
+	}
+	r.Recorder.Eventf(credential, "Warning", reason, "%s", cause)
+}
+
+// credentialsWithTokens returns the ArtifactoryCredentials referencing the SecretRotator whose status records issued or superseded tokens,
+// including the ones being deleted
+func (r *SecretRotatorReconciler) credentialsWithTokens(ctx context.Context, secretRotator *v1alpha1.SecretRotator) ([]*v1alpha1.ArtifactoryCredential, error) {
+	referencing := []*v1alpha1.ArtifactoryCredential{}
+	if !r.credentialsEnabled {
+		return referencing, nil
+	}
+	credentials := &v1alpha1.ArtifactoryCredentialList{}
+	if err := r.List(ctx, credentials); err != nil {
+		return nil, &operations.ReconcileError{Message: "Failed to list ArtifactoryCredentials", Cause: err}
+	}
+	for i := range credentials.Items {
+		credential := &credentials.Items[i]
+		if !operations.ReferencesSecretRotator(credential, secretRotator) {
+			continue
+		}
+		if len(credential.Status.IssuedTokens) > 0 || len(credential.Status.SupersededTokens) > 0 {
+			referencing = append(referencing, credential)
+		}
+	}
+	return referencing, nil
+}
+
+// credentialToSecretRotator enqueues the SecretRotator referenced by the ArtifactoryCredential
+func (r *SecretRotatorReconciler) credentialToSecretRotator(ctx context.Context, object client.Object) []reconcile.Request {
+	credential, ok := object.(*v1alpha1.ArtifactoryCredential)
+	if !ok {
+		return nil
+	}
+	secretRotatorRef := credential.Spec.SecretRotatorRef
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: secretRotatorRef.Namespace, Name: secretRotatorRef.Name}}}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"artifactory-secrets-rotator/internal/resource"
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// rotationRequestRetryInterval is how long a secret waits to request the reconciliation of its SecretRotator again when the requests are full
+const rotationRequestRetryInterval = 10 * time.Second
+
+// ManagedSecretReconciler restores the secrets managed by SecretRotators when they are deleted or tampered with.
+// Only the affected secret is re-rendered, from the tokens cached by the SecretRotatorReconciler, no new token is issued.
+type ManagedSecretReconciler struct {
+	client.Client
+	Log        logr.Logger
+	Scheme     *runtime.Scheme
+	TokenCache *operations.TokenCache
+	// RotationRequests requests a full reconciliation of the SecretRotator when no valid token is cached
+	RotationRequests chan<- event.GenericEvent
+}
+
+// Reconcile restores a managed secret to the state rendered by its SecretRotator
+func (r *ManagedSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx = log.IntoContext(ctx, r.Log)
+
+	secretRotators := &jfrogv1alpha1.SecretRotatorList{}
+	if err := r.List(ctx, secretRotators); err != nil {
+		return ctrl.Result{}, err
+	}
+	// the status still lists the secret as managed after it was deleted
+	secretRotator := operations.ManagingSecretRotator(secretRotators, req.Namespace, req.Name)
+	if secretRotator == nil || secretRotator.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+	// secrets removed from the spec are cleaned up by the SecretRotator reconciliation
+	gSecret, ok := operations.GeneratedSecretByName(secretRotator, req.Name)
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: req.Namespace}, namespace); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// secrets of namespaces leaving the selection are being deleted by the SecretRotator reconciliation
+	if selected, err := operations.NamespaceSelected(secretRotator, namespace); err != nil || !selected || namespace.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+
+	restored, err := resource.RestoreSecret(ctx, secretRotator, *namespace, gSecret, r.TokenCache, r.Client, r.Scheme)
+	if err != nil {
+		r.Log.Error(err, "Failed to restore secret", "namespace", req.Namespace, "secret", req.Name, "secretRotator", secretRotator.Name)
+		return ctrl.Result{}, err
+	}
+	if !restored && r.RotationRequests != nil {
+		r.Log.Info("No valid token cached to restore secret, reconciling the SecretRotator", "namespace", req.Namespace, "secret", req.Name, "secretRotator", secretRotator.Name)
+		// do not block this worker while the SecretRotator controller catches up, retry the secret instead
+		select {
+		case r.RotationRequests <- event.GenericEvent{Object: secretRotator}:
+		default:
+			r.Log.Info("SecretRotator reconciliation requests are full, retrying the secret later", "namespace", req.Namespace, "secret", req.Name, "secretRotator", secretRotator.Name)
+			return ctrl.Result{RequeueAfter: rotationRequestRetryInterval}, nil
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ManagedSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("managedsecret").
+		For(&corev1.Secret{}, builder.WithPredicates(ManagedSecretChanges())).
+		Complete(r)
+}
+
+// ManagedSecretChanges filters the events of the secrets written by SecretRotators, which carry the last rotation time annotation
+func ManagedSecretChanges() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			// the informer sync lists every secret, the SecretRotator reconciliation covers them
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return isManagedSecret(e.ObjectOld) || isManagedSecret(e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return isManagedSecret(e.Object)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// isManagedSecret checks if the secret was written by a SecretRotator, merged docker secrets carry the managed auths instead of the token annotations
+func isManagedSecret(object client.Object) bool {
+	annotations := object.GetAnnotations()
+	_, rotated := annotations[operations.LastRotationTimeAnnotation]
+	_, merged := annotations[operations.ManagedAuthsAnnotation]
+	return rotated || merged
+}
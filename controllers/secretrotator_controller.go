@@ -18,15 +18,20 @@ package controllers
 
 import (
 	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/metrics"
 	"artifactory-secrets-rotator/internal/operations"
-	"math"
+	"artifactory-secrets-rotator/internal/resource"
 	"reflect"
+	"slices"
 
 	corev1 "k8s.io/api/core/v1"
+	ctrlbuilder "sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"context"
 	"fmt"
@@ -36,7 +41,10 @@ import (
 	"k8s.io/client-go/tools/record"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -50,16 +58,29 @@ type SecretRotatorReconciler struct {
 	Scheme          *runtime.Scheme
 	Recorder        record.EventRecorder
 	RequeueInterval time.Duration
+	// Restarter restarts the workloads consuming rotated secrets according to the restart policy
+	Restarter *resource.WorkloadRestarter
+	// TokenCache holds the tokens last issued per SecretRotator, shared with the ManagedSecretReconciler
+	TokenCache *operations.TokenCache
+	// RotationRequests requests a full reconciliation of a SecretRotator, when a drifted secret can't be restored from the TokenCache
+	RotationRequests chan event.GenericEvent
+	// credentialsEnabled is set when the ArtifactoryCredential CRD is installed
+	credentialsEnabled bool
 }
 
 //+kubebuilder:rbac:groups=apps.jfrog.com,resources=secretrotators,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps.jfrog.com,resources=secretrotators/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps.jfrog.com,resources=secretrotators/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps.jfrog.com,resources=artifactorycredentials,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps.jfrog.com,resources=artifactorycredentials/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups=apps;core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps;core,resources=namespaces,verbs=get;list;watch
 //+kubebuilder:rbac:groups=apps;core,resources=pods,verbs=get
 //+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get,resourceNames=jfrog-operator-sa
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=helmrepositories;ocirepositories,verbs=get;list;patch
 //+kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=get;create,resourceNames=jfrog-operator-sa
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -85,6 +106,14 @@ func (r *SecretRotatorReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 			// If the custom resource is not found then, it usually means that it was deleted or not created
 			// In this way, we will stop the reconciliation
 			r.Log.Info("Secret rotator object not found")
+			metrics.ForgetSecretRotator(req.Namespace, req.Name)
+			deleted := &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Namespace: req.Namespace, Name: req.Name}}
+			if r.TokenCache != nil {
+				r.TokenCache.Forget(deleted)
+			}
+			if r.Restarter != nil {
+				r.Restarter.Forget(deleted)
+			}
 			r.Recorder.Event(secretRotator, "Warning", "MissingResource", fmt.Sprintf("Operator object not found, the reconciliation will not run"))
 			return r.handleError(&operations.ReconcileError{Message: "Secret rotator object not found", Cause: err})
 		}
@@ -95,9 +124,19 @@ func (r *SecretRotatorReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	// InitializeResource initializes the secret rotator object and validates specs
 	if err := r.InitializeResource(ctx, &tokenDetails, secretRotator, req); err != nil {
 		r.Recorder.Eventf(secretRotator, "Warning", "Failed in initializing resource", "%s", err)
+		// Cleanup failures keep the finalizer and are retried with their own interval
+		if secretRotator.GetDeletionTimestamp() != nil {
+			return r.handleError(err)
+		}
 		return reconcile.Result{RequeueAfter: 1 * time.Second, Requeue: true}, nil
 	}
 
+	// The finalizer operations completed, no secrets are managed for a deleted SecretRotator
+	if secretRotator.GetDeletionTimestamp() != nil {
+		r.Log.Info("SecretRotator is being deleted, the reconciliation will not run")
+		return ctrl.Result{}, nil
+	}
+
 	// ManagingSecrets is validating the desired state versus the actual state of secrets and creating or updating secrets.
 	if err := r.ManagingSecrets(ctx, &tokenDetails, secretRotator, req); err != nil {
 		r.Recorder.Eventf(secretRotator, "Warning", "Failed in managing secret", "%s", err)
@@ -110,59 +149,138 @@ func (r *SecretRotatorReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return reconcile.Result{RequeueAfter: 1 * time.Second, Requeue: true}, nil
 	}
 
-	// Use fixed interval if configured, otherwise utilize aws role max session time
-	if secretRotator.Spec.RefreshInterval == nil {
-		r.RequeueInterval = time.Duration(tokenDetails.TTLInSeconds * 0.75 * float64(time.Second))
-	} else {
-		r.RequeueInterval = secretRotator.Spec.RefreshInterval.Duration
-	}
+	// Rotate the tokens rotateBefore ahead of their expiry, or earlier if a shorter refresh interval is configured
+	r.RequeueInterval = operations.NextRotation(secretRotator, time.Duration(tokenDetails.TTLInSeconds*float64(time.Second)))
 	r.Log.Info("Reconcile completed, see you in", "next iteration", r.RequeueInterval)
 	return ctrl.Result{RequeueAfter: r.RequeueInterval}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *SecretRotatorReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&jfrogv1alpha1.SecretRotator{}).
-		WithEventFilter(WatchNsChanges(r)).
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&jfrogv1alpha1.SecretRotator{}, ctrlbuilder.WithPredicates(SecretRotatorChanges())).
 		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
-		Owns(&corev1.Namespace{}).
-		Complete(r)
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.namespaceToSecretRotators), ctrlbuilder.WithPredicates(NamespaceChanges())).
+		Watches(&corev1.ServiceAccount{}, handler.EnqueueRequestsFromMapFunc(r.serviceAccountToSecretRotators), ctrlbuilder.WithPredicates(ServiceAccountChanges()))
+	if r.RotationRequests != nil {
+		builder = builder.WatchesRawSource(source.Channel(r.RotationRequests, &handler.EnqueueRequestForObject{}))
+	}
+	// ArtifactoryCredentials are optional, the operator keeps working when their CRD is not installed
+	credentialKind := schema.GroupKind{Group: jfrogv1alpha1.GroupVersion.Group, Kind: jfrogv1alpha1.ArtifactoryCredentialKind}
+	if _, err := mgr.GetRESTMapper().RESTMapping(credentialKind, jfrogv1alpha1.GroupVersion.Version); err == nil {
+		r.credentialsEnabled = true
+		builder = builder.Watches(&jfrogv1alpha1.ArtifactoryCredential{}, handler.EnqueueRequestsFromMapFunc(r.credentialToSecretRotator),
+			ctrlbuilder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	} else {
+		r.Log.Info("ArtifactoryCredential CRD is not installed, tenant credentials are disabled", "reason", err.Error())
+	}
+	return builder.Complete(r)
 }
 
-// WatchNsChanges uses predicates for Event Filtering (namespace creation changes)
-func WatchNsChanges(r *SecretRotatorReconciler) predicate.Predicate {
-	return predicate.Funcs{
-		CreateFunc: func(e event.CreateEvent) bool {
-			if _, ok := e.Object.(*corev1.Namespace); ok {
-				secretRotators := operations.ListSecretRotatorObjects(r.Client)
-				difference := e.Object.GetCreationTimestamp().Time.Sub(time.Now())
-				for i := range secretRotators.Items {
-					if operations.IsExist(e.Object.GetLabels(), secretRotators.Items[i].Spec.NamespaceSelector.MatchLabels) && int(math.Abs(difference.Seconds())) < 20 {
-						r.Log.Info("Created new namespace with matching labels to secret rotator object, ", "Namespace name :", e.Object.GetName(), "Secret rotator name :", secretRotators.Items[i].Name)
-						if flag := operations.HandlingNamespaceEvents(r.Client, r.Log, &secretRotators.Items[i]); !flag {
-							return false
-						}
-					}
-				}
+// namespaceToSecretRotators enqueues the SecretRotators selecting the namespace or which provisioned it.
+// Label updates map both the old and the new namespace, so SecretRotators the namespace moved out of are enqueued too.
+func (r *SecretRotatorReconciler) namespaceToSecretRotators(ctx context.Context, object client.Object) []reconcile.Request {
+	namespace, ok := object.(*corev1.Namespace)
+	if !ok {
+		return nil
+	}
+	secretRotators := &jfrogv1alpha1.SecretRotatorList{}
+	if err := r.List(ctx, secretRotators); err != nil {
+		r.Log.Error(err, "Failed to list SecretRotators for namespace event", "namespace", namespace.Name)
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, secretRotator := range operations.SecretRotatorsForNamespace(secretRotators, namespace) {
+		r.Log.Info("Namespace event affects secret rotator", "namespace", namespace.Name, "secretRotator", secretRotator.Name)
+		requests = append(requests, reconcile.Request{NamespacedName: secretRotator})
+	}
+	return requests
+}
+
+// serviceAccountToSecretRotators enqueues the SecretRotators attaching docker secrets to the ServiceAccounts of the namespace,
+// directly or through an ArtifactoryCredential of the namespace, so a new ServiceAccount gets the secret without waiting for the next rotation
+func (r *SecretRotatorReconciler) serviceAccountToSecretRotators(ctx context.Context, object client.Object) []reconcile.Request {
+	serviceAccount, ok := object.(*corev1.ServiceAccount)
+	if !ok {
+		return nil
+	}
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: serviceAccount.Namespace}, namespace); err != nil {
+		r.Log.Error(err, "Failed to get namespace for service account event", "namespace", serviceAccount.Namespace, "serviceAccount", serviceAccount.Name)
+		return nil
+	}
+	secretRotators := &jfrogv1alpha1.SecretRotatorList{}
+	if err := r.List(ctx, secretRotators); err != nil {
+		r.Log.Error(err, "Failed to list SecretRotators for service account event", "namespace", serviceAccount.Namespace, "serviceAccount", serviceAccount.Name)
+		return nil
+	}
+	attaching := map[types.NamespacedName]bool{}
+	for i := range secretRotators.Items {
+		if operations.AttachesImagePullSecrets(secretRotators.Items[i].Spec.GeneratedSecrets) {
+			attaching[types.NamespacedName{Namespace: secretRotators.Items[i].Namespace, Name: secretRotators.Items[i].Name}] = true
+		}
+	}
+
+	requests := []reconcile.Request{}
+	for _, secretRotator := range operations.SecretRotatorsForNamespace(secretRotators, namespace) {
+		if attaching[secretRotator] {
+			requests = append(requests, reconcile.Request{NamespacedName: secretRotator})
+		}
+	}
+	if r.credentialsEnabled {
+		credentials := &jfrogv1alpha1.ArtifactoryCredentialList{}
+		if err := r.List(ctx, credentials, client.InNamespace(serviceAccount.Namespace)); err != nil {
+			r.Log.Error(err, "Failed to list ArtifactoryCredentials for service account event", "namespace", serviceAccount.Namespace, "serviceAccount", serviceAccount.Name)
+		}
+		for _, credential := range credentials.Items {
+			if !operations.AttachesImagePullSecrets(credential.Spec.GeneratedSecrets) {
+				continue
 			}
-			return true
+			secretRotatorRef := credential.Spec.SecretRotatorRef
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: secretRotatorRef.Namespace, Name: secretRotatorRef.Name}}
+			if !slices.Contains(requests, request) {
+				requests = append(requests, request)
+			}
+		}
+	}
+	for _, request := range requests {
+		r.Log.Info("Service account event affects secret rotator", "namespace", serviceAccount.Namespace, "serviceAccount", serviceAccount.Name, "secretRotator", request.Name)
+	}
+	return requests
+}
+
+// SecretRotatorChanges filters the SecretRotator events to spec changes and deletions. Status updates, which every
+// reconciliation writes, do not enqueue the SecretRotator again, the rotation is scheduled by the requeue interval.
+func SecretRotatorChanges() predicate.Predicate {
+	return predicate.GenerationChangedPredicate{}
+}
+
+// ServiceAccountChanges filters the ServiceAccount events which can change the ServiceAccounts docker secrets are attached to,
+// creations and label changes. Deleted ServiceAccounts take their imagePullSecrets with them.
+func ServiceAccountChanges() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
 		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// NamespaceChanges filters the namespace events which can change the namespaces selected by SecretRotators,
+// label changes and opt-out annotation changes
+func NamespaceChanges() predicate.Predicate {
+	return predicate.Funcs{
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			if _, ok := e.ObjectOld.(*corev1.Namespace); ok {
-				if !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) {
-					secretRotators := operations.ListSecretRotatorObjects(r.Client)
-					for i := range secretRotators.Items {
-						if operations.IsExist(e.ObjectNew.GetLabels(), secretRotators.Items[i].Spec.NamespaceSelector.MatchLabels) || operations.IsExist(e.ObjectOld.GetLabels(), secretRotators.Items[i].Spec.NamespaceSelector.MatchLabels) {
-							r.Log.Info("Namespace lebels has been changes, ", "Namespace name :", e.ObjectNew.GetName(), "Secret rotator name :", secretRotators.Items[i].Name)
-							if flag := operations.HandlingNamespaceEvents(r.Client, r.Log, &secretRotators.Items[i]); !flag {
-								return false
-							}
-						}
-					}
-				}
-			}
-			return true
+			return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+				e.ObjectOld.GetAnnotations()[operations.OptOutAnnotation] != e.ObjectNew.GetAnnotations()[operations.OptOutAnnotation]
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
 		},
 	}
 }
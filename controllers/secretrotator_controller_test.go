@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSecretRotatorChanges(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", Generation: 1}}
+
+	// a status update, written by every reconciliation, does not reconcile the SecretRotator again and issue new tokens
+	statusUpdate := secretRotator.DeepCopy()
+	statusUpdate.Status.IssuedTokens = []jfrogv1alpha1.IssuedToken{{TokenID: "issued", IssuedAt: metav1.Now()}}
+	assert.False(t, SecretRotatorChanges().Update(event.UpdateEvent{ObjectOld: secretRotator, ObjectNew: statusUpdate}))
+
+	specUpdate := secretRotator.DeepCopy()
+	specUpdate.Generation = 2
+	assert.True(t, SecretRotatorChanges().Update(event.UpdateEvent{ObjectOld: secretRotator, ObjectNew: specUpdate}))
+	assert.True(t, SecretRotatorChanges().Create(event.CreateEvent{Object: secretRotator}))
+}
+
+func TestCredentialsWithTokens(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, jfrogv1alpha1.AddToScheme(scheme))
+	secretRotator := &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"}}
+	newCredential := func(name, secretRotatorName string, issuedTokens ...jfrogv1alpha1.IssuedToken) *jfrogv1alpha1.ArtifactoryCredential {
+		return &jfrogv1alpha1.ArtifactoryCredential{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+			Spec:       jfrogv1alpha1.ArtifactoryCredentialSpec{SecretRotatorRef: jfrogv1alpha1.SecretRotatorReference{Name: secretRotatorName}},
+			Status:     jfrogv1alpha1.ArtifactoryCredentialStatus{IssuedTokens: issuedTokens},
+		}
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newCredential("issued", "test-rotator", jfrogv1alpha1.IssuedToken{TokenID: "tenant"}),
+		newCredential("no-tokens", "test-rotator"),
+		newCredential("other", "other-rotator", jfrogv1alpha1.IssuedToken{TokenID: "other"}),
+	).Build()
+
+	// the Delete policy only revokes the tokens recorded by the ArtifactoryCredentials of the SecretRotator
+	r := &SecretRotatorReconciler{Client: k8sClient, credentialsEnabled: true}
+	credentials, err := r.credentialsWithTokens(context.Background(), secretRotator)
+	require.NoError(t, err)
+	require.Len(t, credentials, 1)
+	assert.Equal(t, "issued", credentials[0].Name)
+
+	r.credentialsEnabled = false
+	credentials, err = r.credentialsWithTokens(context.Background(), secretRotator)
+	require.NoError(t, err)
+	assert.Empty(t, credentials)
+}
+
+func TestServiceAccountChanges(t *testing.T) {
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: "team-a"}}
+	assert.True(t, ServiceAccountChanges().Create(event.CreateEvent{Object: serviceAccount}))
+
+	// a relabelled ServiceAccount can move into the selection of a docker secret
+	relabelled := serviceAccount.DeepCopy()
+	relabelled.Labels = map[string]string{"pulls": "artifactory"}
+	assert.True(t, ServiceAccountChanges().Update(event.UpdateEvent{ObjectOld: serviceAccount, ObjectNew: relabelled}))
+
+	// the imagePullSecrets patched by the operator do not enqueue the SecretRotator again
+	patched := serviceAccount.DeepCopy()
+	patched.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "pull"}}
+	assert.False(t, ServiceAccountChanges().Update(event.UpdateEvent{ObjectOld: serviceAccount, ObjectNew: patched}))
+	assert.False(t, ServiceAccountChanges().Delete(event.DeleteEvent{Object: serviceAccount}))
+}
+
+func TestServiceAccountToSecretRotators(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, jfrogv1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	pullSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: "docker",
+		Docker: &jfrogv1alpha1.DockerSecretDetails{ServiceAccounts: &jfrogv1alpha1.ServiceAccountSelector{}}}
+	newSecretRotator := func(name string, gSecrets ...jfrogv1alpha1.GeneratedSecret) *jfrogv1alpha1.SecretRotator {
+		return &jfrogv1alpha1.SecretRotator{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: jfrogv1alpha1.SecretRotatorSpec{
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+				GeneratedSecrets:  gSecrets,
+			},
+		}
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}},
+		newSecretRotator("attaching", pullSecret),
+		newSecretRotator("not-attaching", jfrogv1alpha1.GeneratedSecret{SecretName: "token", SecretType: "generic"}),
+		newSecretRotator("tenant-rotator"),
+		&jfrogv1alpha1.ArtifactoryCredential{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "team-a"},
+			Spec: jfrogv1alpha1.ArtifactoryCredentialSpec{
+				SecretRotatorRef: jfrogv1alpha1.SecretRotatorReference{Name: "tenant-rotator"},
+				GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{pullSecret},
+			},
+		},
+	).Build()
+	r := &SecretRotatorReconciler{Client: k8sClient, Log: logr.Discard(), credentialsEnabled: true}
+
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "team-a"}}
+	assert.Equal(t, []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: "attaching"}},
+		{NamespacedName: types.NamespacedName{Name: "tenant-rotator"}},
+	}, r.serviceAccountToSecretRotators(context.Background(), serviceAccount))
+}
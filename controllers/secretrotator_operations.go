@@ -3,11 +3,14 @@ package controllers
 import (
 	"artifactory-secrets-rotator/api/v1alpha1"
 	"artifactory-secrets-rotator/internal/handler"
+	"artifactory-secrets-rotator/internal/metrics"
 	"artifactory-secrets-rotator/internal/operations"
 	"artifactory-secrets-rotator/internal/resource"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -38,6 +41,9 @@ func (r *SecretRotatorReconciler) InitializeResource(ctx context.Context, tokenD
 	if err := r.SecretRotatorChecker(ctx, secretRotator, req); err != nil {
 		return err
 	}
+	if secretRotator.GetDeletionTimestamp() != nil {
+		return nil
+	}
 
 	p := client.MergeFrom(secretRotator.DeepCopy())
 	defer r.DeferPatch(ctx, secretRotator, p)
@@ -67,6 +73,16 @@ func (r *SecretRotatorReconciler) ManagingSecrets(ctx context.Context, tokenDeta
 	skippedSecrets := make(map[string]string, 0)
 	failedSecrets := []string{}
 	tokenDetails.SecretManagedByNamespaces = make(map[string][]string)
+	tokenDetails.RotatedSecrets = make(map[string][]string)
+	// Let the workloads consuming the written secrets read the rotated token, also when a later namespace fails
+	defer r.RestartConsumers(ctx, tokenDetails, secretRotator)
+
+	// Approved ArtifactoryCredentials add their scopes to the tokens requested for this SecretRotator
+	credentials, deniedCredentials, err := r.ReviewCredentials(ctx, secretRotator)
+	if err != nil {
+		return err
+	}
+	tokenDetails.Credentials = credentials
 
 	for _, namespace := range tokenDetails.NamespaceList.Items {
 		// Iterate over generated secrets, which includes secrets from SecretRotatorSpec.SecretName (appended in ValidateObjectSpec)
@@ -75,15 +91,20 @@ func (r *SecretRotatorReconciler) ManagingSecrets(ctx context.Context, tokenDeta
 			existingSecret, err := resource.GetSecret(ctx, namespace.Name, gSecret.SecretName, r.Client)
 			if err != nil && !apierrors.IsNotFound(err) {
 				logger.Error(err, "Could not get existing secret, skipping secret", "secretType", gSecret.SecretType, "secret", gSecret.SecretName, "namespace", namespace.Name)
+				// the secret may still hold a previously issued token
+				tokenDetails.SecretsOutdated = true
 				failedSecrets = append(failedSecrets, fmt.Sprintf("%s (%s) Reason: not found, ", gSecret.SecretName, gSecret.SecretType))
 				skippedSecrets[gSecret.SecretName] = namespace.Name
+				tokenDetails.SecretStatuses = append(tokenDetails.SecretStatuses, resource.NewSecretStatus(tokenDetails, secretRotator, namespace.Name, gSecret, fmt.Errorf("could not get existing secret: %w", err)))
 				continue
 			}
 
-			if err == nil && !resource.IsSecretOwnedBy(existingSecret, secretRotator.Name) {
+			// merged docker secrets share the secret with other writers and only own their auths entries
+			if err == nil && !resource.IsSecretOwnedBy(existingSecret, secretRotator.Name) && !operations.IsMergedDockerSecret(gSecret) {
 				logger.Info("Secret is not owned by this SecretRotator, delete it manually if you want this operator to control it", "secretType", gSecret.SecretType, "secret", gSecret.SecretName, "namespace", namespace.Name)
 				failedSecrets = append(failedSecrets, fmt.Sprintf("%s (%s) Reason: not owned by secretrotator, ", gSecret.SecretName, gSecret.SecretType))
 				skippedSecrets[gSecret.SecretName] = namespace.Name
+				tokenDetails.SecretStatuses = append(tokenDetails.SecretStatuses, resource.NewSecretStatus(tokenDetails, secretRotator, namespace.Name, gSecret, errors.New("secret is not owned by this SecretRotator")))
 				continue
 			}
 		}
@@ -92,6 +113,10 @@ func (r *SecretRotatorReconciler) ManagingSecrets(ctx context.Context, tokenDeta
 		if err := handler.HandlingToken(ctx, tokenDetails, secretRotator, r.Recorder, r.Client); err != nil {
 			return err
 		}
+		// Keep the issued tokens to restore drifted secrets until the next rotation
+		if r.TokenCache != nil {
+			r.TokenCache.Store(secretRotator, tokenDetails)
+		}
 
 		// Create or update secrets
 		for _, gSecret := range tokenDetails.GeneratedSecrets {
@@ -99,17 +124,23 @@ func (r *SecretRotatorReconciler) ManagingSecrets(ctx context.Context, tokenDeta
 			if isExist || value == namespace.Name {
 				continue
 			}
-			if err, isCrossOwnershipConflict := resource.CreateOrUpdateSecrets(req, ctx, tokenDetails, secretRotator, namespace, r.Client, r.Scheme, gSecret.SecretName, gSecret.SecretType); err != nil {
+			err, isCrossOwnershipConflict := resource.CreateOrUpdateSecrets(req, ctx, tokenDetails, secretRotator, namespace, r.Client, r.Scheme, gSecret)
+			tokenDetails.SecretStatuses = append(tokenDetails.SecretStatuses, resource.NewSecretStatus(tokenDetails, secretRotator, namespace.Name, gSecret, err))
+			if err != nil {
 				// Handle cross-namespace owner reference conflict separately
 				if isCrossOwnershipConflict {
 					logger.Info("Skipping Secret", "secret type", gSecret.SecretType, "secret name", gSecret.SecretName, "namespace", namespace.Name, "error", err, "Reason", "cross-namespace owner references are disallowed. Verify the installation scope and namespace selectors")
 					failedSecrets = append(failedSecrets, fmt.Sprintf(" Skipping secret %s: namespace is out of scope. Verify the installation scope and namespace selectors", gSecret.SecretName))
 				} else {
 					logger.Error(err, " Failed to create or update secret", "secretType", gSecret.SecretType, "secret", gSecret.SecretName, "namespace", namespace.Name)
+					metrics.SecretWritesTotal.WithLabelValues(namespace.Name, metrics.ResultFailure).Inc()
+					tokenDetails.SecretsOutdated = true
 					failedSecrets = append(failedSecrets, fmt.Sprintf("%s (%s) Reason: failed in create/update", gSecret.SecretName, gSecret.SecretType))
 				}
 				continue
 			}
+			metrics.SecretWritesTotal.WithLabelValues(namespace.Name, metrics.ResultSuccess).Inc()
+			tokenDetails.RotatedSecrets[namespace.Name] = append(tokenDetails.RotatedSecrets[namespace.Name], gSecret.SecretName)
 			tokenDetails.SecretManagedByNamespaces[namespace.Name] = append(tokenDetails.SecretManagedByNamespaces[namespace.Name], gSecret.SecretName)
 		}
 		if len(failedSecrets) > 0 {
@@ -131,31 +162,36 @@ func (r *SecretRotatorReconciler) ManagingSecrets(ctx context.Context, tokenDeta
 		logger.Info("Successfully managed secrets for namespace", "namespace", namespace.Name)
 	}
 
-	return nil
+	return r.ManagingCredentials(ctx, tokenDetails, secretRotator, deniedCredentials)
+}
+
+// RestartConsumers queues one restart of every workload consuming the secrets written by the rotation
+func (r *SecretRotatorReconciler) RestartConsumers(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *v1alpha1.SecretRotator) {
+	if r.Restarter == nil {
+		return
+	}
+	logger := log.FromContext(ctx)
+	for _, namespace := range slices.Sorted(maps.Keys(tokenDetails.RotatedSecrets)) {
+		if err := r.Restarter.RestartConsumers(ctx, secretRotator, namespace, tokenDetails.RotatedSecrets[namespace]); err != nil {
+			logger.Error(err, "Unable to restart the workloads consuming the secrets", "secrets", tokenDetails.RotatedSecrets[namespace], "namespace", namespace)
+		}
+	}
 }
 
 // UpdateStatus updates the custom resource status
 func (r *SecretRotatorReconciler) UpdateStatus(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *v1alpha1.SecretRotator) error {
-	// Collect docker and generic secret names
-	var dockerSecretNames []string
-	var genericSecretNames []string
+	// Collect the secret names by secret type
+	secretNamesByType := map[string][]string{}
 	secretNames := []string{}
 
 	for _, gSecret := range tokenDetails.GeneratedSecrets {
-		if gSecret.SecretType == operations.SecretTypeDocker {
-			dockerSecretNames = append(dockerSecretNames, gSecret.SecretName)
-		} else if gSecret.SecretType == operations.SecretTypeGeneric {
-			genericSecretNames = append(genericSecretNames, gSecret.SecretName)
-		}
+		secretNamesByType[gSecret.SecretType] = append(secretNamesByType[gSecret.SecretType], gSecret.SecretName)
 	}
 
-	if len(dockerSecretNames) != 0 {
-		secretNames = append(secretNames, fmt.Sprintf("%s (docker)", dockerSecretNames))
-
-	}
-	if len(genericSecretNames) != 0 {
-		secretNames = append(secretNames, fmt.Sprintf("%s (generic)", genericSecretNames))
-
+	for _, secretType := range operations.SupportedSecretTypes {
+		if len(secretNamesByType[secretType]) != 0 {
+			secretNames = append(secretNames, fmt.Sprintf("%s (%s)", secretNamesByType[secretType], secretType))
+		}
 	}
 
 	// Update the status after reconciliation completed
@@ -178,6 +214,31 @@ func (r *SecretRotatorReconciler) UpdateStatus(ctx context.Context, tokenDetails
 	secretRotator.Status.ProvisionedNamespaces = tokenDetails.ProvisionedNamespaces
 	secretRotator.Status.SecretManagedByNamespaces = tokenDetails.SecretManagedByNamespaces
 
+	// Report the scope each generated secret's token was issued with
+	secretRotator.Status.GeneratedSecrets = []v1alpha1.GeneratedSecretStatus{}
+	for _, gSecret := range tokenDetails.GeneratedSecrets {
+		secretRotator.Status.GeneratedSecrets = append(secretRotator.Status.GeneratedSecrets, v1alpha1.GeneratedSecretStatus{
+			SecretName: gSecret.SecretName,
+			SecretType: gSecret.SecretType,
+			Scope:      tokenDetails.IssuedScope(gSecret.Scope),
+		})
+	}
+
+	// Report the token each generated secret holds, per namespace
+	sort.Slice(tokenDetails.SecretStatuses, func(i, j int) bool {
+		if tokenDetails.SecretStatuses[i].Namespace != tokenDetails.SecretStatuses[j].Namespace {
+			return tokenDetails.SecretStatuses[i].Namespace < tokenDetails.SecretStatuses[j].Namespace
+		}
+		return tokenDetails.SecretStatuses[i].SecretName < tokenDetails.SecretStatuses[j].SecretName
+	})
+	secretRotator.Status.Secrets = tokenDetails.SecretStatuses
+
+	// Record the issued tokens and revoke the tokens superseded by this rotation once their grace period passed
+	now := metav1.Now()
+	handler.TrackIssuedTokens(tokenDetails, secretRotator, now)
+	reportTokenExpiry(tokenDetails, secretRotator)
+	handler.RevokeSupersededTokens(ctx, tokenDetails, secretRotator, r.Recorder, now)
+
 	// Update status for resource
 	if err := r.Status().Update(ctx, secretRotator); err != nil {
 		return &operations.ReconcileError{Message: "Failed to update SecretRotator status", Cause: err, RetryIn: 1 * time.Minute}
@@ -187,6 +248,19 @@ func (r *SecretRotatorReconciler) UpdateStatus(ctx context.Context, tokenDetails
 	return nil
 }
 
+// reportTokenExpiry reports when the earliest expiring token issued for the SecretRotator expires
+func reportTokenExpiry(tokenDetails *operations.TokenDetails, secretRotator *v1alpha1.SecretRotator) {
+	var earliest *metav1.Time
+	for _, accessToken := range tokenDetails.Tokens {
+		if expiresAt := tokenDetails.TokenExpiresAt(accessToken); expiresAt != nil && (earliest == nil || expiresAt.Before(earliest)) {
+			earliest = expiresAt
+		}
+	}
+	if earliest != nil {
+		metrics.SetTokenExpiry(secretRotator.Namespace, secretRotator.Name, earliest.Time)
+	}
+}
+
 // HandleConditions handles kubernetes conditions for secret rotator object
 func (r *SecretRotatorReconciler) HandleConditions(ctx context.Context, secretRotator *v1alpha1.SecretRotator, req ctrl.Request) error {
 	var err error
@@ -241,8 +315,16 @@ func (r *SecretRotatorReconciler) SecretRotatorChecker(ctx context.Context, secr
 				return &operations.ReconcileError{Message: "Failed to update SecretRotator status", Cause: err}
 			}
 
-			// Perform finalizer operations
-			r.DoFinalizerOperationsForSecretRotator(secretRotator)
+			// Perform finalizer operations, the finalizer is kept until the deletion policy was enforced
+			if err := r.DoFinalizerOperationsForSecretRotator(ctx, secretRotator); err != nil {
+				meta.SetStatusCondition(&secretRotator.Status.Conditions, metav1.Condition{Type: operations.TypeCleanedUpSecretRotator,
+					Status: metav1.ConditionFalse, Reason: "CleanupFailed",
+					Message: fmt.Sprintf("Failed to enforce deletion policy %s, the finalizer is kept until cleanup succeeds: %s", deletionPolicy(secretRotator), err.Error())})
+				if updateErr := r.Status().Update(ctx, secretRotator); updateErr != nil {
+					logger.Error(updateErr, "Failed to update SecretRotator status")
+				}
+				return &operations.ReconcileError{Message: "Failed to enforce the deletion policy, the finalizer is kept until cleanup succeeds", Cause: err, RetryIn: 1 * time.Minute}
+			}
 
 			// Re-fetch the Custom Resource to avoid conflicts
 			if err := r.Get(ctx, req.NamespacedName, secretRotator); err != nil {
@@ -252,11 +334,21 @@ func (r *SecretRotatorReconciler) SecretRotatorChecker(ctx context.Context, secr
 			meta.SetStatusCondition(&secretRotator.Status.Conditions, metav1.Condition{Type: operations.TypeDegradedSecretRotator,
 				Status: metav1.ConditionTrue, Reason: "Finalizing",
 				Message: fmt.Sprintf("Finalizer operations for custom resource %s name were successfully accomplished", secretRotator.Name)})
+			meta.SetStatusCondition(&secretRotator.Status.Conditions, metav1.Condition{Type: operations.TypeCleanedUpSecretRotator,
+				Status: metav1.ConditionTrue, Reason: "Finalizing",
+				Message: fmt.Sprintf("Deletion policy %s was enforced", deletionPolicy(secretRotator))})
 
 			if err := r.Status().Update(ctx, secretRotator); err != nil {
 				return &operations.ReconcileError{Message: "Failed to update SecretRotator status", Cause: err}
 			}
 
+			metrics.ForgetSecretRotator(secretRotator.Namespace, secretRotator.Name)
+			if r.TokenCache != nil {
+				r.TokenCache.Forget(secretRotator)
+			}
+			if r.Restarter != nil {
+				r.Restarter.Forget(secretRotator)
+			}
 			r.Log.Info("Removing Finalizer for SecretRotator after successfully performing the operations")
 			if ok := controllerutil.RemoveFinalizer(secretRotator, operations.SecretRotatorFinalizer); !ok {
 				return &operations.ReconcileError{Message: "Failed to remove finalizer for SecretRotator", Cause: err}
@@ -277,9 +369,73 @@ func (r *SecretRotatorReconciler) DeferPatch(ctx context.Context, secretRotator
 	}
 }
 
-// DoFinalizerOperationsForSecretRotator updates k8s event
-func (r *SecretRotatorReconciler) DoFinalizerOperationsForSecretRotator(secretRotator *v1alpha1.SecretRotator) {
+// DoFinalizerOperationsForSecretRotator enforces the deletion policy on the generated secrets and the issued tokens
+func (r *SecretRotatorReconciler) DoFinalizerOperationsForSecretRotator(ctx context.Context, secretRotator *v1alpha1.SecretRotator) error {
+	logger := log.FromContext(ctx)
 	r.Recorder.Event(secretRotator, "Warning", "Deleting", fmt.Sprintf("Custom Resource %s is being deleted from the namespace %s", secretRotator.Name, secretRotator.Namespace))
+
+	var errs []error
+	switch deletionPolicy(secretRotator) {
+	case operations.DeletionPolicyRetain:
+		logger.Info("Retaining generated secrets and issued tokens")
+		return nil
+	case operations.DeletionPolicyOrphan:
+		logger.Info("Orphaning generated secrets")
+		errs = resource.OrphanManagedSecrets(ctx, secretRotator, r.Client)
+	default:
+		logger.Info("Deleting generated secrets and revoking issued tokens")
+		errs = resource.DeleteManagedSecrets(ctx, secretRotator, r.Client)
+		if err := r.RevokeLiveTokens(ctx, secretRotator); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RevokeLiveTokens issues a token for every scope of the live tokens through the configured identity provider and uses it to revoke them
+func (r *SecretRotatorReconciler) RevokeLiveTokens(ctx context.Context, secretRotator *v1alpha1.SecretRotator) error {
+	now := metav1.Now()
+	credentials, err := r.credentialsWithTokens(ctx, secretRotator)
+	if err != nil {
+		return err
+	}
+	scopes := handler.LiveTokenScopes(secretRotator, credentials, now)
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl:   operations.ArtifactoryHost(secretRotator.Spec.ArtifactoryUrl),
+		IAMRoleAwsRegion: secretRotator.Spec.AwsRegion,
+	}
+	if tokenDetails.IAMRoleAwsRegion == "" {
+		tokenDetails.IAMRoleAwsRegion = operations.AwsRegion
+	}
+	for _, scope := range scopes {
+		tokenDetails.GeneratedSecrets = append(tokenDetails.GeneratedSecrets, v1alpha1.GeneratedSecret{Scope: scope})
+	}
+	if _, err := operations.GetServiceAccount(ctx, r.Client, tokenDetails); err != nil {
+		return &operations.ReconcileError{Message: "Error reading operator's service account resource, the issued tokens could not be revoked", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	if err := handler.HandlingToken(ctx, tokenDetails, secretRotator, r.Recorder, r.Client); err != nil {
+		return err
+	}
+	revokeErr := handler.RevokeIssuedTokens(ctx, tokenDetails, secretRotator, credentials, r.Recorder, now)
+	// the ArtifactoryCredentials keep the tokens that could not be revoked for the retry
+	for _, credential := range credentials {
+		if err := r.Status().Update(ctx, credential); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to update ArtifactoryCredential status", "artifactoryCredential", credential.Name, "namespace", credential.Namespace)
+		}
+	}
+	return revokeErr
+}
+
+// deletionPolicy returns the deletion policy of the SecretRotator, defaults to Delete
+func deletionPolicy(secretRotator *v1alpha1.SecretRotator) string {
+	if secretRotator.Spec.DeletionPolicy == "" {
+		return operations.DeletionPolicyDelete
+	}
+	return secretRotator.Spec.DeletionPolicy
 }
 
 // handleError converts an error into reconcile result
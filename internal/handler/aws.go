@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"artifactory-secrets-rotator/internal/metrics"
 	"artifactory-secrets-rotator/internal/operations"
 	controllers2 "artifactory-secrets-rotator/internal/sign"
 	"context"
@@ -133,7 +134,9 @@ func GetSignedRequestAndHandleRoleMaxSession(ctx context.Context, roleArn string
 		}))
 
 	// creating credentials cache, this is needed to get the credentials for the role ARN
+	start := time.Now()
 	credentials, err := appCreds.Retrieve(ctx)
+	metrics.ObserveRequest(metrics.ServiceSts, "assume_role_with_web_identity", start, err != nil)
 	if err != nil {
 		return nil, &operations.ReconcileError{Message: "Got error on appCreds.Retrieve", Cause: err, RetryIn: 1 * time.Minute}
 	}
@@ -164,3 +167,16 @@ func GetSignedRequestAndHandleRoleMaxSession(ctx context.Context, roleArn string
 
 	return req, nil
 }
+
+// awsProvider exchanges a signed STS GetCallerIdentity request at Artifactory's AWS token endpoint, shared by the AWS identity providers
+type awsProvider struct{}
+
+// Exchange creates a JFrog access token with the requested scope from the signed request
+func (awsProvider) Exchange(ctx context.Context, request *ProviderRequest, credential *Credential, scope string) (*operations.AccessResponse, error) {
+	return createArtifactoryToken(ctx, credential.SignedRequest, request.TokenDetails.ArtifactoryUrl, credential.ExpiresIn, scope, &request.SecretRotator.Spec.Security, request.SecretRotator.Name)
+}
+
+// TTL returns the requested token lifetime, taken from the IAM role max session duration
+func (awsProvider) TTL(credential *Credential, _ *operations.AccessResponse) float64 {
+	return float64(*credential.ExpiresIn)
+}
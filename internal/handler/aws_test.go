@@ -0,0 +1,133 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const testRoleARN = "arn:aws:iam::123456789012:role/artifactory"
+
+// newFakeAws returns a server answering the STS and IAM query API calls made while signing requests, and points the AWS SDK at it
+func newFakeAws(t *testing.T, webIdentityTokens *[]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		w.Header().Set("Content-Type", "text/xml")
+		switch r.PostForm.Get("Action") {
+		case "AssumeRoleWithWebIdentity":
+			assert.Equal(t, testRoleARN, r.PostForm.Get("RoleArn"))
+			*webIdentityTokens = append(*webIdentityTokens, r.PostForm.Get("WebIdentityToken"))
+			_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleWithWebIdentityResult><Credentials><AccessKeyId>ASIAWEBIDENTITY</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+		case "GetCallerIdentity":
+			_, _ = w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetCallerIdentityResult><Arn>arn:aws:sts::123456789012:assumed-role/artifactory/eks-pod-identity</Arn><UserId>AROA:eks-pod-identity</UserId><Account>123456789012</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`))
+		case "GetRole":
+			assert.Equal(t, "artifactory", r.PostForm.Get("RoleName"))
+			_, _ = w.Write([]byte(`<GetRoleResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/"><GetRoleResult><Role><Path>/</Path><RoleName>artifactory</RoleName><RoleId>AROA</RoleId><Arn>arn:aws:iam::123456789012:role/artifactory</Arn><CreateDate>2024-01-01T00:00:00Z</CreateDate><MaxSessionDuration>7200</MaxSessionDuration></Role></GetRoleResult></GetRoleResponse>`))
+		default:
+			t.Errorf("unexpected AWS action %s", r.PostForm.Get("Action"))
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	return server
+}
+
+// newFakeArtifactoryAws returns an Artifactory serving the AWS token endpoint, recording the requests it received
+func newFakeArtifactoryAws(t *testing.T, requests *[]operations.AccessRequest) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, tokenEndpoint, r.URL.Path)
+		assert.Contains(t, r.Header.Get("Authorization"), "AWS4-ECDSA-P256-SHA256")
+		var accessRequest operations.AccessRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&accessRequest))
+		*requests = append(*requests, accessRequest)
+		_ = json.NewEncoder(w).Encode(operations.AccessResponse{TokenId: "id-" + accessRequest.Scope, AccessToken: "aws-token", Username: "artifactory", ExpiresIn: int(accessRequest.ExpiresIn), Scope: accessRequest.Scope})
+	}))
+}
+
+func newAwsSecretRotator(artifactoryUrl, authType string) *jfrogv1alpha1.SecretRotator {
+	return &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			ArtifactoryUrl: artifactoryUrl,
+			AuthType:       authType,
+			ServiceAccount: jfrogv1alpha1.ServiceAccountDetails{Name: "jfrog-operator-sa", Namespace: "jfrog-operator"},
+			Security:       jfrogv1alpha1.SecurityDetails{Enabled: true, InsecureSkipVerify: true},
+		},
+	}
+}
+
+func TestPodIdentityProvider_Success(t *testing.T) {
+	newFakeAws(t, &[]string{})
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "pod-identity-jwt", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(operations.CredentialsResponse{AccessKeyId: "ASIAPODIDENTITY", SecretAccessKey: "secret", Token: "session"})
+	}))
+	defer agent.Close()
+	tokenFile := filepath.Join(t.TempDir(), "eks-pod-identity-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("pod-identity-jwt"), 0o600))
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", agent.URL)
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+
+	var requests []operations.AccessRequest
+	artifactory := newFakeArtifactoryAws(t, &requests)
+	defer artifactory.Close()
+
+	secretRotator := newAwsSecretRotator(strings.TrimPrefix(artifactory.URL, "https://"), operations.AutoAuthType)
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl:   secretRotator.Spec.ArtifactoryUrl,
+		GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}, {SecretName: "deploy", SecretType: operations.SecretTypeGeneric, Scope: "applied-permissions/groups:deployers"}},
+	}
+
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, ServiceAccount: &corev1.ServiceAccount{}, Recorder: record.NewFakeRecorder(10)})
+	require.NoError(t, err)
+
+	assert.Equal(t, operations.PodIdentityAuthType, tokenDetails.AuthType)
+	assert.Equal(t, []operations.AccessRequest{{ExpiresIn: 7200}, {ExpiresIn: 7200, Scope: "applied-permissions/groups:deployers"}}, requests)
+	assert.Equal(t, float64(7200), tokenDetails.TTLInSeconds)
+	assert.Equal(t, "id-applied-permissions/groups:deployers", tokenDetails.TokenForScope("applied-permissions/groups:deployers").TokenId)
+}
+
+func TestWebIdentityProvider_Success(t *testing.T) {
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "")
+	var webIdentityTokens []string
+	newFakeAws(t, &webIdentityTokens)
+
+	var requests []operations.AccessRequest
+	artifactory := newFakeArtifactoryAws(t, &requests)
+	defer artifactory.Close()
+
+	secretRotator := newAwsSecretRotator(strings.TrimPrefix(artifactory.URL, "https://"), operations.WebIdentityAuthType)
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "jfrog-operator-sa", Namespace: "jfrog-operator", Annotations: map[string]string{operations.RoleARNKey: testRoleARN}}}
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl:   secretRotator.Spec.ArtifactoryUrl,
+		IAMRoleAwsRegion: "us-east-1",
+		GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}},
+	}
+
+	var audiences []string
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, ServiceAccount: serviceAccount, Recorder: record.NewFakeRecorder(10), Clientset: newFakeClientsetWithToken("sa-jwt", &audiences)})
+	require.NoError(t, err)
+
+	assert.Equal(t, operations.WebIdentityAuthType, tokenDetails.AuthType)
+	assert.Equal(t, []string{operations.AmazonAwsSts}, audiences)
+	assert.Equal(t, []string{"sa-jwt"}, webIdentityTokens)
+	assert.Equal(t, []operations.AccessRequest{{ExpiresIn: 7200}}, requests)
+	assert.Equal(t, "aws-token", tokenDetails.TokenForScope("").AccessToken)
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/metrics"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// azureAuthorityHost is the Microsoft Entra ID endpoint, a variable so it can be pointed at a local fake server
+var azureAuthorityHost = "https://login.microsoftonline.com/"
+
+const (
+	// Environment variables injected by the Azure Workload Identity webhook
+	azureFederatedTokenFileEnv = "AZURE_FEDERATED_TOKEN_FILE"
+	azureAuthorityHostEnv      = "AZURE_AUTHORITY_HOST"
+	azureClientIDEnv           = "AZURE_CLIENT_ID"
+	azureTenantIDEnv           = "AZURE_TENANT_ID"
+	azureClientAssertionType   = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// azureTokenResponse is the response of the Microsoft Entra ID token endpoint
+type azureTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+func init() {
+	RegisterIdentityProvider(&azureWorkloadIdentityProvider{})
+}
+
+// azureWorkloadIdentityProvider exchanges the federated ServiceAccount token for a Microsoft Entra ID token
+// and exchanges that through Artifactory's OIDC integration.
+type azureWorkloadIdentityProvider struct {
+	oidcProvider
+}
+
+// Name returns the azureWorkloadIdentity auth type
+func (*azureWorkloadIdentityProvider) Name() string {
+	return operations.AzureWorkloadIdentityAuthType
+}
+
+// Detect reports whether spec.azureWorkloadIdentity.providerName is configured
+func (*azureWorkloadIdentityProvider) Detect(_ context.Context, request *ProviderRequest) bool {
+	return request.SecretRotator.Spec.AzureWorkloadIdentity.ProviderName != ""
+}
+
+// ObtainCredential obtains a Microsoft Entra ID token for the federated identity
+func (*azureWorkloadIdentityProvider) ObtainCredential(ctx context.Context, request *ProviderRequest) (*Credential, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("Using Azure Workload Identity flow - exchanging Microsoft Entra ID token with Artifactory")
+
+	secretRotator, recorder := request.SecretRotator, request.Recorder
+
+	azureDetails := secretRotator.Spec.AzureWorkloadIdentity
+	if azureDetails.ProviderName == "" {
+		recorder.Eventf(secretRotator, "Warning", "Misconfiguration", "spec.azureWorkloadIdentity.providerName is required for the azureWorkloadIdentity auth type")
+		return nil, &operations.ReconcileError{Message: "Missing spec.azureWorkloadIdentity.providerName for the azureWorkloadIdentity auth type, the current reconciliation cycle will end here"}
+	}
+
+	// The webhook injected environment only describes the operator's own service account
+	isOperatorServiceAccount := request.usesOperatorServiceAccount()
+	clientID := resolveAzureSetting(azureDetails.ClientID, request.ServiceAccount, operations.AzureClientIDKey, azureClientIDEnv, isOperatorServiceAccount)
+	tenantID := resolveAzureSetting(azureDetails.TenantID, request.ServiceAccount, operations.AzureTenantIDKey, azureTenantIDEnv, isOperatorServiceAccount)
+	if clientID == "" || tenantID == "" {
+		recorder.Eventf(secretRotator, "Warning", "Misconfiguration", "missing Azure client id or tenant id for the azureWorkloadIdentity auth type")
+		return nil, &operations.ReconcileError{Message: "Missing Azure client id or tenant id, set spec.azureWorkloadIdentity.clientId/tenantId or the azure.workload.identity annotations on the ServiceAccount", RetryIn: 1 * time.Minute}
+	}
+
+	federatedToken, err := getAzureFederatedToken(ctx, isOperatorServiceAccount, recorder, request.Clientset, secretRotator)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := azureDetails.Scope
+	if scope == "" {
+		scope = clientID + "/.default"
+	}
+	entraToken, err := getAzureEntraToken(ctx, tenantID, clientID, scope, federatedToken)
+	if err != nil {
+		recorder.Eventf(secretRotator, "Warning", "TokenGenerationFailure",
+			fmt.Sprintf("could not get Microsoft Entra ID token, error was %s", err.Error()))
+		return nil, err
+	}
+
+	oidcDetails := jfrogv1alpha1.OidcDetails{ProviderName: azureDetails.ProviderName, IdentityMappingName: azureDetails.IdentityMappingName, ProjectKey: azureDetails.ProjectKey}
+	return &Credential{IdentityToken: entraToken, Oidc: oidcDetails}, nil
+}
+
+// resolveAzureSetting returns the configured value, falling back to the ServiceAccount annotation and, for the operator's own service account, the injected environment
+func resolveAzureSetting(configured string, serviceAccount *corev1.ServiceAccount, annotation, env string, isOperatorServiceAccount bool) string {
+	if configured != "" {
+		return configured
+	}
+	if serviceAccount != nil && serviceAccount.Annotations[annotation] != "" {
+		return serviceAccount.Annotations[annotation]
+	}
+	if isOperatorServiceAccount {
+		return os.Getenv(env)
+	}
+	return ""
+}
+
+// getAzureFederatedToken reads the projected federated token file of the operator, or mints a token for the configured ServiceAccount
+func getAzureFederatedToken(ctx context.Context, isOperatorServiceAccount bool, recorder record.EventRecorder, clientset kubernetes.Interface, secretRotator *jfrogv1alpha1.SecretRotator) (string, error) {
+	logger := log.FromContext(ctx)
+	if tokenFile := os.Getenv(azureFederatedTokenFileEnv); tokenFile != "" && isOperatorServiceAccount {
+		logger.Info("Reading Azure federated token file", "file", tokenFile)
+		tokenBytes, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", &operations.ReconcileError{Message: "Failed to read Azure federated token file", Cause: err, RetryIn: 1 * time.Minute}
+		}
+		return strings.TrimSpace(string(tokenBytes)), nil
+	}
+	return CreateServiceAccountToken(ctx, clientset, secretRotator, operations.AzureTokenExchangeAudience, recorder)
+}
+
+// getAzureEntraToken exchanges the federated token as client assertion for a Microsoft Entra ID access token
+func getAzureEntraToken(ctx context.Context, tenantID, clientID, scope, federatedToken string) (string, error) {
+	logger := log.FromContext(ctx)
+	authorityHost := azureAuthorityHost
+	if host := os.Getenv(azureAuthorityHostEnv); host != "" {
+		authorityHost = host
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"), url.PathEscape(tenantID))
+	logger.Info("Exchanging federated token with Microsoft Entra ID", "tenantId", tenantID, "clientId", clientID)
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", clientID)
+	form.Set("scope", scope)
+	form.Set("client_assertion_type", azureClientAssertionType)
+	form.Set("client_assertion", federatedToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", &operations.ReconcileError{Message: "Failed to create Microsoft Entra ID token request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	start := time.Now()
+	resp, err := client.Do(req)
+	metrics.ObserveRequest(metrics.ServiceAzure, "entra_token", start, err != nil || resp.StatusCode != http.StatusOK)
+	if err != nil {
+		return "", &operations.ReconcileError{Message: "Failed sending Microsoft Entra ID token request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			logger.Error(err, "Could not close response body")
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &operations.ReconcileError{
+			Message: fmt.Sprintf("%s%s%s%d%s", "Error getting Microsoft Entra ID token, request to ", tokenURL, " returned ", resp.StatusCode, " response"),
+			RetryIn: 1 * time.Minute,
+		}
+	}
+	tokenResponse := &azureTokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tokenResponse); err != nil {
+		return "", &operations.ReconcileError{Message: "Failed to parse Microsoft Entra ID token response", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	return tokenResponse.AccessToken, nil
+}
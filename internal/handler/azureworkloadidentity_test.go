@@ -0,0 +1,102 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/metrics"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// newFakeEntra returns a Microsoft Entra ID stub recording the client assertion
+func newFakeEntra(t *testing.T, clientAssertion *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tenant-1/oauth2/v2.0/token", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client-1", r.PostForm.Get("client_id"))
+		assert.Equal(t, "client-1/.default", r.PostForm.Get("scope"))
+		assert.Equal(t, azureClientAssertionType, r.PostForm.Get("client_assertion_type"))
+		*clientAssertion = r.PostForm.Get("client_assertion")
+		_ = json.NewEncoder(w).Encode(azureTokenResponse{AccessToken: "entra-token"})
+	}))
+}
+
+func newAzureSecretRotator(artifactoryUrl string) *jfrogv1alpha1.SecretRotator {
+	return &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			ArtifactoryUrl:        artifactoryUrl,
+			AuthType:              operations.AzureWorkloadIdentityAuthType,
+			ServiceAccount:        jfrogv1alpha1.ServiceAccountDetails{Name: "tenant-sa", Namespace: "tenant"},
+			AzureWorkloadIdentity: jfrogv1alpha1.AzureWorkloadIdentityDetails{ProviderName: "aks"},
+			Security:              jfrogv1alpha1.SecurityDetails{Enabled: true, InsecureSkipVerify: true},
+		},
+	}
+}
+
+func TestAzureWorkloadIdentityProvider_TokenRequest(t *testing.T) {
+	var clientAssertion, subjectToken string
+	entra := newFakeEntra(t, &clientAssertion)
+	defer entra.Close()
+	t.Setenv(azureAuthorityHostEnv, entra.URL)
+	artifactory := newFakeArtifactoryOidc(t, &subjectToken)
+	defer artifactory.Close()
+
+	secretRotator := newAzureSecretRotator(strings.TrimPrefix(artifactory.URL, "https://"))
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{operations.AzureClientIDKey: "client-1", operations.AzureTenantIDKey: "tenant-1"}}}
+	tokenDetails := &operations.TokenDetails{ArtifactoryUrl: secretRotator.Spec.ArtifactoryUrl, GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}}}
+
+	entraRequests := observedRequests(t, metrics.ServiceAzure, "entra_token", metrics.ResultSuccess)
+	var audiences []string
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, ServiceAccount: serviceAccount, Recorder: record.NewFakeRecorder(10), Clientset: newFakeClientsetWithToken("sa-jwt", &audiences)})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{operations.AzureTokenExchangeAudience}, audiences)
+	assert.Equal(t, "sa-jwt", clientAssertion)
+	assert.Equal(t, "entra-token", subjectToken)
+	assert.Equal(t, "exchanged", tokenDetails.TokenForScope("").AccessToken)
+	assert.Equal(t, entraRequests+1, observedRequests(t, metrics.ServiceAzure, "entra_token", metrics.ResultSuccess))
+}
+
+func TestAzureWorkloadIdentityProvider_FederatedTokenFile(t *testing.T) {
+	var clientAssertion, subjectToken string
+	entra := newFakeEntra(t, &clientAssertion)
+	defer entra.Close()
+	artifactory := newFakeArtifactoryOidc(t, &subjectToken)
+	defer artifactory.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "azure-identity-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("federated-jwt\n"), 0o600))
+	t.Setenv(azureAuthorityHostEnv, entra.URL)
+	t.Setenv(azureFederatedTokenFileEnv, tokenFile)
+	t.Setenv(azureClientIDEnv, "client-1")
+	t.Setenv(azureTenantIDEnv, "tenant-1")
+
+	secretRotator := newAzureSecretRotator(strings.TrimPrefix(artifactory.URL, "https://"))
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl:                 secretRotator.Spec.ArtifactoryUrl,
+		DefaultServiceAccountName:      "tenant-sa",
+		DefaultServiceAccountNamespace: "tenant",
+		GeneratedSecrets:               []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}},
+	}
+
+	var audiences []string
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, ServiceAccount: &corev1.ServiceAccount{}, Recorder: record.NewFakeRecorder(10), Clientset: newFakeClientsetWithToken("sa-jwt", &audiences)})
+	require.NoError(t, err)
+
+	assert.Empty(t, audiences)
+	assert.Equal(t, "federated-jwt", clientAssertion)
+	assert.Equal(t, "entra-token", subjectToken)
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/metrics"
+	"artifactory-secrets-rotator/internal/operations"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Google endpoints, variables so they can be pointed at a local fake server
+var (
+	gcpMetadataURL       = "http://metadata.google.internal/computeMetadata/v1"
+	gcpStsURL            = "https://sts.googleapis.com/v1/token"
+	gcpIamCredentialsURL = "https://iamcredentials.googleapis.com/v1"
+)
+
+const (
+	// gcpMetadataHostEnv overrides the metadata server host, same as the Google client libraries
+	gcpMetadataHostEnv    = "GCE_METADATA_HOST"
+	gcpCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+	gcpAccessTokenType    = "urn:ietf:params:oauth:token-type:access_token"
+	gcpJwtTokenType       = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// gcpStsResponse is the response of the Google STS token exchange
+type gcpStsResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// gcpIdTokenResponse is the response of the IAM credentials generateIdToken call
+type gcpIdTokenResponse struct {
+	Token string `json:"token"`
+}
+
+func init() {
+	RegisterIdentityProvider(&gcpWorkloadIdentityProvider{})
+}
+
+// gcpWorkloadIdentityProvider exchanges a Google-signed identity token of the bound Google service account
+// through Artifactory's OIDC integration.
+type gcpWorkloadIdentityProvider struct {
+	oidcProvider
+}
+
+// Name returns the gcpWorkloadIdentity auth type
+func (*gcpWorkloadIdentityProvider) Name() string {
+	return operations.GcpWorkloadIdentityAuthType
+}
+
+// Detect reports whether spec.gcpWorkloadIdentity.providerName is configured
+func (*gcpWorkloadIdentityProvider) Detect(_ context.Context, request *ProviderRequest) bool {
+	return request.SecretRotator.Spec.GcpWorkloadIdentity.ProviderName != ""
+}
+
+// ObtainCredential obtains an identity token of the bound Google service account from the metadata server, or through Google STS
+func (*gcpWorkloadIdentityProvider) ObtainCredential(ctx context.Context, request *ProviderRequest) (*Credential, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("Using GCP Workload Identity flow - exchanging Google identity token with Artifactory")
+
+	secretRotator := request.SecretRotator
+	gcpDetails := secretRotator.Spec.GcpWorkloadIdentity
+	if gcpDetails.ProviderName == "" {
+		request.Recorder.Eventf(secretRotator, "Warning", "Misconfiguration", "spec.gcpWorkloadIdentity.providerName is required for the gcpWorkloadIdentity auth type")
+		return nil, &operations.ReconcileError{Message: "Missing spec.gcpWorkloadIdentity.providerName for the gcpWorkloadIdentity auth type, the current reconciliation cycle will end here"}
+	}
+	audience := gcpDetails.Audience
+	if audience == "" {
+		audience = operations.DefaultOidcAudience
+	}
+
+	var identityToken string
+	var err error
+	if gcpDetails.WorkloadIdentityProvider != "" {
+		identityToken, err = getGcpIdentityTokenFromSts(ctx, gcpDetails, request.ServiceAccount, audience, request.Recorder, request.Clientset, secretRotator)
+	} else {
+		// The metadata server only issues identity tokens for the Google service account bound to the operator pod
+		if !request.usesOperatorServiceAccount() {
+			request.Recorder.Eventf(secretRotator, "Warning", "Misconfiguration", "spec.gcpWorkloadIdentity.workloadIdentityProvider is required for service account %s/%s", secretRotator.Spec.ServiceAccount.Namespace, secretRotator.Spec.ServiceAccount.Name)
+			return nil, &operations.ReconcileError{Message: fmt.Sprintf("Missing spec.gcpWorkloadIdentity.workloadIdentityProvider for service account %s/%s, the metadata server only issues identity tokens for the operator's own service account, the current reconciliation cycle will end here",
+				secretRotator.Spec.ServiceAccount.Namespace, secretRotator.Spec.ServiceAccount.Name)}
+		}
+		identityToken, err = getGcpIdentityTokenFromMetadata(ctx, audience)
+	}
+	if err != nil {
+		request.Recorder.Eventf(secretRotator, "Warning", "TokenGenerationFailure",
+			fmt.Sprintf("could not get Google identity token, error was %s", err.Error()))
+		return nil, err
+	}
+
+	oidcDetails := jfrogv1alpha1.OidcDetails{ProviderName: gcpDetails.ProviderName, IdentityMappingName: gcpDetails.IdentityMappingName, ProjectKey: gcpDetails.ProjectKey}
+	return &Credential{IdentityToken: identityToken, Oidc: oidcDetails}, nil
+}
+
+// getGcpIdentityTokenFromMetadata fetches an identity token of the bound Google service account from the GKE metadata server
+func getGcpIdentityTokenFromMetadata(ctx context.Context, audience string) (string, error) {
+	logger := log.FromContext(ctx)
+	metadataURL := gcpMetadataURL
+	if host := os.Getenv(gcpMetadataHostEnv); host != "" {
+		metadataURL = fmt.Sprintf("http://%s/computeMetadata/v1", host)
+	}
+	identityURL := fmt.Sprintf("%s/instance/service-accounts/default/identity?audience=%s&format=full", metadataURL, url.QueryEscape(audience))
+	logger.Info("Sending a request to the GCP metadata server", "uri", metadataURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, identityURL, nil)
+	if err != nil {
+		return "", &operations.ReconcileError{Message: "Failed to create GCP metadata request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	body, err := doGcpRequest(ctx, req, "metadata_identity_token")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// getGcpIdentityTokenFromSts exchanges the ServiceAccount token through Google STS and uses the federated token
+// to generate an identity token for the bound Google service account
+func getGcpIdentityTokenFromSts(ctx context.Context, gcpDetails jfrogv1alpha1.GcpWorkloadIdentityDetails, serviceAccount *corev1.ServiceAccount, audience string, recorder record.EventRecorder, clientset kubernetes.Interface, secretRotator *jfrogv1alpha1.SecretRotator) (string, error) {
+	logger := log.FromContext(ctx)
+	serviceAccountEmail := gcpDetails.ServiceAccountEmail
+	if serviceAccountEmail == "" && serviceAccount != nil {
+		serviceAccountEmail = serviceAccount.Annotations[operations.GcpServiceAccountKey]
+	}
+	if serviceAccountEmail == "" {
+		return "", &operations.ReconcileError{Message: "Missing Google service account, set spec.gcpWorkloadIdentity.serviceAccountEmail or the iam.gke.io/gcp-service-account annotation", RetryIn: 1 * time.Minute}
+	}
+
+	// Workload identity pools accept the full provider resource name prefixed with https: as the default audience
+	serviceAccountTokenAudience := gcpDetails.WorkloadIdentityProvider
+	if strings.HasPrefix(serviceAccountTokenAudience, "//") {
+		serviceAccountTokenAudience = "https:" + serviceAccountTokenAudience
+	}
+	serviceAccountToken, err := CreateServiceAccountToken(ctx, clientset, secretRotator, serviceAccountTokenAudience, recorder)
+	if err != nil {
+		return "", err
+	}
+
+	logger.Info("Exchanging service account token with Google STS", "workloadIdentityProvider", gcpDetails.WorkloadIdentityProvider)
+	form := url.Values{}
+	form.Set("grant_type", operations.OidcGrantType)
+	form.Set("audience", gcpDetails.WorkloadIdentityProvider)
+	form.Set("scope", gcpCloudPlatformScope)
+	form.Set("requested_token_type", gcpAccessTokenType)
+	form.Set("subject_token_type", gcpJwtTokenType)
+	form.Set("subject_token", serviceAccountToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gcpStsURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", &operations.ReconcileError{Message: "Failed to create Google STS request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := doGcpRequest(ctx, req, "sts_token_exchange")
+	if err != nil {
+		return "", err
+	}
+	stsResponse := &gcpStsResponse{}
+	if err := json.Unmarshal(body, stsResponse); err != nil {
+		return "", &operations.ReconcileError{Message: "Failed to parse Google STS response", Cause: err, RetryIn: 1 * time.Minute}
+	}
+
+	logger.Info("Generating identity token for Google service account", "serviceAccount", serviceAccountEmail)
+	idTokenBody, err := json.Marshal(map[string]interface{}{"audience": audience, "includeEmail": true})
+	if err != nil {
+		return "", &operations.ReconcileError{Message: "Failed to construct generateIdToken request body", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	idTokenURL := fmt.Sprintf("%s/projects/-/serviceAccounts/%s:generateIdToken", gcpIamCredentialsURL, url.PathEscape(serviceAccountEmail))
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, idTokenURL, bytes.NewBuffer(idTokenBody))
+	if err != nil {
+		return "", &operations.ReconcileError{Message: "Failed to create generateIdToken request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+stsResponse.AccessToken)
+	body, err = doGcpRequest(ctx, req, "generate_id_token")
+	if err != nil {
+		return "", err
+	}
+	idTokenResponse := &gcpIdTokenResponse{}
+	if err := json.Unmarshal(body, idTokenResponse); err != nil {
+		return "", &operations.ReconcileError{Message: "Failed to parse generateIdToken response", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	return idTokenResponse.Token, nil
+}
+
+// doGcpRequest sends a request to a Google endpoint and returns the response body, its latency is observed as the operation
+func doGcpRequest(ctx context.Context, req *http.Request, operation string) ([]byte, error) {
+	logger := log.FromContext(ctx)
+	client := &http.Client{Timeout: 10 * time.Second}
+	start := time.Now()
+	resp, err := client.Do(req)
+	metrics.ObserveRequest(metrics.ServiceGcp, operation, start, err != nil || resp.StatusCode != http.StatusOK)
+	if err != nil {
+		return nil, &operations.ReconcileError{Message: fmt.Sprintf("Failed sending request to %s", req.URL.Host), Cause: err, RetryIn: 1 * time.Minute}
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			logger.Error(err, "Could not close response body")
+		}
+	}(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &operations.ReconcileError{Message: fmt.Sprintf("Failed reading response from %s", req.URL.Host), Cause: err, RetryIn: 1 * time.Minute}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &operations.ReconcileError{
+			Message: fmt.Sprintf("%s%s%s%d%s", "Error getting Google identity token, request to ", req.URL.Host, " returned ", resp.StatusCode, " response"),
+			RetryIn: 1 * time.Minute,
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/metrics"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// observedRequests gathers the number of observed calls to the service operation with the result
+func observedRequests(t *testing.T, service, operation, result string) uint64 {
+	families, err := ctrlmetrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "jfrog_registry_operator_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["service"] == service && labels["operation"] == operation && labels["result"] == result {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+// newFakeArtifactoryOidc returns an Artifactory stub recording the exchanged subject token
+func newFakeArtifactoryOidc(t *testing.T, subjectToken *string) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var received operations.OidcTokenExchangeRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		*subjectToken = received.SubjectToken
+		_ = json.NewEncoder(w).Encode(operations.AccessResponse{AccessToken: "exchanged", Username: "gke-user", ExpiresIn: 900})
+	}))
+}
+
+func newGcpSecretRotator(artifactoryUrl string) *jfrogv1alpha1.SecretRotator {
+	return &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			ArtifactoryUrl:      artifactoryUrl,
+			AuthType:            operations.GcpWorkloadIdentityAuthType,
+			ServiceAccount:      jfrogv1alpha1.ServiceAccountDetails{Name: "jfrog-operator-sa", Namespace: "jfrog-operator"},
+			GcpWorkloadIdentity: jfrogv1alpha1.GcpWorkloadIdentityDetails{ProviderName: "gke", Audience: "artifactory"},
+			Security:            jfrogv1alpha1.SecurityDetails{Enabled: true, InsecureSkipVerify: true},
+		},
+	}
+}
+
+// newGcpTokenDetails returns token details for a SecretRotator running as the operator's own service account
+func newGcpTokenDetails(secretRotator *jfrogv1alpha1.SecretRotator) *operations.TokenDetails {
+	return &operations.TokenDetails{
+		ArtifactoryUrl:                 secretRotator.Spec.ArtifactoryUrl,
+		DefaultServiceAccountName:      "jfrog-operator-sa",
+		DefaultServiceAccountNamespace: "jfrog-operator",
+		GeneratedSecrets:               []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}},
+	}
+}
+
+func TestGcpWorkloadIdentityProvider_MetadataServer(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		assert.Equal(t, "/computeMetadata/v1/instance/service-accounts/default/identity", r.URL.Path)
+		assert.Equal(t, "artifactory", r.URL.Query().Get("audience"))
+		_, _ = w.Write([]byte("google-id-token\n"))
+	}))
+	defer metadata.Close()
+	t.Setenv(gcpMetadataHostEnv, strings.TrimPrefix(metadata.URL, "http://"))
+
+	var subjectToken string
+	artifactory := newFakeArtifactoryOidc(t, &subjectToken)
+	defer artifactory.Close()
+
+	secretRotator := newGcpSecretRotator(strings.TrimPrefix(artifactory.URL, "https://"))
+	tokenDetails := newGcpTokenDetails(secretRotator)
+
+	metadataRequests := observedRequests(t, metrics.ServiceGcp, "metadata_identity_token", metrics.ResultSuccess)
+	var audiences []string
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, ServiceAccount: &corev1.ServiceAccount{}, Recorder: record.NewFakeRecorder(10), Clientset: newFakeClientsetWithToken("sa-jwt", &audiences)})
+	require.NoError(t, err)
+
+	assert.Equal(t, "google-id-token", subjectToken)
+	assert.Empty(t, audiences)
+	assert.Equal(t, "exchanged", tokenDetails.TokenForScope("").AccessToken)
+	assert.Equal(t, float64(900), tokenDetails.TTLInSeconds)
+	assert.Equal(t, metadataRequests+1, observedRequests(t, metrics.ServiceGcp, "metadata_identity_token", metrics.ResultSuccess))
+}
+
+func TestGcpWorkloadIdentityProvider_StsExchange(t *testing.T) {
+	const provider = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/k8s"
+	google := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/token":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, provider, r.PostForm.Get("audience"))
+			assert.Equal(t, "sa-jwt", r.PostForm.Get("subject_token"))
+			_ = json.NewEncoder(w).Encode(gcpStsResponse{AccessToken: "federated"})
+		case "/v1/projects/-/serviceAccounts/artifactory@project.iam.gserviceaccount.com:generateIdToken":
+			assert.Equal(t, "Bearer federated", r.Header.Get("Authorization"))
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "artifactory", body["audience"])
+			_ = json.NewEncoder(w).Encode(gcpIdTokenResponse{Token: "google-id-token"})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer google.Close()
+	defer func(sts, iam string) { gcpStsURL, gcpIamCredentialsURL = sts, iam }(gcpStsURL, gcpIamCredentialsURL)
+	gcpStsURL, gcpIamCredentialsURL = google.URL+"/v1/token", google.URL+"/v1"
+
+	var subjectToken string
+	artifactory := newFakeArtifactoryOidc(t, &subjectToken)
+	defer artifactory.Close()
+
+	secretRotator := newGcpSecretRotator(strings.TrimPrefix(artifactory.URL, "https://"))
+	secretRotator.Spec.GcpWorkloadIdentity.WorkloadIdentityProvider = provider
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{operations.GcpServiceAccountKey: "artifactory@project.iam.gserviceaccount.com"}}}
+	tokenDetails := &operations.TokenDetails{ArtifactoryUrl: secretRotator.Spec.ArtifactoryUrl, GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}}}
+
+	stsRequests := observedRequests(t, metrics.ServiceGcp, "sts_token_exchange", metrics.ResultSuccess)
+	idTokenRequests := observedRequests(t, metrics.ServiceGcp, "generate_id_token", metrics.ResultSuccess)
+	var audiences []string
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, ServiceAccount: serviceAccount, Recorder: record.NewFakeRecorder(10), Clientset: newFakeClientsetWithToken("sa-jwt", &audiences)})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"https:" + provider}, audiences)
+	assert.Equal(t, "google-id-token", subjectToken)
+	assert.Equal(t, "exchanged", tokenDetails.TokenForScope("").AccessToken)
+	assert.Equal(t, stsRequests+1, observedRequests(t, metrics.ServiceGcp, "sts_token_exchange", metrics.ResultSuccess))
+	assert.Equal(t, idTokenRequests+1, observedRequests(t, metrics.ServiceGcp, "generate_id_token", metrics.ResultSuccess))
+}
+
+func TestGcpWorkloadIdentityProvider_MetadataServerRejectsOtherServiceAccount(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected metadata request %s", r.URL.Path)
+		_, _ = w.Write([]byte("operator-id-token"))
+	}))
+	defer metadata.Close()
+	t.Setenv(gcpMetadataHostEnv, strings.TrimPrefix(metadata.URL, "http://"))
+
+	// the tenant service account would get the identity of the operator pod from the metadata server
+	secretRotator := newGcpSecretRotator("artifactory.example.com")
+	secretRotator.Spec.ServiceAccount = jfrogv1alpha1.ServiceAccountDetails{Name: "tenant-sa", Namespace: "tenant"}
+	recorder := record.NewFakeRecorder(10)
+	var audiences []string
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: newGcpTokenDetails(secretRotator), SecretRotator: secretRotator, ServiceAccount: &corev1.ServiceAccount{}, Recorder: recorder, Clientset: newFakeClientsetWithToken("sa-jwt", &audiences)})
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "workloadIdentityProvider")
+	assert.Contains(t, <-recorder.Events, "Misconfiguration")
+	assert.Empty(t, audiences)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func init() {
+	RegisterIdentityProvider(&kubernetesOidcProvider{})
+}
+
+// kubernetesOidcProvider exchanges a projected ServiceAccount token through Artifactory's OIDC integration.
+// No cloud provider is involved, the Kubernetes API server issuer has to be configured as an OIDC provider in the JFrog platform.
+type kubernetesOidcProvider struct {
+	oidcProvider
+}
+
+// Name returns the kubernetesOidc auth type
+func (*kubernetesOidcProvider) Name() string {
+	return operations.KubernetesOidcAuthType
+}
+
+// Detect reports whether spec.kubernetesOidc.providerName is configured
+func (*kubernetesOidcProvider) Detect(_ context.Context, request *ProviderRequest) bool {
+	return request.SecretRotator.Spec.KubernetesOidc.ProviderName != ""
+}
+
+// ObtainCredential requests a projected token for the target ServiceAccount
+func (*kubernetesOidcProvider) ObtainCredential(ctx context.Context, request *ProviderRequest) (*Credential, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("Using Kubernetes OIDC flow - exchanging service account token with Artifactory")
+
+	secretRotator := request.SecretRotator
+	oidcDetails := secretRotator.Spec.KubernetesOidc
+	if oidcDetails.ProviderName == "" {
+		request.Recorder.Eventf(secretRotator, "Warning", "Misconfiguration", "spec.kubernetesOidc.providerName is required for the kubernetesOidc auth type")
+		return nil, &operations.ReconcileError{Message: "Missing spec.kubernetesOidc.providerName for the kubernetesOidc auth type, the current reconciliation cycle will end here"}
+	}
+	audience := oidcDetails.Audience
+	if audience == "" {
+		audience = operations.DefaultOidcAudience
+	}
+
+	// Create token request for the target service account
+	serviceAccountToken, err := CreateServiceAccountToken(ctx, request.Clientset, secretRotator, audience, request.Recorder)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{IdentityToken: serviceAccountToken, Oidc: oidcDetails}, nil
+}
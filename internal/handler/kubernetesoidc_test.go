@@ -0,0 +1,124 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/metrics"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// newFakeClientsetWithToken returns a clientset whose TokenRequests are answered with the given token and record the requested audience
+func newFakeClientsetWithToken(token string, audiences *[]string) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "jfrog-operator-sa", Namespace: "jfrog-operator"}})
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		tokenRequest := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		*audiences = tokenRequest.Spec.Audiences
+		tokenRequest.Status.Token = token
+		return true, tokenRequest, nil
+	})
+	return clientset
+}
+
+func newOidcSecretRotator(artifactoryUrl string) *jfrogv1alpha1.SecretRotator {
+	return &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			ArtifactoryUrl: artifactoryUrl,
+			AuthType:       operations.KubernetesOidcAuthType,
+			ServiceAccount: jfrogv1alpha1.ServiceAccountDetails{Name: "jfrog-operator-sa", Namespace: "jfrog-operator"},
+			KubernetesOidc: jfrogv1alpha1.OidcDetails{ProviderName: "k8s-cluster", IdentityMappingName: "ci", Audience: "artifactory"},
+			Security:       jfrogv1alpha1.SecurityDetails{Enabled: true, InsecureSkipVerify: true},
+		},
+	}
+}
+
+func TestKubernetesOidcProvider_Success(t *testing.T) {
+	var received operations.OidcTokenExchangeRequest
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, oidcTokenEndpoint, r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		_ = json.NewEncoder(w).Encode(operations.AccessResponse{TokenId: "id-1", AccessToken: "exchanged", Username: "ci-user", ExpiresIn: 600, Scope: "applied-permissions/groups:readers"})
+	}))
+	defer server.Close()
+
+	var audiences []string
+	clientset := newFakeClientsetWithToken("sa-jwt", &audiences)
+	secretRotator := newOidcSecretRotator(strings.TrimPrefix(server.URL, "https://"))
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl:   secretRotator.Spec.ArtifactoryUrl,
+		GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}, {SecretName: "deploy", SecretType: operations.SecretTypeGeneric}},
+	}
+
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, Recorder: record.NewFakeRecorder(10), Clientset: clientset})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"artifactory"}, audiences)
+	assert.Equal(t, operations.OidcGrantType, received.GrantType)
+	assert.Equal(t, "sa-jwt", received.SubjectToken)
+	assert.Equal(t, "k8s-cluster", received.ProviderName)
+	assert.Equal(t, "ci", received.IdentityMappingName)
+	assert.Equal(t, float64(600), tokenDetails.TTLInSeconds)
+	assert.Equal(t, "exchanged", tokenDetails.TokenForScope("").AccessToken)
+
+	// a requested scope is rejected, the identity mapping defines the token scope
+	tokenDetails.GeneratedSecrets = append(tokenDetails.GeneratedSecrets, jfrogv1alpha1.GeneratedSecret{SecretName: "scoped", SecretType: operations.SecretTypeGeneric, Scope: "applied-permissions/groups:deployers"})
+	err = IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, Recorder: record.NewFakeRecorder(10), Clientset: clientset})
+	assert.ErrorContains(t, err, "Scope 'applied-permissions/groups:deployers' is not supported")
+}
+
+func TestKubernetesOidcProvider_TokenTTL(t *testing.T) {
+	var received operations.OidcTokenExchangeRequest
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		_ = json.NewEncoder(w).Encode(operations.AccessResponse{TokenId: "id-1", AccessToken: "exchanged"})
+	}))
+	defer server.Close()
+
+	var audiences []string
+	secretRotator := newOidcSecretRotator(strings.TrimPrefix(server.URL, "https://"))
+	secretRotator.Spec.TokenTTL = &metav1.Duration{Duration: 15 * time.Minute}
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl:   secretRotator.Spec.ArtifactoryUrl,
+		GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}},
+	}
+
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, Recorder: record.NewFakeRecorder(10), Clientset: newFakeClientsetWithToken("sa-jwt", &audiences)})
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(900), received.ExpiresIn)
+	assert.Equal(t, float64(900), tokenDetails.TTLInSeconds)
+}
+
+func TestKubernetesOidcProvider_MissingProvider(t *testing.T) {
+	var audiences []string
+	secretRotator := newOidcSecretRotator("artifactory.example.com")
+	secretRotator.Spec.KubernetesOidc.ProviderName = ""
+
+	failures := testutil.ToFloat64(metrics.TokenIssuanceFailuresTotal.WithLabelValues(operations.KubernetesOidcAuthType, "artifactory.example.com"))
+	tokenDetails := &operations.TokenDetails{ArtifactoryUrl: "artifactory.example.com"}
+
+	err := IssueTokens(context.Background(), &ProviderRequest{TokenDetails: tokenDetails, SecretRotator: secretRotator, Recorder: record.NewFakeRecorder(10), Clientset: newFakeClientsetWithToken("sa-jwt", &audiences)})
+	require.Error(t, err)
+	assert.Empty(t, audiences)
+	assert.Equal(t, failures+1, testutil.ToFloat64(metrics.TokenIssuanceFailuresTotal.WithLabelValues(operations.KubernetesOidcAuthType, "artifactory.example.com")))
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/metrics"
+	"artifactory-secrets-rotator/internal/operations"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const oidcTokenEndpoint = "/access/api/v1/oidc/token"
+
+// exchangeOidcToken exchanges an identity token for a JFrog access token through the OIDC integration configured in the JFrog platform
+func exchangeOidcToken(ctx context.Context, artifactoryUrl string, subjectToken string, oidcDetails *jfrogv1alpha1.OidcDetails, expiresIn *int32, securityDetails *jfrogv1alpha1.SecurityDetails, secretRotatorName string) (*operations.AccessResponse, error) {
+	logger := log.FromContext(ctx)
+	url := fmt.Sprintf("%s%s%s", "https://", artifactoryUrl, oidcTokenEndpoint)
+	exchangeRequest := operations.OidcTokenExchangeRequest{
+		GrantType:           operations.OidcGrantType,
+		SubjectTokenType:    operations.OidcSubjectTokenType,
+		SubjectToken:        subjectToken,
+		ProviderName:        oidcDetails.ProviderName,
+		IdentityMappingName: oidcDetails.IdentityMappingName,
+		ProjectKey:          oidcDetails.ProjectKey,
+	}
+	if expiresIn != nil {
+		exchangeRequest.ExpiresIn = *expiresIn
+	}
+	body, err := json.Marshal(exchangeRequest)
+	if err != nil {
+		return nil, &operations.ReconcileError{Message: "Error constructing artifactory OIDC token exchange request body", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, &operations.ReconcileError{Message: "Error constructing artifactory OIDC token exchange request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create a custom HTTP client with TLS configuration
+	client, err := createCustomHTTPClient(securityDetails, secretRotatorName)
+	if err != nil {
+		return nil, &operations.ReconcileError{Message: "Error in intialising custom HTTP client with TLS configuration", Cause: err, RetryIn: 1 * time.Minute}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	metrics.ObserveRequest(metrics.ServiceArtifactory, "oidc_token", start, err != nil || resp.StatusCode != http.StatusOK)
+	if err != nil {
+		return nil, &operations.ReconcileError{Message: "Error sending artifactory OIDC token exchange request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			logger.Error(err, "Could not close response body")
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		errMessage := fmt.Sprintf("%s%s%s%d%s", "Error exchanging OIDC token, token exchange to ", url, " returned ", resp.StatusCode, " response")
+		return nil, &operations.ReconcileError{Message: errMessage, RetryIn: 1 * time.Minute}
+	}
+	// Read and process the response
+	myResponse := &operations.AccessResponse{}
+	err = json.NewDecoder(resp.Body).Decode(myResponse)
+	if err != nil {
+		return nil, &operations.ReconcileError{Message: "Error reading artifactory OIDC token exchange response", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	return myResponse, nil
+}
+
+// oidcProvider exchanges an identity token through Artifactory's OIDC integration, shared by the OIDC identity providers
+type oidcProvider struct{}
+
+// Exchange exchanges the identity token for a JFrog access token.
+// The token scope is governed by the identity mapping, a requested scope is rejected instead of being dropped.
+func (oidcProvider) Exchange(ctx context.Context, request *ProviderRequest, credential *Credential, scope string) (*operations.AccessResponse, error) {
+	logger := log.FromContext(ctx)
+	if scope != "" {
+		return nil, &operations.ReconcileError{Message: fmt.Sprintf("Scope '%s' is not supported by OIDC exchanged tokens, the identity mapping of provider %s defines the token scope", scope, credential.Oidc.ProviderName)}
+	}
+	accessResponse, err := exchangeOidcToken(ctx, request.TokenDetails.ArtifactoryUrl, credential.IdentityToken, &credential.Oidc, credential.ExpiresIn, &request.SecretRotator.Spec.Security, request.SecretRotator.Name)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Successfully exchanged identity token with artifactory", "providerName", credential.Oidc.ProviderName, "expiresIn", accessResponse.ExpiresIn)
+	return accessResponse, nil
+}
+
+// TTL returns the expiry reported by the exchange response, or the requested token lifetime
+func (oidcProvider) TTL(credential *Credential, response *operations.AccessResponse) float64 {
+	if response.ExpiresIn <= 0 && credential.ExpiresIn != nil {
+		return float64(*credential.ExpiresIn)
+	}
+	if response.ExpiresIn <= 0 {
+		// the exchange response did not report an expiry, fall back to the default token expiration of 3 hours
+		return operations.RoleMaxSessionDuration
+	}
+	return float64(response.ExpiresIn)
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"artifactory-secrets-rotator/internal/metrics"
 	"artifactory-secrets-rotator/internal/operations"
 	controllers2 "artifactory-secrets-rotator/internal/sign"
 	"context"
@@ -18,6 +19,34 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+func init() {
+	RegisterIdentityProvider(&podIdentityProvider{})
+}
+
+// podIdentityProvider signs requests with the credentials served by the EKS Pod Identity agent
+type podIdentityProvider struct {
+	awsProvider
+}
+
+// Name returns the podIdentity auth type
+func (*podIdentityProvider) Name() string {
+	return operations.PodIdentityAuthType
+}
+
+// Detect reports whether the EKS Pod Identity agent environment is injected
+func (*podIdentityProvider) Detect(_ context.Context, _ *ProviderRequest) bool {
+	return operations.DetectPodIdentity()
+}
+
+// ObtainCredential signs a GetCallerIdentity request with the Pod Identity credentials
+func (*podIdentityProvider) ObtainCredential(ctx context.Context, request *ProviderRequest) (*Credential, error) {
+	signedRequest, err := GetSignedRequestForPodIdentity(ctx, request.TokenDetails)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{SignedRequest: signedRequest, ExpiresIn: request.TokenDetails.RoleMaxSessionDuration}, nil
+}
+
 // ResolveIAMRoleARNFromPodIdentityCredentials returns the IAM role ARN for the current Pod Identity session.
 // EKS Pod Identity does not inject AWS_ROLE_ARN; the role is discovered via STS GetCallerIdentity using the
 // temporary keys from the credentials endpoint (Arn is an assumed-role ARN, converted to arn:aws:iam::...:role/...).
@@ -31,7 +60,9 @@ func ResolveIAMRoleARNFromPodIdentityCredentials(ctx context.Context, region str
 	if err != nil {
 		return nil, err
 	}
+	start := time.Now()
 	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	metrics.ObserveRequest(metrics.ServiceSts, "get_caller_identity", start, err != nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+// IdentityProvider resolves the workload identity of a SecretRotator and exchanges it for JFrog access tokens.
+// Providers register themselves with RegisterIdentityProvider and are selected by spec.authType, or detected in auto mode.
+type IdentityProvider interface {
+	// Name returns the auth type handled by the provider
+	Name() string
+	// Detect reports whether the provider's environment or configuration is available, used by the auto auth type
+	Detect(ctx context.Context, request *ProviderRequest) bool
+	// ObtainCredential obtains the workload credential to be exchanged with Artifactory
+	ObtainCredential(ctx context.Context, request *ProviderRequest) (*Credential, error)
+	// Exchange exchanges the credential for a JFrog access token with the requested scope
+	Exchange(ctx context.Context, request *ProviderRequest, credential *Credential, scope string) (*operations.AccessResponse, error)
+	// TTL reports the lifetime in seconds of the tokens issued for the credential
+	TTL(credential *Credential, response *operations.AccessResponse) float64
+}
+
+// ProviderRequest holds what identity providers need to resolve the workload identity of a SecretRotator
+type ProviderRequest struct {
+	TokenDetails   *operations.TokenDetails
+	SecretRotator  *jfrogv1alpha1.SecretRotator
+	ServiceAccount *corev1.ServiceAccount
+	Recorder       record.EventRecorder
+	Clientset      kubernetes.Interface
+}
+
+// usesOperatorServiceAccount reports whether the SecretRotator uses the operator's own service account, the only one
+// the credentials injected into the operator pod describe
+func (request *ProviderRequest) usesOperatorServiceAccount() bool {
+	serviceAccount := request.SecretRotator.Spec.ServiceAccount
+	return serviceAccount.Name == request.TokenDetails.DefaultServiceAccountName && serviceAccount.Namespace == request.TokenDetails.DefaultServiceAccountNamespace
+}
+
+// Credential is the workload credential obtained by an identity provider
+type Credential struct {
+	// SignedRequest is the signed AWS STS GetCallerIdentity request, exchanged at Artifactory's AWS token endpoint
+	SignedRequest *http.Request
+	// ExpiresIn is the requested token lifetime in seconds
+	ExpiresIn *int32
+	// IdentityToken is the identity token exchanged through Artifactory's OIDC integration
+	IdentityToken string
+	// Oidc is the Artifactory OIDC integration the identity token is exchanged with
+	Oidc jfrogv1alpha1.OidcDetails
+}
+
+var (
+	identityProvidersMu sync.RWMutex
+	identityProviders   = map[string]IdentityProvider{}
+)
+
+// RegisterIdentityProvider registers an identity provider under its auth type name, replacing any provider with the same name
+func RegisterIdentityProvider(provider IdentityProvider) {
+	identityProvidersMu.Lock()
+	defer identityProvidersMu.Unlock()
+	identityProviders[provider.Name()] = provider
+}
+
+// GetIdentityProvider returns the identity provider registered for the auth type
+func GetIdentityProvider(authType string) (IdentityProvider, bool) {
+	identityProvidersMu.RLock()
+	defer identityProvidersMu.RUnlock()
+	provider, ok := identityProviders[authType]
+	return provider, ok
+}
+
+// ResolveIdentityProvider returns the provider configured by spec.authType.
+// In auto mode the providers of spec.authPriority, or operations.DefaultAuthPriority, are detected in order and the first available one is used.
+func ResolveIdentityProvider(ctx context.Context, request *ProviderRequest) (IdentityProvider, error) {
+	authType := request.SecretRotator.Spec.AuthType
+	if authType == "" {
+		authType = operations.AutoAuthType
+	}
+
+	if authType != operations.AutoAuthType {
+		provider, ok := GetIdentityProvider(authType)
+		if !ok {
+			return nil, &operations.ReconcileError{Message: fmt.Sprintf("No identity provider registered for auth type %s", authType)}
+		}
+		return provider, nil
+	}
+
+	priority := operations.AuthPriority(request.SecretRotator)
+	for _, name := range priority {
+		provider, ok := GetIdentityProvider(name)
+		if !ok {
+			return nil, &operations.ReconcileError{Message: fmt.Sprintf("No identity provider registered for auth type %s in spec.authPriority", name)}
+		}
+		if provider.Detect(ctx, request) {
+			return provider, nil
+		}
+	}
+	return nil, &operations.ReconcileError{Message: fmt.Sprintf("None of the identity providers %v was detected for the auto auth type", priority)}
+}
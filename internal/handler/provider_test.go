@@ -0,0 +1,85 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIdentityProvidersRegistered(t *testing.T) {
+	for _, authType := range []string{
+		operations.PodIdentityAuthType,
+		operations.WebIdentityAuthType,
+		operations.KubernetesOidcAuthType,
+		operations.GcpWorkloadIdentityAuthType,
+		operations.AzureWorkloadIdentityAuthType,
+	} {
+		provider, ok := GetIdentityProvider(authType)
+		require.True(t, ok, authType)
+		assert.Equal(t, authType, provider.Name())
+	}
+}
+
+func TestResolveIdentityProvider_Explicit(t *testing.T) {
+	request := &ProviderRequest{SecretRotator: &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{AuthType: operations.GcpWorkloadIdentityAuthType}}}
+
+	provider, err := ResolveIdentityProvider(context.Background(), request)
+	require.NoError(t, err)
+	assert.Equal(t, operations.GcpWorkloadIdentityAuthType, provider.Name())
+}
+
+func TestResolveIdentityProvider_UnknownAuthType(t *testing.T) {
+	request := &ProviderRequest{SecretRotator: &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{AuthType: "unknown"}}}
+
+	_, err := ResolveIdentityProvider(context.Background(), request)
+	require.Error(t, err)
+}
+
+func TestResolveIdentityProvider_AutoDefaultPriority(t *testing.T) {
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "")
+	request := &ProviderRequest{
+		SecretRotator:  &jfrogv1alpha1.SecretRotator{},
+		ServiceAccount: &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{operations.RoleARNKey: "arn:aws:iam::123456789012:role/artifactory"}}},
+	}
+
+	provider, err := ResolveIdentityProvider(context.Background(), request)
+	require.NoError(t, err)
+	assert.Equal(t, operations.WebIdentityAuthType, provider.Name())
+
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount/eks-pod-identity-token")
+	provider, err = ResolveIdentityProvider(context.Background(), request)
+	require.NoError(t, err)
+	assert.Equal(t, operations.PodIdentityAuthType, provider.Name())
+}
+
+func TestResolveIdentityProvider_AutoConfiguredPriority(t *testing.T) {
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "")
+	secretRotator := &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{
+		AuthType:       operations.AutoAuthType,
+		AuthPriority:   []string{operations.PodIdentityAuthType, operations.AzureWorkloadIdentityAuthType, operations.KubernetesOidcAuthType},
+		KubernetesOidc: jfrogv1alpha1.OidcDetails{ProviderName: "k8s-cluster"},
+	}}
+
+	provider, err := ResolveIdentityProvider(context.Background(), &ProviderRequest{SecretRotator: secretRotator, ServiceAccount: &corev1.ServiceAccount{}})
+	require.NoError(t, err)
+	assert.Equal(t, operations.KubernetesOidcAuthType, provider.Name())
+
+	secretRotator.Spec.AzureWorkloadIdentity.ProviderName = "entra"
+	provider, err = ResolveIdentityProvider(context.Background(), &ProviderRequest{SecretRotator: secretRotator, ServiceAccount: &corev1.ServiceAccount{}})
+	require.NoError(t, err)
+	assert.Equal(t, operations.AzureWorkloadIdentityAuthType, provider.Name())
+}
+
+func TestResolveIdentityProvider_AutoNoneDetected(t *testing.T) {
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "")
+	request := &ProviderRequest{SecretRotator: &jfrogv1alpha1.SecretRotator{}, ServiceAccount: &corev1.ServiceAccount{}}
+
+	_, err := ResolveIdentityProvider(context.Background(), request)
+	require.Error(t, err)
+}
@@ -0,0 +1,261 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/metrics"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const revokeTokenEndpoint = "/access/api/v1/tokens/"
+
+// TrackIssuedTokens records the tokens issued by the current reconciliation in the status.
+// The previously issued tokens are marked superseded once every generated secret was updated,
+// while some secrets may still hold them they stay recorded as issued. Tokens already recorded keep their entry,
+// so the status only changes when the issued tokens do.
+func TrackIssuedTokens(tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, now metav1.Time) {
+	if len(tokenDetails.Tokens) == 0 {
+		return
+	}
+	secretRotator.Status.IssuedTokens, secretRotator.Status.SupersededTokens = trackTokens(tokenDetails.Tokens, secretRotator.Status.IssuedTokens,
+		secretRotator.Status.SupersededTokens, tokenDetails.SecretsOutdated, secretRotator.Spec.TokenRevocation.Enabled, now)
+}
+
+// TrackCredentialTokens records the tokens issued for the ArtifactoryCredential in its status, like TrackIssuedTokens does
+// for the SecretRotator. The previous tokens are kept as issued while some secrets of the ArtifactoryCredential are outdated.
+func TrackCredentialTokens(tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, credential *jfrogv1alpha1.ArtifactoryCredential, secretsOutdated bool, now metav1.Time) {
+	tokens := tokenDetails.ForCredential(credential).Tokens
+	if len(tokens) == 0 {
+		return
+	}
+	credential.Status.IssuedTokens, credential.Status.SupersededTokens = trackTokens(tokens, credential.Status.IssuedTokens,
+		credential.Status.SupersededTokens, secretsOutdated, secretRotator.Spec.TokenRevocation.Enabled, now)
+}
+
+// trackTokens returns the issued and superseded tokens after the tokens were issued
+func trackTokens(tokens map[string]*operations.AccessResponse, issued, superseded []jfrogv1alpha1.IssuedToken, secretsOutdated, revocationEnabled bool, now metav1.Time) ([]jfrogv1alpha1.IssuedToken, []jfrogv1alpha1.IssuedToken) {
+	scopes := make([]string, 0, len(tokens))
+	for scope := range tokens {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	recorded := map[string]jfrogv1alpha1.IssuedToken{}
+	for _, previous := range issued {
+		recorded[previous.TokenID] = previous
+	}
+	issuedTokens := []jfrogv1alpha1.IssuedToken{}
+	current := map[string]bool{}
+	for _, scope := range scopes {
+		accessResponse := tokens[scope]
+		if accessResponse.TokenId == "" || current[accessResponse.TokenId] {
+			continue
+		}
+		current[accessResponse.TokenId] = true
+		if previous, ok := recorded[accessResponse.TokenId]; ok {
+			issuedTokens = append(issuedTokens, previous)
+			continue
+		}
+		issuedToken := jfrogv1alpha1.IssuedToken{TokenID: accessResponse.TokenId, Scope: scope, IssuedAt: now}
+		if accessResponse.ExpiresIn > 0 {
+			expiresAt := metav1.NewTime(now.Add(time.Duration(accessResponse.ExpiresIn) * time.Second))
+			issuedToken.ExpiresAt = &expiresAt
+		}
+		issuedTokens = append(issuedTokens, issuedToken)
+	}
+
+	for _, previous := range issued {
+		if current[previous.TokenID] {
+			continue
+		}
+		if secretsOutdated {
+			issuedTokens = append(issuedTokens, previous)
+			continue
+		}
+		if revocationEnabled {
+			supersededAt := now
+			previous.SupersededAt = &supersededAt
+			superseded = append(superseded, previous)
+		}
+	}
+	return issuedTokens, superseded
+}
+
+// RevokeSupersededTokens revokes the superseded tokens whose grace period passed, using a currently issued token of the same scope.
+// Tokens that already expired are dropped, tokens that could not be revoked are retried on the next reconciliation.
+func RevokeSupersededTokens(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, recorder record.EventRecorder, now metav1.Time) {
+	secretRotator.Status.SupersededTokens = revokeSuperseded(ctx, tokenDetails, secretRotator, secretRotator, secretRotator.Status.SupersededTokens, recorder, now)
+}
+
+// RevokeSupersededCredentialTokens revokes the superseded tokens of the ArtifactoryCredential under the tokenRevocation of the SecretRotator,
+// using the token currently issued for the ArtifactoryCredential with the same scope
+func RevokeSupersededCredentialTokens(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, credential *jfrogv1alpha1.ArtifactoryCredential, recorder record.EventRecorder, now metav1.Time) {
+	credential.Status.SupersededTokens = revokeSuperseded(ctx, tokenDetails.ForCredential(credential), secretRotator, credential, credential.Status.SupersededTokens, recorder, now)
+}
+
+// revokeSuperseded revokes the superseded tokens whose grace period passed and returns the ones still waiting for revocation,
+// the failures are reported as events on the object the tokens were issued for
+func revokeSuperseded(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, object runtime.Object, supersededTokens []jfrogv1alpha1.IssuedToken, recorder record.EventRecorder, now metav1.Time) []jfrogv1alpha1.IssuedToken {
+	logger := log.FromContext(ctx)
+	if !secretRotator.Spec.TokenRevocation.Enabled {
+		return nil
+	}
+	gracePeriod := operations.DefaultRevocationGracePeriod
+	if secretRotator.Spec.TokenRevocation.GracePeriod != nil {
+		gracePeriod = secretRotator.Spec.TokenRevocation.GracePeriod.Duration
+	}
+
+	remaining := []jfrogv1alpha1.IssuedToken{}
+	for _, superseded := range supersededTokens {
+		if superseded.ExpiresAt != nil && !now.Before(superseded.ExpiresAt) {
+			logger.Info("Superseded token already expired, nothing to revoke", "tokenId", superseded.TokenID)
+			continue
+		}
+		if superseded.SupersededAt != nil && now.Before(&metav1.Time{Time: superseded.SupersededAt.Add(gracePeriod)}) {
+			remaining = append(remaining, superseded)
+			continue
+		}
+		bearer := tokenDetails.TokenForScope(superseded.Scope)
+		if bearer == nil {
+			logger.Info("No token issued for the scope of the superseded token, revocation is retried on the next reconciliation", "tokenId", superseded.TokenID, "scope", superseded.Scope)
+			remaining = append(remaining, superseded)
+			continue
+		}
+		if err := revokeToken(ctx, tokenDetails.ArtifactoryUrl, bearer.AccessToken, superseded.TokenID, &secretRotator.Spec.Security, secretRotator.Name); err != nil {
+			logger.Error(err, "Could not revoke superseded token", "tokenId", superseded.TokenID)
+			recorder.Eventf(object, "Warning", "TokenRevocationFailure",
+				fmt.Sprintf("could not revoke superseded token %s, it stays valid until it expires if this persists, error was %s", superseded.TokenID, err.Error()))
+			remaining = append(remaining, superseded)
+			continue
+		}
+		logger.Info("Revoked superseded token", "tokenId", superseded.TokenID, "scope", superseded.Scope)
+	}
+	return remaining
+}
+
+// LiveTokenScopes returns the distinct scopes of the issued and superseded tokens of the SecretRotator
+// and its ArtifactoryCredentials that did not expire yet
+func LiveTokenScopes(secretRotator *jfrogv1alpha1.SecretRotator, credentials []*jfrogv1alpha1.ArtifactoryCredential, now metav1.Time) []string {
+	tokens := liveTokens(secretRotator.Status.IssuedTokens, secretRotator.Status.SupersededTokens, now)
+	for _, credential := range credentials {
+		tokens = append(tokens, liveTokens(credential.Status.IssuedTokens, credential.Status.SupersededTokens, now)...)
+	}
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, liveToken := range tokens {
+		if seen[liveToken.Scope] {
+			continue
+		}
+		seen[liveToken.Scope] = true
+		scopes = append(scopes, liveToken.Scope)
+	}
+	return scopes
+}
+
+// RevokeIssuedTokens revokes the issued and superseded tokens of the SecretRotator and its ArtifactoryCredentials that did not expire yet,
+// using the tokens issued for the revocation, which are revoked last. The tokens that could not be revoked stay recorded as issued
+// so the revocation can be retried.
+func RevokeIssuedTokens(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, credentials []*jfrogv1alpha1.ArtifactoryCredential, recorder record.EventRecorder, now metav1.Time) error {
+	logger := log.FromContext(ctx)
+	failed, errs := revokeLive(ctx, tokenDetails, secretRotator, liveTokens(secretRotator.Status.IssuedTokens, secretRotator.Status.SupersededTokens, now))
+	secretRotator.Status.IssuedTokens = failed
+	secretRotator.Status.SupersededTokens = nil
+	for _, credential := range credentials {
+		failed, credentialErrs := revokeLive(ctx, tokenDetails, secretRotator, liveTokens(credential.Status.IssuedTokens, credential.Status.SupersededTokens, now))
+		credential.Status.IssuedTokens = failed
+		credential.Status.SupersededTokens = nil
+		errs = append(errs, credentialErrs...)
+	}
+
+	// The tokens issued for the revocation are not stored anywhere, a failure is only reported as they cannot be retried
+	for scope, accessResponse := range tokenDetails.Tokens {
+		if err := revokeToken(ctx, tokenDetails.ArtifactoryUrl, accessResponse.AccessToken, accessResponse.TokenId, &secretRotator.Spec.Security, secretRotator.Name); err != nil {
+			logger.Error(err, "Could not revoke the token issued for the revocation", "tokenId", accessResponse.TokenId, "scope", scope)
+			recorder.Eventf(secretRotator, "Warning", "TokenRevocationFailure",
+				fmt.Sprintf("could not revoke token %s issued for the revocation, it stays valid until it expires, error was %s", accessResponse.TokenId, err.Error()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// revokeLive revokes the live tokens and returns the ones that could not be revoked with the reasons
+func revokeLive(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, live []jfrogv1alpha1.IssuedToken) ([]jfrogv1alpha1.IssuedToken, []error) {
+	logger := log.FromContext(ctx)
+	failed := []jfrogv1alpha1.IssuedToken{}
+	errs := []error{}
+	for _, liveToken := range live {
+		bearer := tokenDetails.TokenForScope(liveToken.Scope)
+		if bearer == nil {
+			failed = append(failed, liveToken)
+			errs = append(errs, fmt.Errorf("no token issued for scope '%s' to revoke token %s", liveToken.Scope, liveToken.TokenID))
+			continue
+		}
+		if err := revokeToken(ctx, tokenDetails.ArtifactoryUrl, bearer.AccessToken, liveToken.TokenID, &secretRotator.Spec.Security, secretRotator.Name); err != nil {
+			failed = append(failed, liveToken)
+			errs = append(errs, fmt.Errorf("could not revoke token %s: %w", liveToken.TokenID, err))
+			continue
+		}
+		logger.Info("Revoked issued token", "tokenId", liveToken.TokenID, "scope", liveToken.Scope)
+	}
+	return failed, errs
+}
+
+// liveTokens returns the issued and superseded tokens that did not expire yet
+func liveTokens(issued, superseded []jfrogv1alpha1.IssuedToken, now metav1.Time) []jfrogv1alpha1.IssuedToken {
+	live := []jfrogv1alpha1.IssuedToken{}
+	for _, token := range append(append([]jfrogv1alpha1.IssuedToken{}, issued...), superseded...) {
+		if token.ExpiresAt != nil && !now.Before(token.ExpiresAt) {
+			continue
+		}
+		live = append(live, token)
+	}
+	return live
+}
+
+// revokeToken revokes a token by its id through Artifactory's token revocation API, a token that is already gone counts as revoked
+func revokeToken(ctx context.Context, artifactoryUrl, bearerToken, tokenID string, securityDetails *jfrogv1alpha1.SecurityDetails, secretRotatorName string) error {
+	logger := log.FromContext(ctx)
+	revokeUrl := fmt.Sprintf("%s%s%s%s", "https://", artifactoryUrl, revokeTokenEndpoint, url.PathEscape(tokenID))
+	req, err := http.NewRequest(http.MethodDelete, revokeUrl, nil)
+	if err != nil {
+		return &operations.ReconcileError{Message: "Error constructing artifactory token revocation request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	req.Header.Set("Authorization", "Bearer "+bearerToken)
+
+	// Create a custom HTTP client with TLS configuration
+	client, err := createCustomHTTPClient(securityDetails, secretRotatorName)
+	if err != nil {
+		return &operations.ReconcileError{Message: "Error in intialising custom HTTP client with TLS configuration", Cause: err, RetryIn: 1 * time.Minute}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	metrics.ObserveRequest(metrics.ServiceArtifactory, "revoke_token", start, err != nil || resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusNotFound)
+	if err != nil {
+		return &operations.ReconcileError{Message: "Error sending artifactory token revocation request", Cause: err, RetryIn: 1 * time.Minute}
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			logger.Error(err, "Could not close response body")
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		errMessage := fmt.Sprintf("%s%s%s%d%s", "Error revoking artifactory token, token revocation to ", revokeUrl, " returned ", resp.StatusCode, " response")
+		return &operations.ReconcileError{Message: errMessage, RetryIn: 1 * time.Minute}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func newRevocationSecretRotator(artifactoryUrl string, previous ...jfrogv1alpha1.IssuedToken) *jfrogv1alpha1.SecretRotator {
+	return &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			ArtifactoryUrl:  artifactoryUrl,
+			Security:        jfrogv1alpha1.SecurityDetails{Enabled: true, InsecureSkipVerify: true},
+			TokenRevocation: jfrogv1alpha1.TokenRevocationDetails{Enabled: true},
+		},
+		Status: jfrogv1alpha1.SecretRotatorStatus{IssuedTokens: previous},
+	}
+}
+
+func TestTrackIssuedTokens_SupersedesPreviousTokens(t *testing.T) {
+	now := metav1.Now()
+	secretRotator := newRevocationSecretRotator("artifactory.example.com", jfrogv1alpha1.IssuedToken{TokenID: "old", IssuedAt: metav1.NewTime(now.Add(-time.Hour))})
+	tokenDetails := &operations.TokenDetails{Tokens: map[string]*operations.AccessResponse{"": {TokenId: "new", ExpiresIn: 600}}}
+
+	TrackIssuedTokens(tokenDetails, secretRotator, now)
+
+	require.Len(t, secretRotator.Status.IssuedTokens, 1)
+	assert.Equal(t, "new", secretRotator.Status.IssuedTokens[0].TokenID)
+	assert.Equal(t, now.Add(600*time.Second), secretRotator.Status.IssuedTokens[0].ExpiresAt.Time)
+	require.Len(t, secretRotator.Status.SupersededTokens, 1)
+	assert.Equal(t, "old", secretRotator.Status.SupersededTokens[0].TokenID)
+	assert.Equal(t, now, *secretRotator.Status.SupersededTokens[0].SupersededAt)
+}
+
+func TestTrackIssuedTokens_KeepsTokensOfOutdatedSecrets(t *testing.T) {
+	now := metav1.Now()
+	secretRotator := newRevocationSecretRotator("artifactory.example.com", jfrogv1alpha1.IssuedToken{TokenID: "old", IssuedAt: metav1.NewTime(now.Add(-time.Hour))})
+	tokenDetails := &operations.TokenDetails{Tokens: map[string]*operations.AccessResponse{"": {TokenId: "new"}}, SecretsOutdated: true}
+
+	TrackIssuedTokens(tokenDetails, secretRotator, now)
+
+	require.Len(t, secretRotator.Status.IssuedTokens, 2)
+	assert.Equal(t, "new", secretRotator.Status.IssuedTokens[0].TokenID)
+	assert.Equal(t, "old", secretRotator.Status.IssuedTokens[1].TokenID)
+	assert.Empty(t, secretRotator.Status.SupersededTokens)
+}
+
+func TestTrackIssuedTokens_UnchangedTokens(t *testing.T) {
+	issuedAt := metav1.NewTime(metav1.Now().Add(-time.Hour).Truncate(time.Second))
+	secretRotator := newRevocationSecretRotator("artifactory.example.com", jfrogv1alpha1.IssuedToken{TokenID: "current", IssuedAt: issuedAt})
+	previous := secretRotator.Status.DeepCopy()
+	tokenDetails := &operations.TokenDetails{Tokens: map[string]*operations.AccessResponse{"": {TokenId: "current", ExpiresIn: 600}}}
+
+	// the status is not rewritten when the same tokens are reported again
+	TrackIssuedTokens(tokenDetails, secretRotator, metav1.Now())
+
+	assert.Equal(t, previous, &secretRotator.Status)
+}
+
+func TestTrackIssuedTokens_RevocationDisabled(t *testing.T) {
+	now := metav1.Now()
+	secretRotator := newRevocationSecretRotator("artifactory.example.com", jfrogv1alpha1.IssuedToken{TokenID: "old", IssuedAt: metav1.NewTime(now.Add(-time.Hour))})
+	secretRotator.Spec.TokenRevocation.Enabled = false
+	tokenDetails := &operations.TokenDetails{Tokens: map[string]*operations.AccessResponse{"": {TokenId: "new"}}}
+
+	TrackIssuedTokens(tokenDetails, secretRotator, now)
+
+	require.Len(t, secretRotator.Status.IssuedTokens, 1)
+	assert.Empty(t, secretRotator.Status.SupersededTokens)
+}
+
+func TestRevokeSupersededTokens_Success(t *testing.T) {
+	var revoked []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		assert.Equal(t, "Bearer deployers-token", r.Header.Get("Authorization"))
+		revoked = append(revoked, strings.TrimPrefix(r.URL.Path, revokeTokenEndpoint))
+		if strings.HasSuffix(r.URL.Path, "/gone") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := metav1.Now()
+	supersededAt := metav1.NewTime(now.Add(-10 * time.Minute))
+	recentlySupersededAt := metav1.NewTime(now.Add(-time.Minute))
+	expiredAt := metav1.NewTime(now.Add(-time.Second))
+	secretRotator := newRevocationSecretRotator(strings.TrimPrefix(server.URL, "https://"))
+	secretRotator.Status.SupersededTokens = []jfrogv1alpha1.IssuedToken{
+		{TokenID: "old", Scope: "applied-permissions/groups:deployers", SupersededAt: &supersededAt},
+		{TokenID: "gone", Scope: "applied-permissions/groups:deployers", SupersededAt: &supersededAt},
+		{TokenID: "recent", Scope: "applied-permissions/groups:deployers", SupersededAt: &recentlySupersededAt},
+		{TokenID: "expired", Scope: "applied-permissions/groups:deployers", SupersededAt: &supersededAt, ExpiresAt: &expiredAt},
+	}
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl: secretRotator.Spec.ArtifactoryUrl,
+		Tokens:         map[string]*operations.AccessResponse{"applied-permissions/groups:deployers": {TokenId: "new", AccessToken: "deployers-token"}},
+	}
+
+	RevokeSupersededTokens(context.Background(), tokenDetails, secretRotator, record.NewFakeRecorder(10), now)
+
+	assert.Equal(t, []string{"old", "gone"}, revoked)
+	require.Len(t, secretRotator.Status.SupersededTokens, 1)
+	assert.Equal(t, "recent", secretRotator.Status.SupersededTokens[0].TokenID)
+}
+
+func TestRevokeSupersededTokens_FailureIsRetried(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	now := metav1.Now()
+	supersededAt := metav1.NewTime(now.Add(-10 * time.Minute))
+	secretRotator := newRevocationSecretRotator(strings.TrimPrefix(server.URL, "https://"))
+	secretRotator.Status.SupersededTokens = []jfrogv1alpha1.IssuedToken{{TokenID: "old", SupersededAt: &supersededAt}}
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl: secretRotator.Spec.ArtifactoryUrl,
+		Tokens:         map[string]*operations.AccessResponse{"": {TokenId: "new", AccessToken: "default-token"}},
+	}
+	recorder := record.NewFakeRecorder(10)
+
+	RevokeSupersededTokens(context.Background(), tokenDetails, secretRotator, recorder, now)
+
+	require.Len(t, secretRotator.Status.SupersededTokens, 1)
+	assert.Contains(t, <-recorder.Events, "TokenRevocationFailure")
+}
+
+func TestRevokeIssuedTokens_Success(t *testing.T) {
+	revoked := map[string]string{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenID := strings.TrimPrefix(r.URL.Path, revokeTokenEndpoint)
+		revoked[tokenID] = r.Header.Get("Authorization")
+		if tokenID == "stuck" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := metav1.Now()
+	expiredAt := metav1.NewTime(now.Add(-time.Second))
+	secretRotator := newRevocationSecretRotator(strings.TrimPrefix(server.URL, "https://"),
+		jfrogv1alpha1.IssuedToken{TokenID: "live", Scope: "applied-permissions/groups:readers"},
+		jfrogv1alpha1.IssuedToken{TokenID: "stuck"},
+		jfrogv1alpha1.IssuedToken{TokenID: "expired", ExpiresAt: &expiredAt},
+	)
+	secretRotator.Status.SupersededTokens = []jfrogv1alpha1.IssuedToken{{TokenID: "superseded"}}
+	credential := &jfrogv1alpha1.ArtifactoryCredential{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "team-a"},
+		Status: jfrogv1alpha1.ArtifactoryCredentialStatus{
+			IssuedTokens:     []jfrogv1alpha1.IssuedToken{{TokenID: "tenant-live", Scope: "applied-permissions/groups:deployers"}},
+			SupersededTokens: []jfrogv1alpha1.IssuedToken{{TokenID: "tenant-superseded", Scope: "applied-permissions/groups:readers"}},
+		},
+	}
+	credentials := []*jfrogv1alpha1.ArtifactoryCredential{credential}
+	assert.Equal(t, []string{"applied-permissions/groups:readers", "", "applied-permissions/groups:deployers"}, LiveTokenScopes(secretRotator, credentials, now))
+
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl: secretRotator.Spec.ArtifactoryUrl,
+		Tokens: map[string]*operations.AccessResponse{
+			"applied-permissions/groups:readers":   {TokenId: "revoker-readers", AccessToken: "readers-token"},
+			"":                                     {TokenId: "revoker-default", AccessToken: "default-token"},
+			"applied-permissions/groups:deployers": {TokenId: "revoker-deployers", AccessToken: "deployers-token"},
+		},
+	}
+
+	err := RevokeIssuedTokens(context.Background(), tokenDetails, secretRotator, credentials, record.NewFakeRecorder(10), now)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "stuck")
+
+	assert.Equal(t, "Bearer readers-token", revoked["live"])
+	assert.Equal(t, "Bearer default-token", revoked["superseded"])
+	assert.Equal(t, "Bearer readers-token", revoked["revoker-readers"])
+	assert.Equal(t, "Bearer default-token", revoked["revoker-default"])
+	assert.NotContains(t, revoked, "expired")
+	require.Len(t, secretRotator.Status.IssuedTokens, 1)
+	assert.Equal(t, "stuck", secretRotator.Status.IssuedTokens[0].TokenID)
+	assert.Empty(t, secretRotator.Status.SupersededTokens)
+
+	// the tokens of the ArtifactoryCredentials are revoked with the token issued for their scope
+	assert.Equal(t, "Bearer deployers-token", revoked["tenant-live"])
+	assert.Equal(t, "Bearer readers-token", revoked["tenant-superseded"])
+	assert.Empty(t, credential.Status.IssuedTokens)
+	assert.Empty(t, credential.Status.SupersededTokens)
+}
+
+func newRevocationCredential() *jfrogv1alpha1.ArtifactoryCredential {
+	return &jfrogv1alpha1.ArtifactoryCredential{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "team-a"},
+		Spec: jfrogv1alpha1.ArtifactoryCredentialSpec{
+			GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "tenant-token", Scope: "applied-permissions/groups:deployers"}},
+		},
+	}
+}
+
+func TestTrackCredentialTokens_SupersedesPreviousTokens(t *testing.T) {
+	now := metav1.Now()
+	secretRotator := newRevocationSecretRotator("artifactory.example.com", jfrogv1alpha1.IssuedToken{TokenID: "rotator"})
+	credential := newRevocationCredential()
+	credential.Status.IssuedTokens = []jfrogv1alpha1.IssuedToken{{TokenID: "old", Scope: "applied-permissions/groups:deployers"}}
+	tokenDetails := &operations.TokenDetails{
+		Tokens: map[string]*operations.AccessResponse{"": {TokenId: "rotator"}},
+		CredentialTokens: map[types.NamespacedName]map[string]*operations.AccessResponse{
+			operations.CredentialKey(credential): {"applied-permissions/groups:deployers": {TokenId: "new"}},
+		},
+	}
+
+	// the previous token stays issued while a secret of the ArtifactoryCredential still holds it
+	TrackCredentialTokens(tokenDetails, secretRotator, credential, true, now)
+	require.Len(t, credential.Status.IssuedTokens, 2)
+	assert.Empty(t, credential.Status.SupersededTokens)
+
+	TrackCredentialTokens(tokenDetails, secretRotator, credential, false, now)
+	require.Len(t, credential.Status.IssuedTokens, 1)
+	assert.Equal(t, "new", credential.Status.IssuedTokens[0].TokenID)
+	require.Len(t, credential.Status.SupersededTokens, 1)
+	assert.Equal(t, "old", credential.Status.SupersededTokens[0].TokenID)
+	assert.Equal(t, []jfrogv1alpha1.IssuedToken{{TokenID: "rotator"}}, secretRotator.Status.IssuedTokens)
+}
+
+func TestRevokeSupersededCredentialTokens_Success(t *testing.T) {
+	var revoked []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer tenant-token", r.Header.Get("Authorization"))
+		revoked = append(revoked, strings.TrimPrefix(r.URL.Path, revokeTokenEndpoint))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := metav1.Now()
+	supersededAt := metav1.NewTime(now.Add(-10 * time.Minute))
+	secretRotator := newRevocationSecretRotator(strings.TrimPrefix(server.URL, "https://"))
+	credential := newRevocationCredential()
+	credential.Status.SupersededTokens = []jfrogv1alpha1.IssuedToken{{TokenID: "old", Scope: "applied-permissions/groups:deployers", SupersededAt: &supersededAt}}
+	tokenDetails := &operations.TokenDetails{
+		ArtifactoryUrl: secretRotator.Spec.ArtifactoryUrl,
+		Tokens:         map[string]*operations.AccessResponse{"applied-permissions/groups:deployers": {TokenId: "rotator", AccessToken: "rotator-token"}},
+		CredentialTokens: map[types.NamespacedName]map[string]*operations.AccessResponse{
+			operations.CredentialKey(credential): {"applied-permissions/groups:deployers": {TokenId: "new", AccessToken: "tenant-token"}},
+		},
+	}
+
+	RevokeSupersededCredentialTokens(context.Background(), tokenDetails, secretRotator, credential, record.NewFakeRecorder(10), now)
+
+	assert.Equal(t, []string{"old"}, revoked)
+	assert.Empty(t, credential.Status.SupersededTokens)
+}
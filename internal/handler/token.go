@@ -3,8 +3,8 @@ package handler
 import (
 	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
 	k8sClientSet "artifactory-secrets-rotator/internal/client"
-	"errors"
 
+	"artifactory-secrets-rotator/internal/metrics"
 	operations "artifactory-secrets-rotator/internal/operations"
 	"bytes"
 	"context"
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -29,8 +30,7 @@ const tokenEndpoint = "/access/api/v1/aws/token"
 // HandlingToken Get JFrog access token
 func HandlingToken(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, recorder record.EventRecorder, k8sClient client.Client) error {
 	logger := log.FromContext(ctx)
-	var request *http.Request
-	if tokenDetails.Token != "" {
+	if len(tokenDetails.Tokens) > 0 {
 		logger.Info("Token already defined. skipping artifactory token creation")
 		return nil
 	}
@@ -61,70 +61,125 @@ func HandlingToken(ctx context.Context, tokenDetails *operations.TokenDetails, s
 		return err
 	}
 
-	configuredAuthType := secretRotator.Spec.AuthType
-	if configuredAuthType == "" {
-		configuredAuthType = operations.AutoAuthType
+	providerRequest := &ProviderRequest{
+		TokenDetails:   tokenDetails,
+		SecretRotator:  secretRotator,
+		ServiceAccount: serviceAccount,
+		Recorder:       recorder,
+		Clientset:      clientset,
 	}
-	tokenDetails.AuthType = configuredAuthType
+	return IssueTokens(ctx, providerRequest)
+}
 
-	// check if the auth type is pod identity
-	if (configuredAuthType == operations.PodIdentityAuthType || configuredAuthType == operations.AutoAuthType) && operations.DetectPodIdentity() {
-		request, err = GetSignedRequestForPodIdentity(ctx, tokenDetails)
-		if err != nil {
-			return err
-		}
-		tokenDetails.AuthType = operations.PodIdentityAuthType
-	} else if configuredAuthType == operations.WebIdentityAuthType || configuredAuthType == operations.AutoAuthType {
-		request, err = GetSignedRequestForWebIdentity(ctx, tokenDetails, serviceAccount, recorder, clientset, secretRotator)
+// IssueTokens resolves the identity provider of the SecretRotator and issues a JFrog access token for every requested scope
+func IssueTokens(ctx context.Context, request *ProviderRequest) (err error) {
+	logger := log.FromContext(ctx)
+	tokenDetails, secretRotator, recorder := request.TokenDetails, request.SecretRotator, request.Recorder
+
+	authType := secretRotator.Spec.AuthType
+	if authType == "" {
+		authType = operations.AutoAuthType
+	}
+	defer func() {
+		metrics.TokenIssuanceTotal.WithLabelValues(authType, tokenDetails.ArtifactoryUrl).Inc()
 		if err != nil {
-			return err
+			metrics.TokenIssuanceFailuresTotal.WithLabelValues(authType, tokenDetails.ArtifactoryUrl).Inc()
 		}
-		tokenDetails.AuthType = operations.WebIdentityAuthType
+	}()
 
-	} else {
-		recorder.Eventf(secretRotator, "Error", "Misconfiguration",
-			fmt.Sprintf("failed to get the correct auth type (%s) from secretRotator.spec.authType or missing Pod Identity environment (Pod Identity detected: %t)", configuredAuthType, operations.DetectPodIdentity()))
-		return errors.New("failed to get correct auth (auto, podIdentity or webIdentity) or missing pod identity environments for podIdentity auth type")
+	provider, err := ResolveIdentityProvider(ctx, request)
+	if err != nil {
+		recorder.Eventf(secretRotator, "Warning", "Misconfiguration",
+			fmt.Sprintf("failed to resolve an identity provider for auth type (%s), error: %s", secretRotator.Spec.AuthType, err.Error()))
+		return err
 	}
+	tokenDetails.AuthType = provider.Name()
+	authType = provider.Name()
+	logger.Info("Using identity provider", "authType", provider.Name())
 
-	//getting max aws role session time to be used as artiactory token expiration time
-	maxTTL := tokenDetails.RoleMaxSessionDuration
-	if secretRotator.Spec.RefreshInterval == nil {
-		logger.Info("JFrog access token TTL will use AWS role Max Session Duration", "roleMaxSession", maxTTL)
+	credential, err := provider.ObtainCredential(ctx, request)
+	if err != nil {
+		return err
 	}
-
-	// if the maxTTL is not set we will use the default value of 3 hours
-	tokenDetails.TTLInSeconds = float64(*maxTTL)
-	if secretRotator.Spec.RefreshInterval != nil && tokenDetails.TTLInSeconds < secretRotator.Spec.RefreshInterval.Seconds() {
-		// if the token is set to expire before reconciliation runs we will always get into token expire events
-		err = errors.New("the token TTL taken from Role max session value, is shorter then reconciliation duration set through operator refreshTime, which is a misconfiguration causing token expire events")
-		logger.Error(err, "CRITICAL MISS CONFIGURATION")
-		//reflect this mis misconfiguration through the operator events
-		recorder.Eventf(secretRotator, "Warning", "TokenGenerationFailure",
-			fmt.Sprintf("The token TTL taken from Role max session value (%d), is shorter then reconciliation duration set through operator refreshTime (%s), which is a misconfiguration causing token expire events",
-				*maxTTL,
-				secretRotator.Spec.RefreshInterval))
+	// spec.tokenTTL decouples the token lifetime from the IAM role max session duration
+	if tokenTTL := operations.TokenTTLSeconds(secretRotator); tokenTTL != nil {
+		credential.ExpiresIn = tokenTTL
 	}
 
-	logger.Info("Generating artifactory token")
-	tokenDetails.Username, tokenDetails.Token, err = createArtifactoryToken(ctx, request, tokenDetails.ArtifactoryUrl, maxTTL, &secretRotator.Spec.Security, secretRotator.Name)
-	if err != nil {
-		recorder.Eventf(secretRotator, "Warning", "Misconfiguration",
-			fmt.Sprintf("could not get artifactory Token, notice we might ran into expired tokens if this persists, error was %s", err.Error()))
-		return err
+	// Each distinct scope requested by the generated secrets gets its own token, so secrets do not share privileges
+	tokens := make(map[string]*operations.AccessResponse)
+	ttl := float64(0)
+	for _, scope := range tokenDetails.RequestedScopes() {
+		logger.Info("Generating artifactory token", "scope", scope)
+		accessResponse, err := provider.Exchange(ctx, request, credential, scope)
+		if err != nil {
+			recorder.Eventf(secretRotator, "Warning", "TokenGenerationFailure",
+				fmt.Sprintf("could not get artifactory Token for scope '%s' using %s, notice we might ran into expired tokens if this persists, error was %s", scope, provider.Name(), err.Error()))
+			return err
+		}
+		tokens[scope] = accessResponse
+		// the secrets are rotated together, so the shortest lived token drives the rotation
+		if scopeTTL := provider.TTL(credential, accessResponse); ttl == 0 || scopeTTL < ttl {
+			ttl = scopeTTL
+		}
 	}
+	// ArtifactoryCredentials get their own tokens, a scope Artifactory rejects only fails the ArtifactoryCredential requesting it
+	tokenDetails.CredentialTokens = make(map[types.NamespacedName]map[string]*operations.AccessResponse)
+	tokenDetails.CredentialErrors = make(map[types.NamespacedName]error)
+	for i := range tokenDetails.Credentials {
+		artifactoryCredential := &tokenDetails.Credentials[i]
+		key := operations.CredentialKey(artifactoryCredential)
+		credentialTokens, credentialTTL, err := issueCredentialTokens(ctx, request, provider, credential, artifactoryCredential)
+		if err != nil {
+			logger.Error(err, "Could not get artifactory token for ArtifactoryCredential", "artifactoryCredential", artifactoryCredential.Name, "namespace", artifactoryCredential.Namespace)
+			tokenDetails.CredentialErrors[key] = err
+			continue
+		}
+		tokenDetails.CredentialTokens[key] = credentialTokens
+		if credentialTTL > 0 && (ttl == 0 || credentialTTL < ttl) {
+			ttl = credentialTTL
+		}
+	}
+	if ttl <= 0 {
+		// no token was issued, fall back to the default token expiration of 3 hours
+		ttl = operations.RoleMaxSessionDuration
+	}
+	tokenDetails.Tokens = tokens
+	tokenDetails.TTLInSeconds = ttl
+	tokenDetails.IssuedAt = metav1.Now()
+
+	logger.Info("JFrog access token TTL", "ttlInSeconds", ttl, "authType", provider.Name())
 	return nil
 }
 
+// issueCredentialTokens issues a token for every scope requested by the ArtifactoryCredential, and reports the shortest token lifetime
+func issueCredentialTokens(ctx context.Context, request *ProviderRequest, provider IdentityProvider, credential *Credential, artifactoryCredential *jfrogv1alpha1.ArtifactoryCredential) (map[string]*operations.AccessResponse, float64, error) {
+	tokens := make(map[string]*operations.AccessResponse)
+	ttl := float64(0)
+	for _, scope := range operations.CredentialScopes(artifactoryCredential) {
+		accessResponse, err := provider.Exchange(ctx, request, credential, scope)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not get artifactory token for scope '%s' using %s: %w", scope, provider.Name(), err)
+		}
+		tokens[scope] = accessResponse
+		if scopeTTL := provider.TTL(credential, accessResponse); ttl == 0 || scopeTTL < ttl {
+			ttl = scopeTTL
+		}
+	}
+	return tokens, ttl, nil
+}
+
 // createArtifactoryToken triggers a call against to retrieve JFrog access token
-func createArtifactoryToken(ctx context.Context, request *http.Request, artifactoryUrl string, secretTTL *int32, securityDetails *jfrogv1alpha1.SecurityDetails, secretRotatorName string) (string, string, error) {
+func createArtifactoryToken(ctx context.Context, request *http.Request, artifactoryUrl string, secretTTL *int32, scope string, securityDetails *jfrogv1alpha1.SecurityDetails, secretRotatorName string) (*operations.AccessResponse, error) {
 	logger := log.FromContext(ctx)
 	url := fmt.Sprintf("%s%s%s", "https://", artifactoryUrl, tokenEndpoint)
-	requestBody := fmt.Sprintf("%s%d%s", "{\"expires_in\": ", *secretTTL, "}")
-	body := []byte(requestBody)
+	body, err := json.Marshal(operations.AccessRequest{ExpiresIn: *secretTTL, Scope: scope})
+	if err != nil {
+		return nil, &operations.ReconcileError{Message: "Error constructing artifactory request body", Cause: err, RetryIn: 1 * time.Minute}
+	}
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
 	if err != nil {
-		return "", "", &operations.ReconcileError{Message: "Error constructing artifactory request", Cause: err, RetryIn: 1 * time.Minute}
+		return nil, &operations.ReconcileError{Message: "Error constructing artifactory request", Cause: err, RetryIn: 1 * time.Minute}
 	}
 
 	// Set headers if needed
@@ -136,12 +191,14 @@ func createArtifactoryToken(ctx context.Context, request *http.Request, artifact
 	// Create a custom HTTP client with TLS configuration
 	client, err := createCustomHTTPClient(securityDetails, secretRotatorName)
 	if err != nil {
-		return "", "", &operations.ReconcileError{Message: "Error in intialising custom HTTP client with TLS configuration", Cause: err, RetryIn: 1 * time.Minute}
+		return nil, &operations.ReconcileError{Message: "Error in intialising custom HTTP client with TLS configuration", Cause: err, RetryIn: 1 * time.Minute}
 	}
 
+	start := time.Now()
 	resp, err := client.Do(req)
+	metrics.ObserveRequest(metrics.ServiceArtifactory, "aws_token", start, err != nil || resp.StatusCode != http.StatusOK)
 	if err != nil {
-		return "", "", &operations.ReconcileError{Message: "Error sending artifactory create token request", Cause: err, RetryIn: 1 * time.Minute}
+		return nil, &operations.ReconcileError{Message: "Error sending artifactory create token request", Cause: err, RetryIn: 1 * time.Minute}
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -152,15 +209,18 @@ func createArtifactoryToken(ctx context.Context, request *http.Request, artifact
 
 	if resp.StatusCode != http.StatusOK {
 		errMessage := fmt.Sprintf("%s%s%s%d%s", "Error getting artifactory token request, token creation to ", url, " returned ", resp.StatusCode, " response")
-		return "", "", &operations.ReconcileError{Message: errMessage, RetryIn: 1 * time.Minute}
+		return nil, &operations.ReconcileError{Message: errMessage, RetryIn: 1 * time.Minute}
 	}
 	// Read and process the response
 	myResponse := &operations.AccessResponse{}
 	err = json.NewDecoder(resp.Body).Decode(myResponse)
 	if err != nil {
-		return "", "", &operations.ReconcileError{Message: "Error reading artifactory response", RetryIn: 1 * time.Minute}
+		return nil, &operations.ReconcileError{Message: "Error reading artifactory response", RetryIn: 1 * time.Minute}
+	}
+	if myResponse.Scope == "" {
+		myResponse.Scope = scope
 	}
-	return myResponse.Username, myResponse.AccessToken, nil
+	return myResponse, nil
 }
 
 // Create a custom HTTP client with TLS configuration
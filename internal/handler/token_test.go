@@ -0,0 +1,129 @@
+package handler
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCreateArtifactoryToken_SendsScope(t *testing.T) {
+	var received operations.AccessRequest
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, tokenEndpoint, r.URL.Path)
+		assert.Equal(t, "signed", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		_ = json.NewEncoder(w).Encode(operations.AccessResponse{TokenId: "id-1", AccessToken: "token-1", Username: "user-1", Scope: received.Scope})
+	}))
+	defer server.Close()
+
+	signed, err := http.NewRequest(http.MethodGet, "https://sts.amazonaws.com", nil)
+	require.NoError(t, err)
+	signed.Header.Set("Authorization", "signed")
+
+	ttl := int32(600)
+	security := &jfrogv1alpha1.SecurityDetails{Enabled: true, InsecureSkipVerify: true}
+	response, err := createArtifactoryToken(context.Background(), signed, strings.TrimPrefix(server.URL, "https://"), &ttl, "applied-permissions/groups:readers", security, "test-rotator")
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(600), received.ExpiresIn)
+	assert.Equal(t, "applied-permissions/groups:readers", received.Scope)
+	assert.Equal(t, "token-1", response.AccessToken)
+	assert.Equal(t, "applied-permissions/groups:readers", response.Scope)
+}
+
+func TestCreateArtifactoryToken_DefaultScopeOmitted(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		_ = json.NewEncoder(w).Encode(operations.AccessResponse{AccessToken: "token-1", Username: "user-1"})
+	}))
+	defer server.Close()
+
+	signed, err := http.NewRequest(http.MethodGet, "https://sts.amazonaws.com", nil)
+	require.NoError(t, err)
+
+	ttl := int32(600)
+	security := &jfrogv1alpha1.SecurityDetails{Enabled: true, InsecureSkipVerify: true}
+	_, err = createArtifactoryToken(context.Background(), signed, strings.TrimPrefix(server.URL, "https://"), &ttl, "", security, "test-rotator")
+	require.NoError(t, err)
+
+	_, hasScope := body["scope"]
+	assert.False(t, hasScope)
+}
+
+// scopedProvider issues a token per scope and rejects the scopes listed in rejectedScopes
+type scopedProvider struct {
+	rejectedScopes map[string]bool
+}
+
+func (scopedProvider) Name() string { return "scoped" }
+
+func (scopedProvider) Detect(ctx context.Context, request *ProviderRequest) bool { return true }
+
+func (scopedProvider) ObtainCredential(ctx context.Context, request *ProviderRequest) (*Credential, error) {
+	return &Credential{}, nil
+}
+
+func (p scopedProvider) Exchange(ctx context.Context, request *ProviderRequest, credential *Credential, scope string) (*operations.AccessResponse, error) {
+	if p.rejectedScopes[scope] {
+		return nil, errors.New("scope rejected")
+	}
+	return &operations.AccessResponse{TokenId: "id-" + scope, AccessToken: "token-" + scope, Scope: scope, ExpiresIn: 600}, nil
+}
+
+func (scopedProvider) TTL(credential *Credential, response *operations.AccessResponse) float64 {
+	return float64(response.ExpiresIn)
+}
+
+func TestIssueTokens_CredentialsIssuedSeparately(t *testing.T) {
+	RegisterIdentityProvider(scopedProvider{rejectedScopes: map[string]bool{"applied-permissions/admin": true}})
+	newCredential := func(name, scope string) jfrogv1alpha1.ArtifactoryCredential {
+		return jfrogv1alpha1.ArtifactoryCredential{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+			Spec: jfrogv1alpha1.ArtifactoryCredentialSpec{GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{
+				{SecretName: "pull", SecretType: operations.SecretTypeDocker, Scope: scope},
+			}},
+		}
+	}
+	tokenDetails := &operations.TokenDetails{
+		GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: operations.SecretTypeDocker}},
+		Credentials: []jfrogv1alpha1.ArtifactoryCredential{
+			newCredential("readers", "applied-permissions/groups:readers"),
+			newCredential("admins", "applied-permissions/admin"),
+		},
+	}
+	request := &ProviderRequest{
+		TokenDetails:  tokenDetails,
+		SecretRotator: &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{AuthType: "scoped"}},
+		Recorder:      record.NewFakeRecorder(10),
+	}
+
+	// a scope Artifactory rejects only fails the ArtifactoryCredential requesting it
+	require.NoError(t, IssueTokens(context.Background(), request))
+	assert.Equal(t, []string{""}, keys(tokenDetails.Tokens))
+	readers := tokenDetails.CredentialTokens[types.NamespacedName{Namespace: "team-a", Name: "readers"}]
+	require.NotNil(t, readers)
+	assert.Equal(t, "id-applied-permissions/groups:readers", readers["applied-permissions/groups:readers"].TokenId)
+	assert.NotContains(t, tokenDetails.CredentialTokens, types.NamespacedName{Namespace: "team-a", Name: "admins"})
+	assert.ErrorContains(t, tokenDetails.CredentialErrors[types.NamespacedName{Namespace: "team-a", Name: "admins"}], "scope 'applied-permissions/admin'")
+}
+
+func keys(tokens map[string]*operations.AccessResponse) []string {
+	scopes := []string{}
+	for scope := range tokens {
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
@@ -17,8 +17,36 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+func init() {
+	RegisterIdentityProvider(&webIdentityProvider{})
+}
+
+// webIdentityProvider assumes the IAM role annotated on the ServiceAccount with a projected ServiceAccount token (IRSA)
+type webIdentityProvider struct {
+	awsProvider
+}
+
+// Name returns the webIdentity auth type
+func (*webIdentityProvider) Name() string {
+	return operations.WebIdentityAuthType
+}
+
+// Detect reports whether the ServiceAccount is annotated with an IAM role ARN
+func (*webIdentityProvider) Detect(_ context.Context, request *ProviderRequest) bool {
+	return request.ServiceAccount != nil && request.ServiceAccount.Annotations[operations.RoleARNKey] != ""
+}
+
+// ObtainCredential signs a GetCallerIdentity request with the credentials of the assumed IAM role
+func (*webIdentityProvider) ObtainCredential(ctx context.Context, request *ProviderRequest) (*Credential, error) {
+	signedRequest, err := GetSignedRequestForWebIdentity(ctx, request.TokenDetails, request.ServiceAccount, request.Recorder, request.Clientset, request.SecretRotator)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{SignedRequest: signedRequest, ExpiresIn: request.TokenDetails.RoleMaxSessionDuration}, nil
+}
+
 // GetSignedRequestForWebIdentity builds a signed STS GetCallerIdentity request using IRSA (OIDC token + STS AssumeRoleWithWebIdentity).
-func GetSignedRequestForWebIdentity(ctx context.Context, tokenDetails *operations.TokenDetails, serviceAccount *corev1.ServiceAccount, recorder record.EventRecorder, clientset *kubernetes.Clientset, secretRotator *jfrogv1alpha1.SecretRotator) (*http.Request, error) {
+func GetSignedRequestForWebIdentity(ctx context.Context, tokenDetails *operations.TokenDetails, serviceAccount *corev1.ServiceAccount, recorder record.EventRecorder, clientset kubernetes.Interface, secretRotator *jfrogv1alpha1.SecretRotator) (*http.Request, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Using Web Identity (IRSA) flow - assuming IAM role via STS with service account token")
 	var err error
@@ -30,21 +58,14 @@ func GetSignedRequestForWebIdentity(ctx context.Context, tokenDetails *operation
 	}
 
 	// Create token request for the target service account
-	tokenRequest, err := clientset.CoreV1().ServiceAccounts(secretRotator.Spec.ServiceAccount.Namespace).CreateToken(
-		ctx,
-		secretRotator.Spec.ServiceAccount.Name,
-		&authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{Audiences: []string{operations.AmazonAwsSts}, ExpirationSeconds: ptr.Int64(operations.ServiceAccountExpirationSeconds)}},
-		metav1.CreateOptions{},
-	)
+	webIdentityToken, err := CreateServiceAccountToken(ctx, clientset, secretRotator, operations.AmazonAwsSts, recorder)
 	if err != nil {
-		recorder.Eventf(secretRotator, "Warning", "Misconfiguration",
-			fmt.Sprintf("failed to create token for user/service account %s from %s namespace, error: %s", secretRotator.Spec.ServiceAccount.Name, secretRotator.Spec.ServiceAccount.Namespace, err.Error()))
 		return nil, err
 	}
 
 	// getting signed request headers for AWS STS GetCallerIdentity call and check role max session duration
 	// this is needed to get the max session duration for the role ARN
-	request, err := GetSignedRequestAndHandleRoleMaxSession(ctx, roleARN, tokenRequest.Status.Token, secretRotator.Spec.ServiceAccount.Name, secretRotator.Spec.ServiceAccount.Namespace, tokenDetails)
+	request, err := GetSignedRequestAndHandleRoleMaxSession(ctx, roleARN, webIdentityToken, secretRotator.Spec.ServiceAccount.Name, secretRotator.Spec.ServiceAccount.Namespace, tokenDetails)
 	if err != nil {
 		recorder.Eventf(secretRotator, "Warning", "TokenGenerationFailure",
 			fmt.Sprintf("Error getting signed AWS credentials, error was %s", err.Error()))
@@ -54,3 +75,19 @@ func GetSignedRequestForWebIdentity(ctx context.Context, tokenDetails *operation
 	logger.Info("Successfully created signed request for Web Identity")
 	return request, nil
 }
+
+// CreateServiceAccountToken requests a projected token for the target service account with the given audience
+func CreateServiceAccountToken(ctx context.Context, clientset kubernetes.Interface, secretRotator *jfrogv1alpha1.SecretRotator, audience string, recorder record.EventRecorder) (string, error) {
+	tokenRequest, err := clientset.CoreV1().ServiceAccounts(secretRotator.Spec.ServiceAccount.Namespace).CreateToken(
+		ctx,
+		secretRotator.Spec.ServiceAccount.Name,
+		&authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{Audiences: []string{audience}, ExpirationSeconds: ptr.Int64(operations.ServiceAccountExpirationSeconds)}},
+		metav1.CreateOptions{},
+	)
+	if err != nil {
+		recorder.Eventf(secretRotator, "Warning", "Misconfiguration",
+			fmt.Sprintf("failed to create token for user/service account %s from %s namespace, error: %s", secretRotator.Spec.ServiceAccount.Name, secretRotator.Spec.ServiceAccount.Namespace, err.Error()))
+		return "", err
+	}
+	return tokenRequest.Status.Token, nil
+}
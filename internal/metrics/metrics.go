@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "jfrog_registry_operator"
+
+// Result label values
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Services whose call latency is observed
+const (
+	ServiceArtifactory = "artifactory"
+	ServiceSts         = "sts"
+	ServiceGcp         = "gcp"
+	ServiceAzure       = "azure"
+)
+
+var (
+	// TokenIssuanceTotal counts the token issuance attempts by auth type and Artifactory host
+	TokenIssuanceTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_issuance_total",
+		Help:      "Number of Artifactory token issuance attempts by auth type and Artifactory host",
+	}, []string{"auth_type", "artifactory_host"})
+
+	// TokenIssuanceFailuresTotal counts the failed token issuances by auth type and Artifactory host
+	TokenIssuanceFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_issuance_failures_total",
+		Help:      "Number of failed Artifactory token issuances by auth type and Artifactory host",
+	}, []string{"auth_type", "artifactory_host"})
+
+	// SecretWritesTotal counts the generated secret writes by namespace and result
+	SecretWritesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secret_writes_total",
+		Help:      "Number of generated secret creations and updates by namespace and result",
+	}, []string{"namespace", "result"})
+
+	// RequestDurationSeconds observes the latency of Artifactory, STS, Google and Microsoft Entra ID calls
+	RequestDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of Artifactory, STS, Google and Microsoft Entra ID calls by service, operation and result",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "operation", "result"})
+
+	tokenExpiry = newTokenExpiryCollector()
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(TokenIssuanceTotal, TokenIssuanceFailuresTotal, SecretWritesTotal, RequestDurationSeconds, tokenExpiry)
+}
+
+// ObserveRequest records the latency of a call to Artifactory, STS, Google or Microsoft Entra ID started at start
+func ObserveRequest(service, operation string, start time.Time, failed bool) {
+	result := ResultSuccess
+	if failed {
+		result = ResultFailure
+	}
+	RequestDurationSeconds.WithLabelValues(service, operation, result).Observe(time.Since(start).Seconds())
+}
+
+// SetTokenExpiry records when the earliest expiring token of the SecretRotator expires
+func SetTokenExpiry(secretRotatorNamespace, secretRotatorName string, expiresAt time.Time) {
+	tokenExpiry.set(secretRotatorNamespace, secretRotatorName, expiresAt)
+}
+
+// ForgetSecretRotator stops reporting the token expiry of a deleted SecretRotator
+func ForgetSecretRotator(secretRotatorNamespace, secretRotatorName string) {
+	tokenExpiry.delete(secretRotatorNamespace, secretRotatorName)
+}
+
+// tokenExpiryCollector reports the seconds until the tokens of each SecretRotator expire, computed when scraped
+type tokenExpiryCollector struct {
+	desc      *prometheus.Desc
+	mu        sync.Mutex
+	expiresAt map[[2]string]time.Time
+}
+
+func newTokenExpiryCollector() *tokenExpiryCollector {
+	return &tokenExpiryCollector{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "token_expiry_seconds"),
+			"Seconds until the earliest expiring token issued for the SecretRotator expires",
+			[]string{"secretrotator_namespace", "secretrotator"}, nil),
+		expiresAt: map[[2]string]time.Time{},
+	}
+}
+
+func (c *tokenExpiryCollector) set(secretRotatorNamespace, secretRotatorName string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expiresAt[[2]string{secretRotatorNamespace, secretRotatorName}] = expiresAt
+}
+
+func (c *tokenExpiryCollector) delete(secretRotatorNamespace, secretRotatorName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.expiresAt, [2]string{secretRotatorNamespace, secretRotatorName})
+}
+
+// Describe implements prometheus.Collector
+func (c *tokenExpiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *tokenExpiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, expiresAt := range c.expiresAt {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Until(expiresAt).Seconds(), key[0], key[1])
+	}
+}
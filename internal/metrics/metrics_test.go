@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+func TestObserveRequest_Success(t *testing.T) {
+	ObserveRequest(ServiceSts, "get_caller_identity", time.Now(), false)
+	ObserveRequest(ServiceSts, "get_caller_identity", time.Now(), true)
+	ObserveRequest(ServiceSts, "get_caller_identity", time.Now(), true)
+
+	count, err := testutil.GatherAndCount(ctrlmetrics.Registry, "jfrog_registry_operator_request_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+// tokenExpiryValues gathers the token expiry gauge by SecretRotator namespace and name
+func tokenExpiryValues(t *testing.T) map[string]float64 {
+	families, err := ctrlmetrics.Registry.Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "jfrog_registry_operator_token_expiry_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			values[labels["secretrotator_namespace"]+"/"+labels["secretrotator"]] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestTokenExpiry_Success(t *testing.T) {
+	SetTokenExpiry("", "test-rotator", time.Now().Add(time.Hour))
+	SetTokenExpiry("team-a", "team-rotator", time.Now().Add(-time.Minute))
+
+	values := tokenExpiryValues(t)
+	require.Len(t, values, 2)
+	assert.InDelta(t, time.Hour.Seconds(), values["/test-rotator"], 5)
+	assert.Less(t, values["team-a/team-rotator"], float64(0))
+
+	ForgetSecretRotator("team-a", "team-rotator")
+	ForgetSecretRotator("", "test-rotator")
+	assert.Empty(t, tokenExpiryValues(t))
+}
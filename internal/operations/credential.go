@@ -0,0 +1,136 @@
+package operations
+
+import (
+	"artifactory-secrets-rotator/api/v1alpha1"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ReferencesSecretRotator checks if the ArtifactoryCredential references the SecretRotator
+func ReferencesSecretRotator(credential *v1alpha1.ArtifactoryCredential, secretRotator *v1alpha1.SecretRotator) bool {
+	secretRotatorRef := credential.Spec.SecretRotatorRef
+	return secretRotatorRef.Name == secretRotator.Name && secretRotatorRef.Namespace == secretRotator.Namespace
+}
+
+// ValidateCredentialRequest checks the ArtifactoryCredential in the namespace against the credentialPolicy of the SecretRotator it references
+func ValidateCredentialRequest(secretRotator *v1alpha1.SecretRotator, credential *v1alpha1.ArtifactoryCredential, namespace *v1.Namespace) error {
+	policy := secretRotator.Spec.CredentialPolicy
+	if !policy.Enabled {
+		return fmt.Errorf("SecretRotator %s does not accept ArtifactoryCredentials, its credentialPolicy is not enabled", secretRotator.Name)
+	}
+	if policy.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("invalid credentialPolicy.namespaceSelector of SecretRotator %s: %w", secretRotator.Name, err)
+		}
+		if !selector.Matches(labels.Set(namespace.Labels)) {
+			return fmt.Errorf("namespace %s is not allowed to request secrets from SecretRotator %s", namespace.Name, secretRotator.Name)
+		}
+	}
+
+	seenNames := map[string]bool{}
+	for _, gSecret := range credential.Spec.GeneratedSecrets {
+		if gSecret.SecretName == "" {
+			return fmt.Errorf("empty secretName for %s secret", gSecret.SecretType)
+		}
+		if seenNames[gSecret.SecretName] {
+			return fmt.Errorf("duplicate secretName '%s' in generatedSecrets", gSecret.SecretName)
+		}
+		seenNames[gSecret.SecretName] = true
+		if err := ValidateGeneratedSecret(gSecret); err != nil {
+			return err
+		}
+		if len(policy.AllowedSecretTypes) > 0 && !slices.Contains(policy.AllowedSecretTypes, gSecret.SecretType) {
+			return fmt.Errorf("secretType %s of secret %s is not allowed by the credentialPolicy of SecretRotator %s", gSecret.SecretType, gSecret.SecretName, secretRotator.Name)
+		}
+		// tenant templates are executed by the operator, they need to be allowed explicitly
+		if len(policy.AllowedSecretTypes) == 0 && gSecret.SecretType == SecretTypeTemplate {
+			return fmt.Errorf("secretType %s of secret %s is only allowed when listed in credentialPolicy.allowedSecretTypes of SecretRotator %s", gSecret.SecretType, gSecret.SecretName, secretRotator.Name)
+		}
+		// the identity mapping defines the scope of OIDC exchanged tokens
+		if gSecret.Scope != "" && !SupportsScopedTokens(secretRotator) {
+			return fmt.Errorf("scope '%s' of secret %s is not supported, SecretRotator %s uses an OIDC auth type whose identity mapping defines the token scope", gSecret.Scope, gSecret.SecretName, secretRotator.Name)
+		}
+		if !ScopeAllowed(policy.AllowedScopes, gSecret.Scope) {
+			return fmt.Errorf("scope '%s' of secret %s is not allowed by the credentialPolicy of SecretRotator %s", gSecret.Scope, gSecret.SecretName, secretRotator.Name)
+		}
+	}
+	return nil
+}
+
+// ScopeAllowed checks if every entry of the scope matches one of the allowed scopes or glob patterns. Only the default scope is allowed when none are listed.
+// The space separated entries and the comma separated groups or roles of an entry are matched on their own, so a pattern cannot widen to further entries.
+func ScopeAllowed(allowedScopes []string, scope string) bool {
+	if scope == "" {
+		return len(allowedScopes) == 0 || slices.Contains(allowedScopes, "")
+	}
+	entries, ok := ScopeEntries(scope)
+	if !ok {
+		return false
+	}
+	for _, entry := range entries {
+		if !slices.ContainsFunc(allowedScopes, func(pattern string) bool {
+			// patterns are validated by ValidateObjectSpec
+			matched, _ := path.Match(pattern, entry)
+			return pattern != "" && matched
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// ScopeEntries splits the scope into its space separated entries, expanding the comma separated groups or roles of an entry
+// such as applied-permissions/groups:readers,deployers into one entry each. Empty groups are reported as not ok.
+func ScopeEntries(scope string) ([]string, bool) {
+	entries := []string{}
+	for _, entry := range strings.Fields(scope) {
+		if !strings.Contains(entry, ",") {
+			entries = append(entries, entry)
+			continue
+		}
+		// the list follows the last colon of the entry prefix, e.g. applied-permissions/roles:project:role-a,role-b
+		list := entry[:strings.Index(entry, ",")]
+		prefix := list[:strings.LastIndex(list, ":")+1]
+		for _, item := range strings.Split(entry[len(prefix):], ",") {
+			if item == "" {
+				return nil, false
+			}
+			entries = append(entries, prefix+item)
+		}
+	}
+	return entries, len(entries) > 0
+}
+
+// validateCredentialPolicy checks the namespace selector and scope patterns of the credentialPolicy
+func validateCredentialPolicy(secretRotator *v1alpha1.SecretRotator) error {
+	policy := secretRotator.Spec.CredentialPolicy
+	if !policy.Enabled {
+		return nil
+	}
+	if policy.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(policy.NamespaceSelector); err != nil {
+			return &ReconcileError{Message: "Invalid credentialPolicy.namespaceSelector, the current reconciliation cycle will end here", Cause: err}
+		}
+	}
+	for _, pattern := range policy.AllowedScopes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return &ReconcileError{Message: fmt.Sprintf("Invalid credentialPolicy scope pattern '%s', the current reconciliation cycle will end here", pattern), Cause: err}
+		}
+		if strings.ContainsAny(pattern, ", \t\n") {
+			return &ReconcileError{Message: fmt.Sprintf("Invalid credentialPolicy scope pattern '%s', list a single scope entry or group per pattern, the current reconciliation cycle will end here", pattern)}
+		}
+	}
+	for _, secretType := range policy.AllowedSecretTypes {
+		if !slices.Contains(SupportedSecretTypes, secretType) {
+			return &ReconcileError{Message: fmt.Sprintf("Unsupported secret type '%s' in credentialPolicy.allowedSecretTypes, the current reconciliation cycle will end here", secretType)}
+		}
+	}
+	return nil
+}
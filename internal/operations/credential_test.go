@@ -0,0 +1,158 @@
+package operations
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newCredential(gSecrets ...jfrogv1alpha1.GeneratedSecret) *jfrogv1alpha1.ArtifactoryCredential {
+	return &jfrogv1alpha1.ArtifactoryCredential{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-credential", Namespace: "team-a"},
+		Spec: jfrogv1alpha1.ArtifactoryCredentialSpec{
+			SecretRotatorRef: jfrogv1alpha1.SecretRotatorReference{Name: "test-rotator"},
+			GeneratedSecrets: gSecrets,
+		},
+	}
+}
+
+func TestReferencesSecretRotator_Success(t *testing.T) {
+	credential := newCredential()
+	assert.True(t, ReferencesSecretRotator(credential, &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"}}))
+	assert.False(t, ReferencesSecretRotator(credential, &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "other-rotator"}}))
+	assert.False(t, ReferencesSecretRotator(credential, &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", Namespace: "jfrog-operator"}}))
+}
+
+func TestValidateCredentialRequest(t *testing.T) {
+	policy := jfrogv1alpha1.CredentialPolicyDetails{
+		Enabled:            true,
+		NamespaceSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+		AllowedScopes:      []string{"", "applied-permissions/groups:team-*"},
+		AllowedSecretTypes: []string{SecretTypeDocker, SecretTypeGeneric},
+	}
+	tenant := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"tenant": "true"}}}
+	docker := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker}
+
+	tests := []struct {
+		name       string
+		policy     jfrogv1alpha1.CredentialPolicyDetails
+		namespace  *v1.Namespace
+		credential *jfrogv1alpha1.ArtifactoryCredential
+		authType   string
+		wantErr    string
+	}{
+		{name: "approved", policy: policy, namespace: tenant,
+			credential: newCredential(docker, jfrogv1alpha1.GeneratedSecret{SecretName: "deploy", SecretType: SecretTypeGeneric, Scope: "applied-permissions/groups:team-a"})},
+		{name: "policy disabled", policy: jfrogv1alpha1.CredentialPolicyDetails{}, namespace: tenant,
+			credential: newCredential(docker), wantErr: "credentialPolicy is not enabled"},
+		{name: "namespace not selected", policy: policy, namespace: &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+			credential: newCredential(docker), wantErr: "namespace team-a is not allowed"},
+		{name: "secret type not allowed", policy: policy, namespace: tenant,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretName: "netrc", SecretType: SecretTypeNetrc}), wantErr: "secretType netrc"},
+		{name: "template type not listed", policy: jfrogv1alpha1.CredentialPolicyDetails{Enabled: true}, namespace: tenant,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretName: "config", SecretType: SecretTypeTemplate, Template: map[string]string{"config": "{{ .Token }}"}}), wantErr: "only allowed when listed"},
+		{name: "template type listed", policy: jfrogv1alpha1.CredentialPolicyDetails{Enabled: true, AllowedSecretTypes: []string{SecretTypeTemplate}}, namespace: tenant,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretName: "config", SecretType: SecretTypeTemplate, Template: map[string]string{"config": "{{ .Token }}"}})},
+		{name: "scope not allowed", policy: policy, namespace: tenant,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker, Scope: "applied-permissions/admin"}), wantErr: "scope 'applied-permissions/admin'"},
+		{name: "only the default scope without allowed scopes", policy: jfrogv1alpha1.CredentialPolicyDetails{Enabled: true}, namespace: tenant,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker, Scope: "applied-permissions/groups:team-a"}), wantErr: "is not allowed"},
+		{name: "scope widened with a group list", policy: policy, namespace: tenant,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker, Scope: "applied-permissions/groups:team-a,admins"}), wantErr: "is not allowed"},
+		{name: "scope widened with a scope list", policy: policy, namespace: tenant,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker, Scope: "applied-permissions/groups:team-a applied-permissions/admin"}), wantErr: "is not allowed"},
+		{name: "scope with an OIDC auth type", policy: policy, namespace: tenant, authType: KubernetesOidcAuthType,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker, Scope: "applied-permissions/groups:team-a"}), wantErr: "uses an OIDC auth type"},
+		{name: "default scope with an OIDC auth type", policy: policy, namespace: tenant, authType: KubernetesOidcAuthType,
+			credential: newCredential(docker)},
+		{name: "duplicate secret name", policy: policy, namespace: tenant,
+			credential: newCredential(docker, docker), wantErr: "duplicate secretName 'pull'"},
+		{name: "empty secret name", policy: policy, namespace: tenant,
+			credential: newCredential(jfrogv1alpha1.GeneratedSecret{SecretType: SecretTypeDocker}), wantErr: "empty secretName"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretRotator := &jfrogv1alpha1.SecretRotator{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+				Spec:       jfrogv1alpha1.SecretRotatorSpec{CredentialPolicy: tt.policy, AuthType: tt.authType},
+			}
+			err := ValidateCredentialRequest(secretRotator, tt.credential, tt.namespace)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestScopeAllowed(t *testing.T) {
+	allowedScopes := []string{"applied-permissions/groups:team-*", "applied-permissions/roles:project:reader"}
+	tests := []struct {
+		scope   string
+		allowed bool
+	}{
+		{scope: "", allowed: false},
+		{scope: "applied-permissions/groups:team-a", allowed: true},
+		{scope: "applied-permissions/groups:team-a,team-b", allowed: true},
+		{scope: "applied-permissions/groups:team-a applied-permissions/groups:team-b", allowed: true},
+		{scope: "applied-permissions/groups:team-a,admins", allowed: false},
+		{scope: "applied-permissions/groups:team-a applied-permissions/admin", allowed: false},
+		{scope: "applied-permissions/groups:team-a,", allowed: false},
+		{scope: "applied-permissions/roles:project:reader", allowed: true},
+		{scope: "applied-permissions/roles:project:reader,admin", allowed: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.allowed, ScopeAllowed(allowedScopes, tt.scope), tt.scope)
+	}
+	assert.True(t, ScopeAllowed(nil, ""))
+	assert.False(t, ScopeAllowed(nil, "applied-permissions/groups:team-a"))
+}
+
+func TestValidateCredentialPolicy(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{CredentialPolicy: jfrogv1alpha1.CredentialPolicyDetails{
+		Enabled: true, AllowedScopes: []string{"applied-permissions/groups:team-["},
+	}}}
+	assert.ErrorContains(t, validateCredentialPolicy(secretRotator), "Invalid credentialPolicy scope pattern")
+
+	secretRotator.Spec.CredentialPolicy.AllowedScopes = []string{"applied-permissions/groups:team-a,team-*"}
+	assert.ErrorContains(t, validateCredentialPolicy(secretRotator), "list a single scope entry or group per pattern")
+
+	secretRotator.Spec.CredentialPolicy.AllowedScopes = nil
+	secretRotator.Spec.CredentialPolicy.AllowedSecretTypes = []string{"unknown"}
+	assert.ErrorContains(t, validateCredentialPolicy(secretRotator), "Unsupported secret type 'unknown'")
+
+	// a disabled policy is not validated
+	secretRotator.Spec.CredentialPolicy.Enabled = false
+	assert.NoError(t, validateCredentialPolicy(secretRotator))
+}
+
+func TestRequestedScopes_Credentials(t *testing.T) {
+	credential := newCredential(
+		jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker},
+		jfrogv1alpha1.GeneratedSecret{SecretName: "deploy", SecretType: SecretTypeGeneric, Scope: "applied-permissions/groups:team-a"},
+		jfrogv1alpha1.GeneratedSecret{SecretName: "npmrc", SecretType: SecretTypeNpm, Scope: "applied-permissions/groups:team-a"},
+	)
+	tokenDetails := &TokenDetails{
+		GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: SecretTypeDocker}},
+		Credentials:      []jfrogv1alpha1.ArtifactoryCredential{*credential},
+		Tokens:           map[string]*AccessResponse{"": {TokenId: "rotator-id"}},
+		CredentialTokens: map[types.NamespacedName]map[string]*AccessResponse{
+			{Namespace: "team-a", Name: "team-credential"}: {"applied-permissions/groups:team-a": {TokenId: "credential-id"}},
+		},
+	}
+	// the scopes of the ArtifactoryCredentials are issued separately from the SecretRotator ones
+	assert.Equal(t, []string{""}, tokenDetails.RequestedScopes())
+	assert.Equal(t, []string{"", "applied-permissions/groups:team-a"}, CredentialScopes(credential))
+
+	credentialTokenDetails := tokenDetails.ForCredential(credential)
+	assert.Nil(t, credentialTokenDetails.TokenForScope(""))
+	assert.Equal(t, "credential-id", credentialTokenDetails.TokenForScope("applied-permissions/groups:team-a").TokenId)
+	assert.Equal(t, "rotator-id", tokenDetails.TokenForScope("").TokenId)
+}
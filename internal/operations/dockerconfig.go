@@ -0,0 +1,129 @@
+package operations
+
+import (
+	"artifactory-secrets-rotator/api/v1alpha1"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// IsMergedDockerSecret reports whether the generated secret merges its auths entries into a shared docker config
+func IsMergedDockerSecret(gSecret v1alpha1.GeneratedSecret) bool {
+	return gSecret.SecretType == SecretTypeDocker && gSecret.Docker != nil && gSecret.Docker.Merge
+}
+
+// MergeDockerConfig merges the auths entries of the rendered docker config into the existing docker config.
+// The entries the SecretRotator previously owned and no longer renders are removed, the entries and other fields
+// of other writers are preserved. It returns the merged docker config and the managed auths annotation value.
+func MergeDockerConfig(existing, rendered []byte, managedAuths, secretRotatorName string) ([]byte, string, error) {
+	config, auths, err := parseDockerConfig(existing)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse the existing docker config %w", err)
+	}
+	_, renderedAuths, err := parseDockerConfig(rendered)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse the rendered docker config %w", err)
+	}
+	owners, err := parseManagedAuths(managedAuths)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, registry := range owners[secretRotatorName] {
+		delete(auths, registry)
+	}
+	registries := make([]string, 0, len(renderedAuths))
+	for registry, auth := range renderedAuths {
+		auths[registry] = auth
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+	owners[secretRotatorName] = registries
+
+	return marshalDockerConfig(config, auths, owners)
+}
+
+// UnmergeDockerConfig removes the auths entries the SecretRotator owns from a merged docker config secret.
+// It reports whether the SecretRotator merged into the secret and how many auths entries remain.
+func UnmergeDockerConfig(secret *v1.Secret, secretRotatorName string) (bool, int, error) {
+	owners, err := parseManagedAuths(secret.Annotations[ManagedAuthsAnnotation])
+	if err != nil {
+		return false, 0, err
+	}
+	registries, merged := owners[secretRotatorName]
+	if !merged {
+		return false, 0, nil
+	}
+
+	config, auths, err := parseDockerConfig(secret.Data[DockerSecretJSON])
+	if err != nil {
+		return true, 0, fmt.Errorf("failed to parse the docker config of secret %s %w", secret.Name, err)
+	}
+	for _, registry := range registries {
+		delete(auths, registry)
+	}
+	delete(owners, secretRotatorName)
+
+	dockerConfig, managedAuths, err := marshalDockerConfig(config, auths, owners)
+	if err != nil {
+		return true, 0, err
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[DockerSecretJSON] = dockerConfig
+	if len(owners) == 0 {
+		delete(secret.Annotations, ManagedAuthsAnnotation)
+	} else {
+		secret.Annotations[ManagedAuthsAnnotation] = managedAuths
+	}
+	return true, len(auths), nil
+}
+
+// parseDockerConfig parses the fields and the auths entries of a docker config, keeping their raw values
+func parseDockerConfig(dockerConfig []byte) (map[string]json.RawMessage, map[string]json.RawMessage, error) {
+	config, auths := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	if len(dockerConfig) == 0 {
+		return config, auths, nil
+	}
+	if err := json.Unmarshal(dockerConfig, &config); err != nil {
+		return nil, nil, err
+	}
+	if rawAuths, ok := config["auths"]; ok {
+		if err := json.Unmarshal(rawAuths, &auths); err != nil {
+			return nil, nil, err
+		}
+	}
+	return config, auths, nil
+}
+
+// parseManagedAuths parses the registries each SecretRotator owns from the managed auths annotation
+func parseManagedAuths(managedAuths string) (map[string][]string, error) {
+	owners := map[string][]string{}
+	if managedAuths == "" {
+		return owners, nil
+	}
+	if err := json.Unmarshal([]byte(managedAuths), &owners); err != nil {
+		return nil, fmt.Errorf("failed to parse the %s annotation %w", ManagedAuthsAnnotation, err)
+	}
+	return owners, nil
+}
+
+func marshalDockerConfig(config, auths map[string]json.RawMessage, owners map[string][]string) ([]byte, string, error) {
+	authsBytes, err := json.Marshal(auths)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal docker config auths %w", err)
+	}
+	config["auths"] = authsBytes
+	dockerConfig, err := json.Marshal(config)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal docker config %w", err)
+	}
+	managedAuths, err := json.Marshal(owners)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal the %s annotation %w", ManagedAuthsAnnotation, err)
+	}
+	return dockerConfig, string(managedAuths), nil
+}
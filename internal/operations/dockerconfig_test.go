@@ -0,0 +1,56 @@
+package operations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergeDockerConfig_Success(t *testing.T) {
+	existing := `{"credsStore": "ecr-login", "auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOnRva2Vu"},
+		"acme.jfrog.io": {"auth": "b2xkOnRva2Vu"},
+		"old.acme.jfrog.io": {"auth": "b2xkOnRva2Vu"}}}`
+	rendered := `{"auths": {"acme.jfrog.io": {"auth": "bmV3OnRva2Vu"}, "docker.acme.jfrog.io": {"auth": "bmV3OnRva2Vu"}}}`
+	managedAuths := `{"test-rotator": ["acme.jfrog.io", "old.acme.jfrog.io"], "other-rotator": ["other.jfrog.io"]}`
+
+	dockerConfig, managedAuths, err := MergeDockerConfig([]byte(existing), []byte(rendered), managedAuths, "test-rotator")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"credsStore": "ecr-login", "auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOnRva2Vu"},
+		"acme.jfrog.io": {"auth": "bmV3OnRva2Vu"},
+		"docker.acme.jfrog.io": {"auth": "bmV3OnRva2Vu"}}}`, string(dockerConfig))
+	assert.JSONEq(t, `{"test-rotator": ["acme.jfrog.io", "docker.acme.jfrog.io"], "other-rotator": ["other.jfrog.io"]}`, managedAuths)
+
+	// a new secret holds only the rendered entries
+	dockerConfig, managedAuths, err = MergeDockerConfig(nil, []byte(rendered), "", "test-rotator")
+	require.NoError(t, err)
+	assert.JSONEq(t, rendered, string(dockerConfig))
+	assert.JSONEq(t, `{"test-rotator": ["acme.jfrog.io", "docker.acme.jfrog.io"]}`, managedAuths)
+
+	_, _, err = MergeDockerConfig([]byte("not json"), []byte(rendered), "", "test-rotator")
+	assert.Error(t, err)
+}
+
+func TestUnmergeDockerConfig_Success(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull", Annotations: map[string]string{
+			ManagedAuthsAnnotation: `{"test-rotator": ["acme.jfrog.io"]}`,
+		}},
+		Data: map[string][]byte{DockerSecretJSON: []byte(`{"auths": {"acme.jfrog.io": {"auth": "bmV3OnRva2Vu"}, "ghcr.io": {"auth": "Z2g6dG9rZW4="}}}`)},
+	}
+
+	merged, remainingAuths, err := UnmergeDockerConfig(secret, "other-rotator")
+	require.NoError(t, err)
+	assert.False(t, merged)
+
+	merged, remainingAuths, err = UnmergeDockerConfig(secret, "test-rotator")
+	require.NoError(t, err)
+	assert.True(t, merged)
+	assert.Equal(t, 1, remainingAuths)
+	assert.JSONEq(t, `{"auths": {"ghcr.io": {"auth": "Z2g6dG9rZW4="}}}`, string(secret.Data[DockerSecretJSON]))
+	assert.NotContains(t, secret.Annotations, ManagedAuthsAnnotation)
+}
@@ -0,0 +1,122 @@
+package operations
+
+import (
+	"artifactory-secrets-rotator/api/v1alpha1"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ImagePullServiceAccounts returns the ServiceAccounts selection of a docker secret, nil when it is not attached to ServiceAccounts
+func ImagePullServiceAccounts(gSecret v1alpha1.GeneratedSecret) *v1alpha1.ServiceAccountSelector {
+	if gSecret.SecretType != SecretTypeDocker || gSecret.Docker == nil {
+		return nil
+	}
+	return gSecret.Docker.ServiceAccounts
+}
+
+// AttachesImagePullSecrets reports whether one of the secrets is attached to ServiceAccounts
+func AttachesImagePullSecrets(gSecrets []v1alpha1.GeneratedSecret) bool {
+	return slices.ContainsFunc(gSecrets, func(gSecret v1alpha1.GeneratedSecret) bool {
+		return ImagePullServiceAccounts(gSecret) != nil
+	})
+}
+
+// AttachImagePullSecret adds the secret to the imagePullSecrets of the selected ServiceAccounts in the namespace.
+// References the operator added to ServiceAccounts no longer selected are removed, references added by others are kept.
+func AttachImagePullSecret(ctx context.Context, k8sClient client.Client, namespace, secretName string, serviceAccounts *v1alpha1.ServiceAccountSelector) error {
+	selector := labels.Nothing()
+	names := serviceAccounts.Names
+	if serviceAccounts.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(serviceAccounts.Selector); err != nil {
+			return fmt.Errorf("invalid serviceAccounts selector of secret %s: %w", secretName, err)
+		}
+	} else if len(names) == 0 {
+		names = []string{DefaultServiceAccountName}
+	}
+
+	serviceAccountList := &v1.ServiceAccountList{}
+	if err := k8sClient.List(ctx, serviceAccountList, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("failed to list service accounts in namespace %s: %w", namespace, err)
+	}
+	var errs []error
+	for i := range serviceAccountList.Items {
+		serviceAccount := &serviceAccountList.Items[i]
+		selected := slices.Contains(names, serviceAccount.Name) || selector.Matches(labels.Set(serviceAccount.Labels))
+		if err := patchImagePullSecret(ctx, k8sClient, serviceAccount, secretName, selected); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DetachImagePullSecret removes the secret from the imagePullSecrets of the ServiceAccounts in the namespace the operator added it to
+func DetachImagePullSecret(ctx context.Context, k8sClient client.Client, namespace, secretName string) error {
+	serviceAccountList := &v1.ServiceAccountList{}
+	if err := k8sClient.List(ctx, serviceAccountList, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("failed to list service accounts in namespace %s: %w", namespace, err)
+	}
+	var errs []error
+	for i := range serviceAccountList.Items {
+		if err := patchImagePullSecret(ctx, k8sClient, &serviceAccountList.Items[i], secretName, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// patchImagePullSecret adds or removes the secret reference of the ServiceAccount, tracking the references
+// the operator added in the ImagePullSecretsAnnotation. Only tracked references are removed.
+func patchImagePullSecret(ctx context.Context, k8sClient client.Client, serviceAccount *v1.ServiceAccount, secretName string, attach bool) error {
+	attached := attachedImagePullSecrets(serviceAccount)
+	referenced := slices.ContainsFunc(serviceAccount.ImagePullSecrets, func(reference v1.LocalObjectReference) bool {
+		return reference.Name == secretName
+	})
+	tracked := slices.Contains(attached, secretName)
+
+	patch := client.MergeFromWithOptions(serviceAccount.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	switch {
+	case attach && !referenced:
+		serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, v1.LocalObjectReference{Name: secretName})
+		attached = append(attached, secretName)
+	case !attach && tracked:
+		serviceAccount.ImagePullSecrets = slices.DeleteFunc(serviceAccount.ImagePullSecrets, func(reference v1.LocalObjectReference) bool {
+			return reference.Name == secretName
+		})
+		attached = slices.DeleteFunc(attached, func(name string) bool { return name == secretName })
+	default:
+		return nil
+	}
+
+	sort.Strings(attached)
+	if serviceAccount.Annotations == nil {
+		serviceAccount.Annotations = map[string]string{}
+	}
+	if len(attached) == 0 {
+		delete(serviceAccount.Annotations, ImagePullSecretsAnnotation)
+	} else {
+		serviceAccount.Annotations[ImagePullSecretsAnnotation] = strings.Join(attached, ",")
+	}
+	if err := k8sClient.Patch(ctx, serviceAccount, patch); err != nil {
+		return fmt.Errorf("failed to patch the imagePullSecrets of service account %s in namespace %s: %w", serviceAccount.Name, serviceAccount.Namespace, err)
+	}
+	return nil
+}
+
+// attachedImagePullSecrets returns the secrets the operator added to the imagePullSecrets of the ServiceAccount
+func attachedImagePullSecrets(serviceAccount *v1.ServiceAccount) []string {
+	value := serviceAccount.Annotations[ImagePullSecretsAnnotation]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -0,0 +1,78 @@
+package operations
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newServiceAccount(name string, labels map[string]string, imagePullSecrets ...string) *corev1.ServiceAccount {
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", Labels: labels}}
+	for _, secretName := range imagePullSecrets {
+		serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, corev1.LocalObjectReference{Name: secretName})
+	}
+	return serviceAccount
+}
+
+func getServiceAccount(t *testing.T, k8sClient client.Client, name string) *corev1.ServiceAccount {
+	serviceAccount := &corev1.ServiceAccount{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "team-a", Name: name}, serviceAccount))
+	return serviceAccount
+}
+
+func TestAttachImagePullSecret_Success(t *testing.T) {
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newServiceAccount("default", nil),
+		newServiceAccount("builder", map[string]string{"pulls": "artifactory"}, "pull"),
+		newServiceAccount("deployer", map[string]string{"pulls": "artifactory"}, "ghcr"),
+	).Build()
+
+	// without names or selector only the default ServiceAccount is selected
+	require.NoError(t, AttachImagePullSecret(context.Background(), k8sClient, "team-a", "pull", &jfrogv1alpha1.ServiceAccountSelector{}))
+	defaultServiceAccount := getServiceAccount(t, k8sClient, "default")
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "pull"}}, defaultServiceAccount.ImagePullSecrets)
+	assert.Equal(t, "pull", defaultServiceAccount.Annotations[ImagePullSecretsAnnotation])
+	assert.Empty(t, getServiceAccount(t, k8sClient, "deployer").Annotations)
+
+	// the selection moves to the labelled ServiceAccounts, the reference builder already held is not tracked
+	selector := &jfrogv1alpha1.ServiceAccountSelector{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"pulls": "artifactory"}}}
+	require.NoError(t, AttachImagePullSecret(context.Background(), k8sClient, "team-a", "pull", selector))
+	assert.Empty(t, getServiceAccount(t, k8sClient, "default").ImagePullSecrets)
+	deployer := getServiceAccount(t, k8sClient, "deployer")
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "ghcr"}, {Name: "pull"}}, deployer.ImagePullSecrets)
+	assert.Equal(t, "pull", deployer.Annotations[ImagePullSecretsAnnotation])
+	builder := getServiceAccount(t, k8sClient, "builder")
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "pull"}}, builder.ImagePullSecrets)
+	assert.NotContains(t, builder.Annotations, ImagePullSecretsAnnotation)
+
+	// only the references the operator added are removed
+	require.NoError(t, DetachImagePullSecret(context.Background(), k8sClient, "team-a", "pull"))
+	deployer = getServiceAccount(t, k8sClient, "deployer")
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "ghcr"}}, deployer.ImagePullSecrets)
+	assert.NotContains(t, deployer.Annotations, ImagePullSecretsAnnotation)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "pull"}}, getServiceAccount(t, k8sClient, "builder").ImagePullSecrets)
+}
+
+func TestDeleteOutdatedGeneratedSecrets_DetachesImagePullSecret(t *testing.T) {
+	serviceAccount := newServiceAccount("default", nil, "pull")
+	serviceAccount.Annotations = map[string]string{ImagePullSecretsAnnotation: "pull"}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		serviceAccount,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "pull", Namespace: "team-a"}},
+	).Build()
+	tokenDetails := &TokenDetails{SecretManagedByNamespaces: map[string][]string{"team-a": {}}}
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+		Status:     jfrogv1alpha1.SecretRotatorStatus{SecretManagedByNamespaces: map[string][]string{"team-a": {"pull"}}},
+	}
+
+	require.NoError(t, DeleteOutdatedGeneratedSecrets(context.Background(), tokenDetails, secretRotator, k8sClient))
+	assert.Empty(t, getServiceAccount(t, k8sClient, "default").ImagePullSecrets)
+}
@@ -4,16 +4,21 @@ import (
 	k8sClientSet "artifactory-secrets-rotator/internal/client"
 	"context"
 	"fmt"
-	"math/rand"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/go-logr/logr"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"artifactory-secrets-rotator/api/v1alpha1"
@@ -37,8 +42,9 @@ func ValidateObjectSpec(ctx context.Context, tokenDetails *TokenDetails, secretR
 		logger.Info("Using existing secret name spec.secretName, This will be deprecated soon. If new secret name added in new config this will be appended", "secretName", secretRotator.Spec.SecretName)
 	}
 
-	// Validate that at least one secret is defined
-	if len(tokenDetails.GeneratedSecrets) == 0 {
+	// Validate that at least one secret is defined, a SecretRotator with an enabled credentialPolicy may only serve ArtifactoryCredentials
+	credentialsOnly := len(tokenDetails.GeneratedSecrets) == 0 && secretRotator.Spec.CredentialPolicy.Enabled
+	if len(tokenDetails.GeneratedSecrets) == 0 && !credentialsOnly {
 		return &ReconcileError{
 			Message: "No secrets defined in spec.generatedSecrets and spec.secretName. Please configure secret details. The current reconciliation cycle will end here.",
 		}
@@ -62,12 +68,28 @@ func ValidateObjectSpec(ctx context.Context, tokenDetails *TokenDetails, secretR
 			}
 		}
 
-		if gSecret.SecretType != SecretTypeDocker && gSecret.SecretType != SecretTypeGeneric {
+		if err := ValidateGeneratedSecret(gSecret); err != nil {
 			return &ReconcileError{
-				Message: fmt.Sprintf("Invalid SecretType '%s' in generatedSecrets. Must be 'docker' or 'generic'. The current reconciliation cycle will end here.", gSecret.SecretType),
+				Message: fmt.Sprintf("%s. The current reconciliation cycle will end here.", err.Error()),
 			}
 		}
 
+		// The identity mapping defines the scope of OIDC exchanged tokens, a requested scope would be silently dropped
+		if gSecret.Scope != "" && !SupportsScopedTokens(secretRotator) {
+			return &ReconcileError{
+				Message: fmt.Sprintf("Scope '%s' of secret '%s' is not supported with the OIDC auth types, the identity mapping defines the token scope. The current reconciliation cycle will end here.", gSecret.Scope, gSecret.SecretName),
+			}
+		}
+	}
+
+	if err := validateTokenLifetime(secretRotator); err != nil {
+		return err
+	}
+
+	if selector := secretRotator.Spec.RestartPolicy.Selector; selector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			return &ReconcileError{Message: "Invalid restartPolicy.selector, the current reconciliation cycle will end here", Cause: err}
+		}
 	}
 
 	tokenDetails.NamespaceSelector, err = metav1.LabelSelectorAsSelector(&secretRotator.Spec.NamespaceSelector)
@@ -75,10 +97,27 @@ func ValidateObjectSpec(ctx context.Context, tokenDetails *TokenDetails, secretR
 		return &ReconcileError{Message: "Error reading namespace labels selector from operator object configuration, no secrets will be created or updated, the current reconciliation cycle will end here", Cause: err}
 	}
 
+	if err := validateNamespacePatterns(secretRotator); err != nil {
+		return err
+	}
+
+	if err := validateCredentialPolicy(secretRotator); err != nil {
+		return err
+	}
+
+	// Without generated secrets no namespace is provisioned, the secrets of previously provisioned ones are deleted
 	tokenDetails.NamespaceList = v1.NamespaceList{}
-	err = k8sClient.List(ctx, &tokenDetails.NamespaceList, &client.ListOptions{LabelSelector: tokenDetails.NamespaceSelector})
-	if err != nil || len(tokenDetails.NamespaceList.Items) == 0 {
-		return &ReconcileError{Message: "No namespaces match the configured namespace selector, the current reconciliation cycle will end here", Cause: err}
+	if !credentialsOnly {
+		err = k8sClient.List(ctx, &tokenDetails.NamespaceList, &client.ListOptions{LabelSelector: tokenDetails.NamespaceSelector})
+		if err == nil {
+			// Drop the namespaces not listed in spec.namespaces, excluded or opted out
+			tokenDetails.NamespaceList.Items = slices.DeleteFunc(tokenDetails.NamespaceList.Items, func(namespace v1.Namespace) bool {
+				return !NamespaceIncluded(secretRotator, &namespace)
+			})
+		}
+		if err != nil || len(tokenDetails.NamespaceList.Items) == 0 {
+			return &ReconcileError{Message: "No namespaces match the configured namespace selector, the current reconciliation cycle will end here", Cause: err}
+		}
 	}
 
 	// Log GeneratedSecrets for debugging
@@ -96,13 +135,8 @@ func ValidateObjectSpec(ctx context.Context, tokenDetails *TokenDetails, secretR
 		tokenDetails.IAMRoleAwsRegion = AwsRegion
 	}
 
-	// Check if artifactory host contains http or https
 	// If the operator was configured with full URI, remove http or https
-	if len(tokenDetails.ArtifactoryUrl) > 8 && tokenDetails.ArtifactoryUrl[:8] == "https://" {
-		tokenDetails.ArtifactoryUrl = tokenDetails.ArtifactoryUrl[8:]
-	} else if len(tokenDetails.ArtifactoryUrl) > 7 && tokenDetails.ArtifactoryUrl[:7] == "http://" {
-		tokenDetails.ArtifactoryUrl = tokenDetails.ArtifactoryUrl[7:]
-	}
+	tokenDetails.ArtifactoryUrl = ArtifactoryHost(tokenDetails.ArtifactoryUrl)
 
 	// Get the service account details. If not provided, the operator's service account will be used by default.
 	serviceAccount, err := GetServiceAccount(ctx, k8sClient, tokenDetails)
@@ -111,7 +145,8 @@ func ValidateObjectSpec(ctx context.Context, tokenDetails *TokenDetails, secretR
 	}
 
 	// Check if the service account name and namespace are provided in the custom resource, if not, updating the custom resource with the operator's service account name and namespace
-	if secretRotator.Spec.ServiceAccount.Name == "" || secretRotator.Spec.ServiceAccount.Namespace == "" {
+	// The role ARN annotation is only required by the AWS auth types
+	if (secretRotator.Spec.ServiceAccount.Name == "" || secretRotator.Spec.ServiceAccount.Namespace == "") && RequiresAwsRole(secretRotator) {
 		logger.Info("Service account name and namespace not provided in the custom resource, using the operator's service account")
 		roleARN := serviceAccount.Annotations[AwsRoleARNKey]
 		if roleARN == "" && !DetectPodIdentity() {
@@ -124,6 +159,196 @@ func ValidateObjectSpec(ctx context.Context, tokenDetails *TokenDetails, secretR
 	return nil
 }
 
+// ArtifactoryHost strips the http or https scheme from the configured Artifactory URL
+func ArtifactoryHost(artifactoryUrl string) string {
+	if len(artifactoryUrl) > 8 && artifactoryUrl[:8] == "https://" {
+		return artifactoryUrl[8:]
+	} else if len(artifactoryUrl) > 7 && artifactoryUrl[:7] == "http://" {
+		return artifactoryUrl[7:]
+	}
+	return artifactoryUrl
+}
+
+// ValidateGeneratedSecret checks the secret type, its type specific configuration and the renamed data keys
+func ValidateGeneratedSecret(gSecret v1alpha1.GeneratedSecret) error {
+	if err := validateSecretTypeDetails(gSecret); err != nil {
+		return err
+	}
+	return validateDataKeys(gSecret)
+}
+
+// validateSecretTypeDetails checks the secret type and its type specific configuration
+func validateSecretTypeDetails(gSecret v1alpha1.GeneratedSecret) error {
+	switch gSecret.SecretType {
+	case SecretTypeGeneric, SecretTypeBasicAuth, SecretTypeGradle, SecretTypeNetrc:
+		return nil
+	case SecretTypeDocker:
+		if serviceAccounts := ImagePullServiceAccounts(gSecret); serviceAccounts != nil && serviceAccounts.Selector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(serviceAccounts.Selector); err != nil {
+				return fmt.Errorf("docker secret '%s' in generatedSecrets has invalid docker.serviceAccounts.selector: %s", gSecret.SecretName, err)
+			}
+		}
+		return nil
+	case SecretTypeTemplate:
+		return validateSecretTemplate(gSecret)
+	case SecretTypeArgocd:
+		if gSecret.Argocd == nil {
+			return nil
+		}
+		if !slices.Contains([]string{"", ArgocdRepoCreds, ArgocdRepository}, gSecret.Argocd.CredentialType) {
+			return fmt.Errorf("argocd secret '%s' in generatedSecrets has invalid argocd.credentialType '%s', must be %s or %s", gSecret.SecretName, gSecret.Argocd.CredentialType, ArgocdRepoCreds, ArgocdRepository)
+		}
+		if !slices.Contains([]string{"", ArgocdRepositoryTypeHelm, ArgocdRepositoryTypeOci}, gSecret.Argocd.RepositoryType) {
+			return fmt.Errorf("argocd secret '%s' in generatedSecrets has invalid argocd.repositoryType '%s', must be %s or %s", gSecret.SecretName, gSecret.Argocd.RepositoryType, ArgocdRepositoryTypeHelm, ArgocdRepositoryTypeOci)
+		}
+		if gSecret.Argocd.CredentialType == ArgocdRepository && gSecret.Argocd.Repository == "" {
+			return fmt.Errorf("argocd secret '%s' in generatedSecrets needs argocd.repository with credentialType %s", gSecret.SecretName, ArgocdRepository)
+		}
+		return nil
+	case SecretTypeFlux:
+		if gSecret.Flux == nil {
+			return nil
+		}
+		if !slices.Contains([]string{"", FluxRepositoryTypeHelm, FluxRepositoryTypeOci}, gSecret.Flux.RepositoryType) {
+			return fmt.Errorf("flux secret '%s' in generatedSecrets has invalid flux.repositoryType '%s', must be %s or %s", gSecret.SecretName, gSecret.Flux.RepositoryType, FluxRepositoryTypeHelm, FluxRepositoryTypeOci)
+		}
+		if gSecret.Flux.IncludeCA && gSecret.Flux.RepositoryType == FluxRepositoryTypeOci {
+			return fmt.Errorf("flux secret '%s' in generatedSecrets sets flux.includeCA with repositoryType %s, oci sources read the CA from their certSecretRef", gSecret.SecretName, FluxRepositoryTypeOci)
+		}
+		return nil
+	case SecretTypePip:
+		if gSecret.Pip == nil || gSecret.Pip.Repository == "" {
+			return fmt.Errorf("pip secret '%s' in generatedSecrets needs pip.repository", gSecret.SecretName)
+		}
+		return nil
+	case SecretTypeMaven:
+		if gSecret.Maven == nil || (len(gSecret.Maven.Repositories) == 0 && gSecret.Maven.MirrorRepository == "") {
+			return fmt.Errorf("maven secret '%s' in generatedSecrets needs maven.repositories or maven.mirrorRepository", gSecret.SecretName)
+		}
+		return nil
+	case SecretTypeNpm:
+		if gSecret.Npm == nil || (gSecret.Npm.Repository == "" && len(gSecret.Npm.PackageScopes) == 0) {
+			return fmt.Errorf("npm secret '%s' in generatedSecrets needs npm.repository or npm.packageScopes", gSecret.SecretName)
+		}
+		for packageScope, repository := range gSecret.Npm.PackageScopes {
+			if !strings.HasPrefix(packageScope, "@") || repository == "" {
+				return fmt.Errorf("npm secret '%s' in generatedSecrets maps package scope '%s' to repository '%s', package scopes start with @ and need a repository", gSecret.SecretName, packageScope, repository)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Invalid SecretType '%s' in generatedSecrets. Must be one of '%s'", gSecret.SecretType, strings.Join(SupportedSecretTypes, "', '"))
+}
+
+// validateDataKeys checks that dataKeys renames default keys of the secret type to distinct valid keys
+func validateDataKeys(gSecret v1alpha1.GeneratedSecret) error {
+	if len(gSecret.DataKeys) == 0 {
+		return nil
+	}
+	defaultKeys, ok := RenamableSecretKeys[gSecret.SecretType]
+	if !ok {
+		return fmt.Errorf("secret '%s' in generatedSecrets sets dataKeys, the data keys of secretType %s can't be renamed", gSecret.SecretName, gSecret.SecretType)
+	}
+
+	// the keys written, renamed or kept with their default name
+	written := map[string]string{}
+	for _, defaultKey := range defaultKeys {
+		key := defaultKey
+		if renamed, ok := gSecret.DataKeys[defaultKey]; ok {
+			if errs := validation.IsConfigMapKey(renamed); len(errs) > 0 {
+				return fmt.Errorf("secret '%s' in generatedSecrets renames data key '%s' to invalid key '%s': %s", gSecret.SecretName, defaultKey, renamed, strings.Join(errs, ", "))
+			}
+			key = renamed
+		}
+		if other, ok := written[key]; ok {
+			return fmt.Errorf("secret '%s' in generatedSecrets writes data keys '%s' and '%s' to the same key '%s'", gSecret.SecretName, other, defaultKey, key)
+		}
+		written[key] = defaultKey
+	}
+	for defaultKey := range gSecret.DataKeys {
+		if !slices.Contains(defaultKeys, defaultKey) {
+			return fmt.Errorf("secret '%s' in generatedSecrets renames unknown data key '%s', secretType %s writes '%s'", gSecret.SecretName, defaultKey, gSecret.SecretType, strings.Join(defaultKeys, "', '"))
+		}
+	}
+	return nil
+}
+
+// validateTokenLifetime checks that the configured token TTL outlives the refresh interval and the rotation lead
+func validateTokenLifetime(secretRotator *v1alpha1.SecretRotator) error {
+	lead, percentage, err := ParseRotateBefore(secretRotator.Spec.RotateBefore)
+	if err != nil {
+		return &ReconcileError{Message: fmt.Sprintf("Invalid rotateBefore '%s', must be a duration such as 10m or a percentage of the token TTL such as 25%%. The current reconciliation cycle will end here.", secretRotator.Spec.RotateBefore), Cause: err}
+	}
+	if secretRotator.Spec.TokenTTL == nil {
+		return nil
+	}
+	tokenTTL := secretRotator.Spec.TokenTTL.Duration
+	if tokenTTL < time.Minute {
+		return &ReconcileError{Message: fmt.Sprintf("tokenTTL (%s) must be at least 1m. The current reconciliation cycle will end here.", tokenTTL)}
+	}
+	// if the token is set to expire before reconciliation runs we will always get into token expire events
+	if secretRotator.Spec.RefreshInterval != nil && tokenTTL <= secretRotator.Spec.RefreshInterval.Duration {
+		return &ReconcileError{Message: fmt.Sprintf("tokenTTL (%s) must be longer than refreshTime (%s), otherwise tokens expire before they are rotated. The current reconciliation cycle will end here.", tokenTTL, secretRotator.Spec.RefreshInterval.Duration)}
+	}
+	if percentage == 0 && lead >= tokenTTL {
+		return &ReconcileError{Message: fmt.Sprintf("rotateBefore (%s) must be shorter than tokenTTL (%s). The current reconciliation cycle will end here.", lead, tokenTTL)}
+	}
+	return nil
+}
+
+// TokenTTLSeconds returns the token lifetime requested through spec.tokenTTL, or nil when the identity provider decides it
+func TokenTTLSeconds(secretRotator *v1alpha1.SecretRotator) *int32 {
+	if secretRotator.Spec.TokenTTL == nil {
+		return nil
+	}
+	seconds := int32(secretRotator.Spec.TokenTTL.Seconds())
+	return &seconds
+}
+
+// ParseRotateBefore parses spec.rotateBefore, which is either a duration or a percentage of the token TTL.
+// It returns the duration, or the percentage when the value ends with %, an empty value defaults to DefaultRotateBeforePercentage.
+func ParseRotateBefore(rotateBefore string) (time.Duration, float64, error) {
+	if rotateBefore == "" {
+		return 0, DefaultRotateBeforePercentage, nil
+	}
+	if strings.HasSuffix(rotateBefore, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSuffix(rotateBefore, "%"), 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		if percentage <= 0 || percentage >= 100 {
+			return 0, 0, fmt.Errorf("percentage %s must be between 0%% and 100%%", rotateBefore)
+		}
+		return 0, percentage, nil
+	}
+	lead, err := time.ParseDuration(rotateBefore)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lead <= 0 {
+		return 0, 0, fmt.Errorf("duration %s must be positive", rotateBefore)
+	}
+	return lead, 0, nil
+}
+
+// NextRotation returns the time until the tokens with the given TTL are rotated, rotateBefore ahead of their expiry.
+// A configured refreshTime reconciles earlier when it is shorter.
+func NextRotation(secretRotator *v1alpha1.SecretRotator, ttl time.Duration) time.Duration {
+	lead, percentage, err := ParseRotateBefore(secretRotator.Spec.RotateBefore)
+	if err != nil || (percentage == 0 && lead >= ttl) {
+		// the rotation lead does not fit the issued token, fall back to the default lead so the token is rotated before it expires
+		percentage = DefaultRotateBeforePercentage
+	}
+	if percentage > 0 {
+		lead = time.Duration(float64(ttl) * percentage / 100)
+	}
+	rotateIn := ttl - lead
+	if secretRotator.Spec.RefreshInterval != nil && secretRotator.Spec.RefreshInterval.Duration < rotateIn {
+		return secretRotator.Spec.RefreshInterval.Duration
+	}
+	return rotateIn
+}
+
 // GetServiceAccount is used to get the service account and pod details, it will return the service account object
 // and the pod object, and a boolean indicating if the service account is annotated with role ARN
 // If the service account is not annotated with role ARN, it will return an error
@@ -158,47 +383,77 @@ func GetServiceAccount(ctx context.Context, k8sClient client.Client, tokenDetail
 	return serviceAccount, nil
 }
 
-// IsExist checks the labels from namespaces and secret rotator objects
-func IsExist(namespaceLabels, objectLabels map[string]string) bool {
-	for val, _ := range objectLabels {
-		if namespaceLabels[val] != objectLabels[val] {
-			return false
-		}
+// NamespaceSelected checks if the namespace is selected by the namespaceSelector of the SecretRotator, matches its
+// namespaces, is not excluded and did not opt out of it
+func NamespaceSelected(secretRotator *v1alpha1.SecretRotator, namespace *v1.Namespace) (bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&secretRotator.Spec.NamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid namespaceSelector: %w", err)
 	}
-	return true
+	return selector.Matches(labels.Set(namespace.Labels)) && NamespaceIncluded(secretRotator, namespace), nil
 }
 
-// GetRandomString generates random string with size 10
-func GetRandomString() string {
-	const charset = "abcdefghijklmnopqrstuvwxyz"
-	var seededRand *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
-	b := make([]byte, 10)
-	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
+// NamespaceIncluded checks the namespace against the namespaces and excludeNamespaces of the SecretRotator and the opt-out annotation
+func NamespaceIncluded(secretRotator *v1alpha1.SecretRotator, namespace *v1.Namespace) bool {
+	if len(secretRotator.Spec.Namespaces) > 0 && !matchesNamespacePattern(secretRotator.Spec.Namespaces, namespace.Name) {
+		return false
+	}
+	if matchesNamespacePattern(secretRotator.Spec.ExcludeNamespaces, namespace.Name) {
+		return false
 	}
-	return string(b)
+	return !OptedOut(namespace, secretRotator.Name)
 }
 
-// ListSecretRotatorObjects return list of secret rotator objects
-func ListSecretRotatorObjects(cli client.Client) *v1alpha1.SecretRotatorList {
-	secretRotators := &v1alpha1.SecretRotatorList{}
-	err := cli.List(context.Background(), secretRotators, &client.ListOptions{})
-	if err != nil {
-		return &v1alpha1.SecretRotatorList{}
+// OptedOut checks if the opt-out annotation of the namespace refuses the SecretRotator
+func OptedOut(namespace *v1.Namespace, secretRotatorName string) bool {
+	value, ok := namespace.Annotations[OptOutAnnotation]
+	if !ok {
+		return false
+	}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "*" || name == secretRotatorName {
+			return true
+		}
 	}
-	return secretRotators
+	return false
 }
 
-// HandlingNamespaceEvents updates annotations for namespace events
-func HandlingNamespaceEvents(cli client.Client, log logr.Logger, object *v1alpha1.SecretRotator) bool {
-	if object.Annotations == nil {
-		object.Annotations = make(map[string]string)
+// matchesNamespacePattern checks if the namespace name equals one of the names or matches one of the glob patterns
+func matchesNamespacePattern(patterns []string, namespaceName string) bool {
+	for _, pattern := range patterns {
+		// patterns are validated by ValidateObjectSpec
+		if matched, _ := path.Match(pattern, namespaceName); matched {
+			return true
+		}
 	}
-	object.Annotations["uid"] = GetRandomString()
-	if err := cli.Update(context.Background(), object, &client.UpdateOptions{}); err != nil {
-		return false
+	return false
+}
+
+// validateNamespacePatterns checks the glob patterns of namespaces and excludeNamespaces
+func validateNamespacePatterns(secretRotator *v1alpha1.SecretRotator) error {
+	for _, pattern := range append(slices.Clone(secretRotator.Spec.Namespaces), secretRotator.Spec.ExcludeNamespaces...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return &ReconcileError{Message: fmt.Sprintf("Invalid namespace pattern '%s', the current reconciliation cycle will end here", pattern), Cause: err}
+		}
+	}
+	return nil
+}
+
+// SecretRotatorsForNamespace returns the SecretRotators whose namespaceSelector selects the namespace or which provisioned it
+func SecretRotatorsForNamespace(secretRotators *v1alpha1.SecretRotatorList, namespace *v1.Namespace) []types.NamespacedName {
+	matches := []types.NamespacedName{}
+	for i := range secretRotators.Items {
+		secretRotator := &secretRotators.Items[i]
+		selected, err := NamespaceSelected(secretRotator, namespace)
+		if err != nil {
+			// invalid selectors are reported by the reconciliation of the SecretRotator
+			continue
+		}
+		if selected || slices.Contains(secretRotator.Status.ProvisionedNamespaces, namespace.Name) {
+			matches = append(matches, types.NamespacedName{Namespace: secretRotator.Namespace, Name: secretRotator.Name})
+		}
 	}
-	return true
+	return matches
 }
 
 // FileExists checks if a file exists and is not a directory
@@ -249,21 +504,57 @@ func DeleteOutdatedGeneratedSecrets(ctx context.Context, tokenDetails *TokenDeta
 		for namespace, secretNames := range changesSecrets {
 			for _, secretName := range secretNames {
 				logger.Info("[Outdated secrets found] Deleting secret in namespace", "Name", secretName, "Namespace", namespace)
-				err := k8sClient.Delete(ctx, &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace}}, &client.DeleteOptions{})
+				unmerged, err := unmergeOutdatedSecret(ctx, k8sClient, secretRotator.Name, namespace, secretName)
 				if err != nil {
-					if errors.IsNotFound(err) {
-						logger.Info("Secret not found in namespace, skipping deletion.", "Name", secretName, "Namespace", namespace)
-						continue // Skip if the secret is already deleted
-					}
+					return err
+				}
+				if unmerged {
+					logger.Info("Removed the merged auths entries from outdated secret in namespace", "Name", secretName, "Namespace", namespace)
+					continue
+				}
+				err = k8sClient.Delete(ctx, &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace}}, &client.DeleteOptions{})
+				if err != nil && !errors.IsNotFound(err) {
 					return fmt.Errorf("error deleting secret %s in namespace %s: %w", secretName, namespace, err)
 				}
-				logger.Info("Successfully deleted outdated secret in namespace", "Name", secretName, "Namespace", namespace)
+				if errors.IsNotFound(err) {
+					logger.Info("Secret not found in namespace, skipping deletion.", "Name", secretName, "Namespace", namespace)
+				} else {
+					logger.Info("Successfully deleted outdated secret in namespace", "Name", secretName, "Namespace", namespace)
+				}
+				// Remove the references to the deleted secret the operator added to ServiceAccounts
+				if err := DetachImagePullSecret(ctx, k8sClient, namespace, secretName); err != nil {
+					return err
+				}
 			}
 		}
 	}
 	return nil
 }
 
+// unmergeOutdatedSecret removes the auths entries the SecretRotator merged into a shared docker config secret.
+// It reports whether the secret is kept, because entries of other writers remain or the SecretRotator does not control it.
+func unmergeOutdatedSecret(ctx context.Context, k8sClient client.Client, secretRotatorName, namespace, secretName string) (bool, error) {
+	secret := &v1.Secret{}
+	if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: secretName}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error getting secret %s in namespace %s: %w", secretName, namespace, err)
+	}
+	merged, remainingAuths, err := UnmergeDockerConfig(secret, secretRotatorName)
+	if err != nil || !merged {
+		return false, err
+	}
+	owner := metav1.GetControllerOf(secret)
+	if remainingAuths == 0 && owner != nil && owner.Kind == v1alpha1.SecretKind && owner.Name == secretRotatorName {
+		return false, nil
+	}
+	if err := k8sClient.Update(ctx, secret); err != nil {
+		return false, fmt.Errorf("error unmerging secret %s in namespace %s: %w", secretName, namespace, err)
+	}
+	return true, nil
+}
+
 // findSecretDifferences compares the new state with the old state and returns the differences
 // and a boolean indicating if there are any differences
 func findSecretDifferences(newState map[string][]string, oldState map[string][]string) (map[string][]string, bool) {
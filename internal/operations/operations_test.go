@@ -5,6 +5,7 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,7 +15,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
-	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 type Clientset struct {
@@ -79,78 +79,396 @@ func TestGetServiceAccount_Success(t *testing.T) {
 	assert.Equal(t, podNamespace, tokenDetails.DefaultServiceAccountNamespace)
 }
 
-func TestIsExist_Success(t *testing.T) {
-	namespaceLabels := map[string]string{"environment": "dev", "region": "us-east-1"}
-	objectLabels := map[string]string{"environment": "dev"}
-	assert.True(t, IsExist(namespaceLabels, objectLabels))
+func TestNamespaceSelected_Success(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"environment": "dev", "region": "us-east-1"}}}
+	tests := []struct {
+		name     string
+		selector metav1.LabelSelector
+		selected bool
+	}{
+		{name: "empty selector", selector: metav1.LabelSelector{}, selected: true},
+		{name: "matchLabels subset", selector: metav1.LabelSelector{MatchLabels: map[string]string{"environment": "dev"}}, selected: true},
+		{name: "matchLabels all", selector: metav1.LabelSelector{MatchLabels: map[string]string{"environment": "dev", "region": "us-east-1"}}, selected: true},
+		{name: "matchLabels mismatch", selector: metav1.LabelSelector{MatchLabels: map[string]string{"environment": "prod"}}, selected: false},
+		{name: "In", selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "environment", Operator: metav1.LabelSelectorOpIn, Values: []string{"dev", "staging"}},
+		}}, selected: true},
+		{name: "In mismatch", selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "environment", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod"}},
+		}}, selected: false},
+		{name: "NotIn", selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "environment", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"prod"}},
+		}}, selected: true},
+		{name: "NotIn mismatch", selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "environment", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+		}}, selected: false},
+		{name: "Exists", selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "region", Operator: metav1.LabelSelectorOpExists},
+		}}, selected: true},
+		{name: "DoesNotExist", selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "region", Operator: metav1.LabelSelectorOpDoesNotExist},
+		}}, selected: false},
+		{name: "matchLabels and expressions", selector: metav1.LabelSelector{
+			MatchLabels:      map[string]string{"environment": "dev"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "region", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"us-east-1"}}},
+		}, selected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secretRotator := &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{NamespaceSelector: test.selector}}
+			selected, err := NamespaceSelected(secretRotator, namespace)
+			require.NoError(t, err)
+			assert.Equal(t, test.selected, selected)
+		})
+	}
+
+	secretRotator := &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{NamespaceSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "environment", Operator: metav1.LabelSelectorOpIn},
+	}}}}
+	_, err := NamespaceSelected(secretRotator, namespace)
+	assert.Error(t, err)
+}
+
+func TestSecretRotatorsForNamespace_MatchExpressions(t *testing.T) {
+	secretRotators := &jfrogv1alpha1.SecretRotatorList{Items: []jfrogv1alpha1.SecretRotator{{
+		ObjectMeta: metav1.ObjectMeta{Name: "non-prod-rotator", Namespace: "default"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{NamespaceSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "environment", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"prod"}},
+			{Key: "team", Operator: metav1.LabelSelectorOpExists},
+		}}},
+	}}}
+	rotator := []types.NamespacedName{{Namespace: "default", Name: "non-prod-rotator"}}
+
+	// a created namespace matching the expressions
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"environment": "dev", "team": "a"}}}
+	assert.Equal(t, rotator, SecretRotatorsForNamespace(secretRotators, namespace))
 
-	objectLabels = map[string]string{"environment": "dev", "region": "us-east-1"}
-	assert.True(t, IsExist(namespaceLabels, objectLabels))
+	// the namespace moves out of scope, the old labels still map to the SecretRotator
+	secretRotators.Items[0].Status.ProvisionedNamespaces = []string{"team-a"}
+	movedOut := namespace.DeepCopy()
+	movedOut.Labels["environment"] = "prod"
+	assert.Equal(t, rotator, SecretRotatorsForNamespace(secretRotators, namespace))
+	// the new labels map to it as long as the namespace is provisioned, so its secrets get deleted
+	assert.Equal(t, rotator, SecretRotatorsForNamespace(secretRotators, movedOut))
+	secretRotators.Items[0].Status.ProvisionedNamespaces = nil
+	assert.Empty(t, SecretRotatorsForNamespace(secretRotators, movedOut))
 
-	objectLabels = map[string]string{}
-	assert.True(t, IsExist(namespaceLabels, objectLabels))
+	// losing the label required by Exists moves the namespace out of scope
+	unlabeled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"environment": "dev"}}}
+	assert.Empty(t, SecretRotatorsForNamespace(secretRotators, unlabeled))
 }
 
-func TestGetRandomString_Success(t *testing.T) {
-	randomString := GetRandomString()
-	assert.Len(t, randomString, 10)
-	// Basic check that it contains only lowercase letters (not strictly enforced by the function)
-	for _, r := range randomString {
-		assert.GreaterOrEqual(t, r, 'a')
-		assert.LessOrEqual(t, r, 'z')
+func TestNamespaceSelected_IncludeExclude(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "platform-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			Namespaces:        []string{"team-*", "shared"},
+			ExcludeNamespaces: []string{"team-legacy", "kube-*"},
+		},
+	}
+	tests := []struct {
+		name      string
+		namespace *corev1.Namespace
+		selected  bool
+	}{
+		{name: "glob pattern", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}, selected: true},
+		{name: "explicit name", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}, selected: true},
+		{name: "not listed", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, selected: false},
+		{name: "excluded", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-legacy"}}, selected: false},
+		{name: "opted out of all", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b",
+			Annotations: map[string]string{OptOutAnnotation: "*"}}}, selected: false},
+		{name: "opted out by name", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-c",
+			Annotations: map[string]string{OptOutAnnotation: "other-rotator, platform-rotator"}}}, selected: false},
+		{name: "opted out of another", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-d",
+			Annotations: map[string]string{OptOutAnnotation: "other-rotator"}}}, selected: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := NamespaceSelected(secretRotator, test.namespace)
+			require.NoError(t, err)
+			assert.Equal(t, test.selected, selected)
+		})
 	}
+
+	// without namespaces every selected namespace which is not excluded is included
+	secretRotator.Spec.Namespaces = nil
+	selected, err := NamespaceSelected(secretRotator, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	require.NoError(t, err)
+	assert.True(t, selected)
+	selected, err = NamespaceSelected(secretRotator, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}})
+	require.NoError(t, err)
+	assert.False(t, selected)
 }
 
-func TestListSecretRotatorObjects_Success(t *testing.T) {
+func TestValidateObjectSpec_FiltersNamespaces(t *testing.T) {
 	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
-		&jfrogv1alpha1.SecretRotator{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "test-rotator-1",
-				Namespace: "default",
-			},
-			Spec: jfrogv1alpha1.SecretRotatorSpec{},
-		},
-		&jfrogv1alpha1.SecretRotator{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "test-rotator-2",
-				Namespace: "kube-system",
-			},
-			Spec: jfrogv1alpha1.SecretRotatorSpec{},
-		},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Annotations: map[string]string{OptOutAnnotation: "*"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
 	).Build()
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "platform-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			GeneratedSecrets:  []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull", SecretType: SecretTypeDocker}},
+			ExcludeNamespaces: []string{"kube-system"},
+		},
+	}
 
-	rotators := ListSecretRotatorObjects(fakeClient)
-	assert.Len(t, rotators.Items, 2)
-	assert.Equal(t, "test-rotator-1", rotators.Items[0].Name)
-	assert.Equal(t, "default", rotators.Items[0].Namespace)
-	assert.Equal(t, "test-rotator-2", rotators.Items[1].Name)
-	assert.Equal(t, "kube-system", rotators.Items[1].Namespace)
+	// the namespaces are listed before the missing artifactoryUrl is reported
+	tokenDetails := &TokenDetails{}
+	err := ValidateObjectSpec(context.Background(), tokenDetails, secretRotator, fakeClient)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Missing ArtifactoryUrl")
+	require.Len(t, tokenDetails.NamespaceList.Items, 1)
+	assert.Equal(t, "team-a", tokenDetails.NamespaceList.Items[0].Name)
+
+	secretRotator.Spec.ExcludeNamespaces = []string{"team-["}
+	err = ValidateObjectSpec(context.Background(), &TokenDetails{}, secretRotator, fakeClient)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid namespace pattern 'team-['")
 }
 
-func TestHandlingNamespaceEvents_Success(t *testing.T) {
-	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
-		&jfrogv1alpha1.SecretRotator{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "test-rotator",
-				Namespace: "default",
-			},
-			Spec: jfrogv1alpha1.SecretRotatorSpec{},
+func TestValidateObjectSpec_CredentialPolicyOnly(t *testing.T) {
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			CredentialPolicy: jfrogv1alpha1.CredentialPolicyDetails{Enabled: true, AllowedSecretTypes: []string{SecretTypeDocker}},
 		},
-	).Build()
-	logger := log.FromContext(context.Background())
+	}
+
+	// a SecretRotator only serving ArtifactoryCredentials needs neither generated secrets nor selected namespaces,
+	// the validation continues to the missing artifactoryUrl
+	tokenDetails := &TokenDetails{}
+	err := ValidateObjectSpec(context.Background(), tokenDetails, secretRotator, fakeClient)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Missing ArtifactoryUrl")
+	assert.Empty(t, tokenDetails.GeneratedSecrets)
+	assert.Empty(t, tokenDetails.NamespaceList.Items)
+
+	secretRotator.Spec.CredentialPolicy.Enabled = false
+	err = ValidateObjectSpec(context.Background(), &TokenDetails{}, secretRotator, fakeClient)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "No secrets defined")
+}
+
+func TestValidateObjectSpec_RejectsScopesForOidc(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{
+		AuthType:         KubernetesOidcAuthType,
+		GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "deploy", SecretType: SecretTypeGeneric, Scope: "applied-permissions/groups:deployers"}},
+	}}
+	err := ValidateObjectSpec(context.Background(), &TokenDetails{}, secretRotator, fake.NewClientBuilder().WithScheme(scheme).Build())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Scope 'applied-permissions/groups:deployers' of secret 'deploy' is not supported with the OIDC auth types")
+}
+
+func TestSupportsScopedTokens(t *testing.T) {
+	assert.True(t, SupportsScopedTokens(&jfrogv1alpha1.SecretRotator{}))
+	assert.True(t, SupportsScopedTokens(&jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{AuthType: WebIdentityAuthType}}))
+	assert.False(t, SupportsScopedTokens(&jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{AuthType: GcpWorkloadIdentityAuthType}}))
+	// auto may fall back to an OIDC auth type
+	assert.False(t, SupportsScopedTokens(&jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{
+		AuthType: AutoAuthType, AuthPriority: []string{PodIdentityAuthType, KubernetesOidcAuthType},
+	}}))
+}
+
+func TestRequestedScopes_Success(t *testing.T) {
+	tokenDetails := &TokenDetails{GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{
+		{SecretName: "pull", SecretType: SecretTypeDocker, Scope: "applied-permissions/groups:readers"},
+		{SecretName: "deploy", SecretType: SecretTypeGeneric},
+		{SecretName: "pull-2", SecretType: SecretTypeDocker, Scope: "applied-permissions/groups:readers"},
+	}}
+	assert.Equal(t, []string{"applied-permissions/groups:readers", ""}, tokenDetails.RequestedScopes())
+
+	assert.Nil(t, tokenDetails.TokenForScope(""))
+	tokenDetails.Tokens = map[string]*AccessResponse{"": {AccessToken: "default"}}
+	assert.Equal(t, "default", tokenDetails.TokenForScope("").AccessToken)
+	assert.Nil(t, tokenDetails.TokenForScope("applied-permissions/groups:readers"))
+}
+
+func TestAuthPriority_Success(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{}
+	assert.Equal(t, DefaultAuthPriority, AuthPriority(secretRotator))
+	assert.True(t, RequiresAwsRole(secretRotator))
+
+	secretRotator.Spec.AuthPriority = []string{KubernetesOidcAuthType, WebIdentityAuthType}
+	assert.Equal(t, []string{KubernetesOidcAuthType, WebIdentityAuthType}, AuthPriority(secretRotator))
+	assert.False(t, RequiresAwsRole(secretRotator))
+
+	secretRotator.Spec.AuthType = PodIdentityAuthType
+	assert.True(t, RequiresAwsRole(secretRotator))
+	secretRotator.Spec.AuthType = GcpWorkloadIdentityAuthType
+	assert.False(t, RequiresAwsRole(secretRotator))
+}
+
+func TestArtifactoryHost_Success(t *testing.T) {
+	assert.Equal(t, "artifactory.example.com", ArtifactoryHost("https://artifactory.example.com"))
+	assert.Equal(t, "artifactory.example.com", ArtifactoryHost("http://artifactory.example.com"))
+	assert.Equal(t, "artifactory.example.com", ArtifactoryHost("artifactory.example.com"))
+}
 
-	obj := &jfrogv1alpha1.SecretRotator{}
-	err := fakeClient.Get(context.Background(), types.NamespacedName{Name: "test-rotator", Namespace: "default"}, obj)
+func TestParseRotateBefore_Success(t *testing.T) {
+	lead, percentage, err := ParseRotateBefore("")
 	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), lead)
+	assert.Equal(t, float64(DefaultRotateBeforePercentage), percentage)
 
-	updated := HandlingNamespaceEvents(fakeClient, logger, obj)
-	assert.True(t, updated)
+	lead, percentage, err = ParseRotateBefore("10m")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, lead)
+	assert.Equal(t, float64(0), percentage)
 
-	updatedObj := &jfrogv1alpha1.SecretRotator{}
-	err = fakeClient.Get(context.Background(), types.NamespacedName{Name: "test-rotator", Namespace: "default"}, updatedObj)
+	lead, percentage, err = ParseRotateBefore("12.5%")
 	require.NoError(t, err)
-	assert.NotEmpty(t, updatedObj.Annotations["uid"])
-	assert.Len(t, updatedObj.Annotations["uid"], 10)
+	assert.Equal(t, time.Duration(0), lead)
+	assert.Equal(t, 12.5, percentage)
+
+	for _, invalid := range []string{"100%", "0%", "0s", "soon"} {
+		_, _, err = ParseRotateBefore(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNextRotation_Success(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{}
+	assert.Equal(t, 45*time.Minute, NextRotation(secretRotator, time.Hour))
+
+	secretRotator.Spec.RotateBefore = "10m"
+	assert.Equal(t, 50*time.Minute, NextRotation(secretRotator, time.Hour))
+
+	// a rotation lead longer than the issued token falls back to the default lead
+	assert.Equal(t, 6*time.Minute, NextRotation(secretRotator, 8*time.Minute))
+
+	secretRotator.Spec.RotateBefore = "50%"
+	assert.Equal(t, 30*time.Minute, NextRotation(secretRotator, time.Hour))
+
+	secretRotator.Spec.RefreshInterval = &metav1.Duration{Duration: 20 * time.Minute}
+	assert.Equal(t, 20*time.Minute, NextRotation(secretRotator, time.Hour))
+	assert.Equal(t, 5*time.Minute, NextRotation(secretRotator, 10*time.Minute))
+}
+
+func TestValidateTokenLifetime(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{}
+	assert.NoError(t, validateTokenLifetime(secretRotator))
+
+	secretRotator.Spec.TokenTTL = &metav1.Duration{Duration: time.Hour}
+	secretRotator.Spec.RefreshInterval = &metav1.Duration{Duration: 30 * time.Minute}
+	secretRotator.Spec.RotateBefore = "10m"
+	assert.NoError(t, validateTokenLifetime(secretRotator))
+
+	secretRotator.Spec.RefreshInterval = &metav1.Duration{Duration: time.Hour}
+	assert.Error(t, validateTokenLifetime(secretRotator))
+
+	secretRotator.Spec.RefreshInterval = nil
+	secretRotator.Spec.RotateBefore = "2h"
+	assert.Error(t, validateTokenLifetime(secretRotator))
+
+	secretRotator.Spec.RotateBefore = "later"
+	assert.Error(t, validateTokenLifetime(secretRotator))
+
+	secretRotator.Spec.RotateBefore = ""
+	secretRotator.Spec.TokenTTL = &metav1.Duration{Duration: 30 * time.Second}
+	assert.Error(t, validateTokenLifetime(secretRotator))
+}
+
+func TestValidateGeneratedSecret(t *testing.T) {
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker}))
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "npmrc", SecretType: SecretTypeNpm,
+		Npm: &jfrogv1alpha1.NpmSecretDetails{PackageScopes: map[string]string{"@acme": "npm-internal"}}}))
+
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "npmrc", SecretType: SecretTypeNpm}))
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "npmrc", SecretType: SecretTypeNpm,
+		Npm: &jfrogv1alpha1.NpmSecretDetails{PackageScopes: map[string]string{"acme": "npm-internal"}}}))
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "creds", SecretType: "ssh"}))
+
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "gradle", SecretType: SecretTypeGradle}))
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "settings", SecretType: SecretTypeMaven,
+		Maven: &jfrogv1alpha1.MavenSecretDetails{MirrorRepository: "maven-virtual"}}))
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "settings", SecretType: SecretTypeMaven,
+		Maven: &jfrogv1alpha1.MavenSecretDetails{}}))
+
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "netrc", SecretType: SecretTypeNetrc}))
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "pip", SecretType: SecretTypePip,
+		Pip: &jfrogv1alpha1.PipSecretDetails{Repository: "pypi-remote"}}))
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "pip", SecretType: SecretTypePip}))
+}
+
+func TestValidateGeneratedSecret_Argocd(t *testing.T) {
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "repo-creds", SecretType: SecretTypeArgocd}))
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "charts", SecretType: SecretTypeArgocd,
+		Argocd: &jfrogv1alpha1.ArgocdSecretDetails{CredentialType: ArgocdRepository, RepositoryType: ArgocdRepositoryTypeOci, Repository: "charts-oci"}}))
+
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "charts", SecretType: SecretTypeArgocd,
+		Argocd: &jfrogv1alpha1.ArgocdSecretDetails{CredentialType: ArgocdRepository}}))
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "charts", SecretType: SecretTypeArgocd,
+		Argocd: &jfrogv1alpha1.ArgocdSecretDetails{RepositoryType: "git"}}))
+}
+
+func TestValidateGeneratedSecret_Flux(t *testing.T) {
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "flux", SecretType: SecretTypeFlux}))
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "flux", SecretType: SecretTypeFlux,
+		Flux: &jfrogv1alpha1.FluxSecretDetails{IncludeCA: true, ReconcileSources: true}}))
+
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "flux", SecretType: SecretTypeFlux,
+		Flux: &jfrogv1alpha1.FluxSecretDetails{RepositoryType: FluxRepositoryTypeOci, IncludeCA: true}}))
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "flux", SecretType: SecretTypeFlux,
+		Flux: &jfrogv1alpha1.FluxSecretDetails{RepositoryType: "git"}}))
+}
+
+func TestValidateGeneratedSecret_DockerServiceAccounts(t *testing.T) {
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker,
+		Docker: &jfrogv1alpha1.DockerSecretDetails{ServiceAccounts: &jfrogv1alpha1.ServiceAccountSelector{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"pulls": "artifactory"}}}}}))
+
+	assert.Error(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: SecretTypeDocker,
+		Docker: &jfrogv1alpha1.DockerSecretDetails{ServiceAccounts: &jfrogv1alpha1.ServiceAccountSelector{Selector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "pulls", Operator: "Like"}}}}}}))
+}
+
+func TestValidateGeneratedSecret_DataKeys(t *testing.T) {
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "creds", SecretType: SecretTypeGeneric,
+		DataKeys: map[string]string{GenericSecretUser: "username", GenericSecretToken: "password"}}))
+	// swapping the keys writes distinct keys
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "creds", SecretType: SecretTypeGeneric,
+		DataKeys: map[string]string{GenericSecretUser: GenericSecretToken, GenericSecretToken: GenericSecretUser}}))
+	assert.NoError(t, ValidateGeneratedSecret(jfrogv1alpha1.GeneratedSecret{SecretName: "basic", SecretType: SecretTypeBasicAuth}))
+
+	for name, gSecret := range map[string]jfrogv1alpha1.GeneratedSecret{
+		"fixed keys":   {SecretName: "basic", SecretType: SecretTypeBasicAuth, DataKeys: map[string]string{"username": "user"}},
+		"unknown key":  {SecretName: "creds", SecretType: SecretTypeGeneric, DataKeys: map[string]string{"password": "secret"}},
+		"invalid key":  {SecretName: "creds", SecretType: SecretTypeGeneric, DataKeys: map[string]string{GenericSecretToken: "api/key"}},
+		"same key":     {SecretName: "creds", SecretType: SecretTypeGeneric, DataKeys: map[string]string{GenericSecretToken: GenericSecretUser}},
+		"template key": {SecretName: "config", SecretType: SecretTypeTemplate, Template: map[string]string{"config": "{{ .Token }}"}, DataKeys: map[string]string{"config": "conf"}},
+	} {
+		assert.Error(t, ValidateGeneratedSecret(gSecret), name)
+	}
+}
+
+func TestSecretRotatorsForNamespace_Success(t *testing.T) {
+	secretRotators := &jfrogv1alpha1.SecretRotatorList{Items: []jfrogv1alpha1.SecretRotator{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-rotator", Namespace: "default"},
+			Spec:       jfrogv1alpha1.SecretRotatorSpec{NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-rotator", Namespace: "default"},
+			Spec:       jfrogv1alpha1.SecretRotatorSpec{NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}}},
+		},
+		{
+			// the namespace was provisioned before the selector changed
+			ObjectMeta: metav1.ObjectMeta{Name: "previous-rotator", Namespace: "default"},
+			Spec:       jfrogv1alpha1.SecretRotatorSpec{NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "c"}}},
+			Status:     jfrogv1alpha1.SecretRotatorStatus{ProvisionedNamespaces: []string{"team-a"}},
+		},
+	}}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}}
+
+	assert.Equal(t, []types.NamespacedName{
+		{Namespace: "default", Name: "team-rotator"},
+		{Namespace: "default", Name: "previous-rotator"},
+	}, SecretRotatorsForNamespace(secretRotators, namespace))
+
+	namespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-d", Labels: map[string]string{"team": "d"}}}
+	assert.Empty(t, SecretRotatorsForNamespace(secretRotators, namespace))
 }
 
 func TestFileExists_Success(t *testing.T) {
@@ -0,0 +1,253 @@
+package operations
+
+import (
+	"artifactory-secrets-rotator/api/v1alpha1"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// SecretTemplateData is the data the templates of a template secret are executed with
+type SecretTemplateData struct {
+	Username       string
+	Token          string
+	ArtifactoryURL string
+	Subdomains     []string
+	ExpiresAt      time.Time
+	Namespace      string
+}
+
+// MaxSecretTemplateSize is the largest value a secret template renders, and the largest string its functions return
+const MaxSecretTemplateSize = 64 * 1024
+
+// secretTemplateTimeout is the time a secret template may take to execute, a variable so tests can shorten it
+var secretTemplateTimeout = time.Second
+
+// secretTemplateFuncs are the functions available to secret templates, they only transform strings
+// and have no access to the environment, files or the network. The builtins building strings are replaced
+// so that nesting them cannot grow a value past MaxSecretTemplateSize.
+var secretTemplateFuncs = template.FuncMap{
+	"b64enc": func(value string) (string, error) {
+		return limitTemplateValue(base64.StdEncoding.EncodeToString([]byte(value)))
+	},
+	"toJson": func(value interface{}) (string, error) {
+		jsonBytes, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return limitTemplateValue(string(jsonBytes))
+	},
+	"quote":      func(value string) (string, error) { return limitTemplateValue(strconv.Quote(value)) },
+	"squote":     func(value string) (string, error) { return limitTemplateValue("'" + value + "'") },
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, value string) string { return strings.TrimPrefix(value, prefix) },
+	"trimSuffix": func(suffix, value string) string { return strings.TrimSuffix(value, suffix) },
+	"replace": func(old, new, value string) (string, error) {
+		// check the size before replacing, an empty old string inserts new around every character
+		if len(new) > len(old) && len(value)+(strings.Count(value, old)*(len(new)-len(old))) > MaxSecretTemplateSize {
+			return "", errSecretTemplateTooLarge
+		}
+		return strings.ReplaceAll(value, old, new), nil
+	},
+	"join": func(separator string, values []string) (string, error) {
+		return limitTemplateValue(strings.Join(values, separator))
+	},
+	"host": func(url string) string { return strings.SplitN(ArtifactoryHost(url), "/", 2)[0] },
+	"default": func(fallback, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+	"print": func(args ...interface{}) (string, error) { return limitTemplateValue(fmt.Sprint(args...)) },
+	"printf": func(format string, args ...interface{}) (string, error) {
+		return limitTemplateValue(fmt.Sprintf(format, args...))
+	},
+	"println": func(args ...interface{}) (string, error) { return limitTemplateValue(fmt.Sprintln(args...)) },
+	"html":    func(args ...interface{}) (string, error) { return limitTemplateValue(template.HTMLEscaper(args...)) },
+	"js":      func(args ...interface{}) (string, error) { return limitTemplateValue(template.JSEscaper(args...)) },
+	"urlquery": func(args ...interface{}) (string, error) {
+		return limitTemplateValue(template.URLQueryEscaper(args...))
+	},
+}
+
+var errSecretTemplateTooLarge = fmt.Errorf("secret template value exceeds %d bytes", MaxSecretTemplateSize)
+
+// limitTemplateValue fails a template function whose result exceeds MaxSecretTemplateSize
+func limitTemplateValue(value string) (string, error) {
+	if len(value) > MaxSecretTemplateSize {
+		return "", errSecretTemplateTooLarge
+	}
+	return value, nil
+}
+
+// secretTemplateWriter collects the rendered template up to MaxSecretTemplateSize, and stops the execution once the deadline passed
+type secretTemplateWriter struct {
+	ctx    context.Context
+	buffer bytes.Buffer
+}
+
+func (w *secretTemplateWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if w.buffer.Len()+len(p) > MaxSecretTemplateSize {
+		return 0, errSecretTemplateTooLarge
+	}
+	return w.buffer.Write(p)
+}
+
+// sampleSecretTemplateData is used to check that the templates execute when they are validated
+var sampleSecretTemplateData = SecretTemplateData{
+	Username:       "operator",
+	Token:          "token",
+	ArtifactoryURL: "https://example.jfrog.io",
+	Subdomains:     []string{"docker.example.jfrog.io"},
+	ExpiresAt:      time.Unix(0, 0).UTC(),
+	Namespace:      "default",
+}
+
+// parseSecretTemplate parses the template of a data key with the secret template functions
+func parseSecretTemplate(key, text string) (*template.Template, error) {
+	tmpl, err := template.New(key).Option("missingkey=error").Funcs(secretTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, definition := range tmpl.Templates() {
+		if definition.Tree != nil {
+			if err := checkSecretTemplateNode(definition.Tree.Root, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tmpl, nil
+}
+
+// checkSecretTemplateNode bounds the work a template can do: range only iterates once over .Subdomains, and templates cannot call templates.
+// text/template cannot be interrupted, a loop without output would otherwise run past the execution deadline.
+func checkSecretTemplateNode(node parse.Node, inRange bool) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := checkSecretTemplateNode(child, inRange); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkSecretTemplateBranch(&node.BranchNode, inRange)
+	case *parse.WithNode:
+		return checkSecretTemplateBranch(&node.BranchNode, inRange)
+	case *parse.RangeNode:
+		if inRange {
+			return fmt.Errorf("nested range is not supported")
+		}
+		if !rangesOverSubdomains(node.Pipe) {
+			return fmt.Errorf("range is only supported over .Subdomains")
+		}
+		return checkSecretTemplateBranch(&node.BranchNode, true)
+	case *parse.TemplateNode:
+		return fmt.Errorf("template %q: calling templates is not supported", node.Name)
+	}
+	return nil
+}
+
+func checkSecretTemplateBranch(node *parse.BranchNode, inRange bool) error {
+	if err := checkSecretTemplateNode(node.List, inRange); err != nil {
+		return err
+	}
+	return checkSecretTemplateNode(node.ElseList, inRange)
+}
+
+// rangesOverSubdomains checks the pipeline of a range is .Subdomains or $.Subdomains
+func rangesOverSubdomains(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return slices.Equal(arg.Ident, []string{"Subdomains"})
+	case *parse.VariableNode:
+		return slices.Equal(arg.Ident, []string{"$", "Subdomains"})
+	}
+	return false
+}
+
+// executeSecretTemplate renders a template up to MaxSecretTemplateSize within secretTemplateTimeout. text/template cannot be
+// interrupted, so the template runs in the calling goroutine: the writer fails the first write past the deadline, and
+// checkSecretTemplateNode and the limited functions bound the work done between writes.
+func executeSecretTemplate(tmpl *template.Template, data SecretTemplateData) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretTemplateTimeout)
+	defer cancel()
+
+	writer := &secretTemplateWriter{ctx: ctx}
+	if err := tmpl.Execute(writer, data); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("secret template did not complete within %s", secretTemplateTimeout)
+		}
+		return nil, err
+	}
+	return writer.buffer.Bytes(), nil
+}
+
+// validateSecretTemplate checks the data keys of a template secret and that their templates parse and execute
+func validateSecretTemplate(gSecret v1alpha1.GeneratedSecret) error {
+	if len(gSecret.Template) == 0 {
+		return fmt.Errorf("template secret '%s' in generatedSecrets needs at least one data key in template", gSecret.SecretName)
+	}
+	for _, key := range sortedTemplateKeys(gSecret.Template) {
+		if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+			return fmt.Errorf("template secret '%s' in generatedSecrets has invalid data key '%s': %s", gSecret.SecretName, key, strings.Join(errs, ", "))
+		}
+		tmpl, err := parseSecretTemplate(key, gSecret.Template[key])
+		if err != nil {
+			return fmt.Errorf("template secret '%s' in generatedSecrets has an invalid template for data key '%s': %w", gSecret.SecretName, key, err)
+		}
+		if _, err := executeSecretTemplate(tmpl, sampleSecretTemplateData); err != nil {
+			return fmt.Errorf("template secret '%s' in generatedSecrets fails to execute the template of data key '%s': %w", gSecret.SecretName, key, err)
+		}
+	}
+	return nil
+}
+
+// RenderSecretTemplate executes the template of every data key with the issued token data
+func RenderSecretTemplate(templates map[string]string, data SecretTemplateData) (map[string][]byte, error) {
+	secretData := make(map[string][]byte, len(templates))
+	for _, key := range sortedTemplateKeys(templates) {
+		tmpl, err := parseSecretTemplate(key, templates[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the template of data key %s %w", key, err)
+		}
+		rendered, err := executeSecretTemplate(tmpl, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute the template of data key %s %w", key, err)
+		}
+		secretData[key] = rendered
+	}
+	return secretData, nil
+}
+
+func sortedTemplateKeys(templates map[string]string) []string {
+	keys := make([]string, 0, len(templates))
+	for key := range templates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,95 @@
+package operations
+
+import (
+	"artifactory-secrets-rotator/api/v1alpha1"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSecretTemplate(t *testing.T) {
+	valid := v1alpha1.GeneratedSecret{SecretName: "config", SecretType: SecretTypeTemplate, Template: map[string]string{
+		"config.json": `{"url": {{ .ArtifactoryURL | toJson }}, "auth": "{{ printf "%s:%s" .Username .Token | b64enc }}"}`,
+		"hosts":       `{{ range .Subdomains }}{{ host . }} {{ end }}`,
+	}}
+	assert.NoError(t, ValidateGeneratedSecret(valid))
+
+	for name, templates := range map[string]map[string]string{
+		"no data keys":   {},
+		"invalid key":    {"config/json": "{{ .Token }}"},
+		"parse error":    {"config": "{{ .Token "},
+		"unknown field":  {"config": "{{ .Password }}"},
+		"unknown func":   {"config": `{{ env "HOME" }}`},
+		"wrong argument": {"config": `{{ join .Subdomains "," }}`},
+		"range over int": {"config": `{{ range 1000000000000 }}{{ end }}`},
+		"range over var": {"config": `{{ $n := .ExpiresAt.Unix }}{{ range $n }}{{ end }}`},
+		"nested range":   {"config": `{{ range .Subdomains }}{{ range $.Subdomains }}{{ end }}{{ end }}`},
+		"template call":  {"config": `{{ define "loop" }}{{ template "loop" }}{{ end }}{{ template "loop" }}`},
+		"large printf":   {"config": `{{ printf "%0999999d" 0 }}`},
+		"large replace":  {"config": `{{ replace "" "0123456789abcdef" (replace "" "0123456789abcdef" (printf "%01000d" 0)) }}`},
+	} {
+		gSecret := v1alpha1.GeneratedSecret{SecretName: "config", SecretType: SecretTypeTemplate, Template: templates}
+		assert.Error(t, ValidateGeneratedSecret(gSecret), name)
+	}
+}
+
+func TestRenderSecretTemplate_Success(t *testing.T) {
+	data := SecretTemplateData{
+		Username:       "operator",
+		Token:          "rotated-token",
+		ArtifactoryURL: "https://acme.jfrog.io",
+		Subdomains:     []string{"https://docker.acme.jfrog.io", "go.acme.jfrog.io"},
+		ExpiresAt:      time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Namespace:      "team-a",
+	}
+
+	secretData, err := RenderSecretTemplate(map[string]string{
+		"credentials": `{{ .Username }}:{{ .Token | b64enc }}`,
+		"hosts":       `{{ range .Subdomains }}{{ host . }},{{ end }}{{ host .ArtifactoryURL }}`,
+		"expiry":      `{{ .ExpiresAt.Format "2006-01-02" }} {{ .Namespace | upper }}`,
+	}, data)
+	require.NoError(t, err)
+	assert.Equal(t, "operator:cm90YXRlZC10b2tlbg==", string(secretData["credentials"]))
+	assert.Equal(t, "docker.acme.jfrog.io,go.acme.jfrog.io,acme.jfrog.io", string(secretData["hosts"]))
+	assert.Equal(t, "2025-01-02 TEAM-A", string(secretData["expiry"]))
+}
+
+func TestRenderSecretTemplate_TooLarge(t *testing.T) {
+	data := SecretTemplateData{Token: strings.Repeat("t", MaxSecretTemplateSize/2+1), Subdomains: []string{"docker.acme.jfrog.io"}}
+
+	_, err := RenderSecretTemplate(map[string]string{"token": `{{ .Token }}{{ .Token }}`}, data)
+	assert.ErrorIs(t, err, errSecretTemplateTooLarge)
+	_, err = RenderSecretTemplate(map[string]string{"token": `{{ printf "%s%s" .Token .Token | upper }}`}, data)
+	assert.ErrorIs(t, err, errSecretTemplateTooLarge)
+
+	secretData, err := RenderSecretTemplate(map[string]string{"token": `{{ range $.Subdomains }}{{ $.Token }}{{ end }}`}, data)
+	require.NoError(t, err)
+	assert.Len(t, secretData["token"], MaxSecretTemplateSize/2+1)
+}
+
+func TestSecretTemplateWriter_Deadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := &secretTemplateWriter{ctx: ctx}
+	_, err := writer.Write([]byte("token"))
+	require.NoError(t, err)
+
+	// a template still writing past the deadline is stopped
+	cancel()
+	_, err = writer.Write([]byte("token"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "token", writer.buffer.String())
+}
+
+func TestRenderSecretTemplate_Deadline(t *testing.T) {
+	defer func(timeout time.Duration) { secretTemplateTimeout = timeout }(secretTemplateTimeout)
+	secretTemplateTimeout = 0
+
+	// the template is stopped at its first write past the deadline
+	_, err := RenderSecretTemplate(map[string]string{"token": `{{ .Token }}`}, SecretTemplateData{Token: "token"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "secret template did not complete within 0s")
+}
@@ -0,0 +1,111 @@
+package operations
+
+import (
+	"artifactory-secrets-rotator/api/v1alpha1"
+	"slices"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// cachedTokens are the tokens last issued for a SecretRotator
+type cachedTokens struct {
+	tokens         map[string]*AccessResponse
+	artifactoryUrl string
+	issuedAt       metav1.Time
+	ttlInSeconds   float64
+	authType       string
+}
+
+// TokenCache holds the tokens last issued per SecretRotator, so drifted secrets can be restored without issuing new tokens.
+// The tokens are only kept in memory, a restarted operator issues new tokens on the next reconciliation.
+type TokenCache struct {
+	mu     sync.RWMutex
+	tokens map[types.NamespacedName]cachedTokens
+}
+
+// NewTokenCache creates an empty TokenCache
+func NewTokenCache() *TokenCache {
+	return &TokenCache{tokens: map[types.NamespacedName]cachedTokens{}}
+}
+
+// Store caches the tokens issued for the SecretRotator
+func (c *TokenCache) Store(secretRotator *v1alpha1.SecretRotator, tokenDetails *TokenDetails) {
+	if len(tokenDetails.Tokens) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[cacheKey(secretRotator)] = cachedTokens{
+		tokens:         tokenDetails.Tokens,
+		artifactoryUrl: tokenDetails.ArtifactoryUrl,
+		issuedAt:       tokenDetails.IssuedAt,
+		ttlInSeconds:   tokenDetails.TTLInSeconds,
+		authType:       tokenDetails.AuthType,
+	}
+}
+
+// Load fills the token details with the cached tokens of the SecretRotator and the Artifactory host they were issued by.
+// It reports false when no token is cached for the requested scopes or a cached token expires before the minimum validity.
+func (c *TokenCache) Load(secretRotator *v1alpha1.SecretRotator, tokenDetails *TokenDetails, now time.Time, minValidity time.Duration) bool {
+	c.mu.RLock()
+	cached, ok := c.tokens[cacheKey(secretRotator)]
+	c.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	candidate := &TokenDetails{Tokens: cached.tokens, IssuedAt: cached.issuedAt, TTLInSeconds: cached.ttlInSeconds}
+	for _, scope := range tokenDetails.RequestedScopes() {
+		accessToken := candidate.TokenForScope(scope)
+		if accessToken == nil {
+			return false
+		}
+		if expiresAt := candidate.TokenExpiresAt(accessToken); expiresAt != nil && expiresAt.Time.Before(now.Add(minValidity)) {
+			return false
+		}
+	}
+	tokenDetails.Tokens = cached.tokens
+	tokenDetails.ArtifactoryUrl = cached.artifactoryUrl
+	tokenDetails.IssuedAt = cached.issuedAt
+	tokenDetails.TTLInSeconds = cached.ttlInSeconds
+	tokenDetails.AuthType = cached.authType
+	return true
+}
+
+// Forget drops the tokens cached for a deleted SecretRotator
+func (c *TokenCache) Forget(secretRotator *v1alpha1.SecretRotator) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, cacheKey(secretRotator))
+}
+
+// cacheKey returns the cache key of the SecretRotator
+func cacheKey(secretRotator *v1alpha1.SecretRotator) types.NamespacedName {
+	return types.NamespacedName{Namespace: secretRotator.Namespace, Name: secretRotator.Name}
+}
+
+// ManagingSecretRotator returns the SecretRotator whose status lists the secret in the namespace as managed, or nil
+func ManagingSecretRotator(secretRotators *v1alpha1.SecretRotatorList, namespace, secretName string) *v1alpha1.SecretRotator {
+	for i := range secretRotators.Items {
+		if slices.Contains(secretRotators.Items[i].Status.SecretManagedByNamespaces[namespace], secretName) {
+			return &secretRotators.Items[i]
+		}
+	}
+	return nil
+}
+
+// GeneratedSecretByName returns the configured generated secret with the name, including the deprecated spec.secretName
+func GeneratedSecretByName(secretRotator *v1alpha1.SecretRotator, secretName string) (v1alpha1.GeneratedSecret, bool) {
+	for _, gSecret := range secretRotator.Spec.GeneratedSecrets {
+		if gSecret.SecretName == secretName {
+			return gSecret, true
+		}
+	}
+	if secretRotator.Spec.SecretName != "" && secretRotator.Spec.SecretName == secretName {
+		return v1alpha1.GeneratedSecret{SecretName: secretName, SecretType: SecretTypeDocker}, true
+	}
+	return v1alpha1.GeneratedSecret{}, false
+}
@@ -0,0 +1,72 @@
+package operations
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTokenCache_Success(t *testing.T) {
+	issuedAt := time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC)
+	secretRotator := &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"}}
+	tokenCache := NewTokenCache()
+	tokenCache.Store(secretRotator, &TokenDetails{
+		ArtifactoryUrl: "acme.jfrog.io",
+		IssuedAt:       metav1.NewTime(issuedAt),
+		TTLInSeconds:   3600,
+		AuthType:       "oidc",
+		Tokens: map[string]*AccessResponse{
+			"":             {TokenId: "default-id", ExpiresIn: 600},
+			"groups:admin": {TokenId: "admin-id"},
+		},
+	})
+
+	tokenDetails := &TokenDetails{GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "admin", Scope: "groups:admin"}}}
+	require.True(t, tokenCache.Load(secretRotator, tokenDetails, issuedAt.Add(30*time.Minute), time.Minute))
+	assert.Equal(t, "admin-id", tokenDetails.TokenForScope("groups:admin").TokenId)
+	assert.Equal(t, "acme.jfrog.io", tokenDetails.ArtifactoryUrl)
+	assert.Equal(t, "oidc", tokenDetails.AuthType)
+	assert.Equal(t, issuedAt, tokenDetails.IssuedAt.Time)
+
+	// the default scope token expires after 10 minutes
+	tokenDetails = &TokenDetails{GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "pull"}}}
+	assert.True(t, tokenCache.Load(secretRotator, tokenDetails, issuedAt.Add(5*time.Minute), time.Minute))
+	assert.False(t, tokenCache.Load(secretRotator, &TokenDetails{GeneratedSecrets: tokenDetails.GeneratedSecrets}, issuedAt.Add(9*time.Minute+30*time.Second), time.Minute))
+
+	// no token was issued for the scope
+	assert.False(t, tokenCache.Load(secretRotator, &TokenDetails{GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "read", Scope: "groups:readers"}}}, issuedAt, time.Minute))
+
+	tokenCache.Forget(secretRotator)
+	assert.False(t, tokenCache.Load(secretRotator, &TokenDetails{GeneratedSecrets: tokenDetails.GeneratedSecrets}, issuedAt, time.Minute))
+}
+
+func TestManagingSecretRotator_Success(t *testing.T) {
+	secretRotators := &jfrogv1alpha1.SecretRotatorList{Items: []jfrogv1alpha1.SecretRotator{
+		{ObjectMeta: metav1.ObjectMeta{Name: "rotator-a"}, Status: jfrogv1alpha1.SecretRotatorStatus{SecretManagedByNamespaces: map[string][]string{"team-a": {"pull"}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "rotator-b"}, Status: jfrogv1alpha1.SecretRotatorStatus{SecretManagedByNamespaces: map[string][]string{"team-b": {"pull", "npmrc"}}}},
+	}}
+
+	assert.Equal(t, "rotator-a", ManagingSecretRotator(secretRotators, "team-a", "pull").Name)
+	assert.Equal(t, "rotator-b", ManagingSecretRotator(secretRotators, "team-b", "npmrc").Name)
+	assert.Nil(t, ManagingSecretRotator(secretRotators, "team-a", "npmrc"))
+}
+
+func TestGeneratedSecretByName_Success(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{Spec: jfrogv1alpha1.SecretRotatorSpec{
+		SecretName:       "legacy",
+		GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{{SecretName: "npmrc", SecretType: SecretTypeNpm}},
+	}}
+
+	gSecret, ok := GeneratedSecretByName(secretRotator, "npmrc")
+	require.True(t, ok)
+	assert.Equal(t, SecretTypeNpm, gSecret.SecretType)
+	gSecret, ok = GeneratedSecretByName(secretRotator, "legacy")
+	require.True(t, ok)
+	assert.Equal(t, SecretTypeDocker, gSecret.SecretType)
+	_, ok = GeneratedSecretByName(secretRotator, "removed")
+	assert.False(t, ok)
+}
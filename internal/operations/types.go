@@ -5,7 +5,9 @@ import (
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 
 	"artifactory-secrets-rotator/api/v1alpha1"
 )
@@ -13,8 +15,17 @@ import (
 // Secret Info
 const (
 	// Secret types
-	SecretTypeDocker  = "docker"
-	SecretTypeGeneric = "generic"
+	SecretTypeDocker    = "docker"
+	SecretTypeGeneric   = "generic"
+	SecretTypeBasicAuth = "basicAuth"
+	SecretTypeNpm       = "npm"
+	SecretTypeMaven     = "maven"
+	SecretTypeGradle    = "gradle"
+	SecretTypeNetrc     = "netrc"
+	SecretTypePip       = "pip"
+	SecretTypeArgocd    = "argocd"
+	SecretTypeFlux      = "flux"
+	SecretTypeTemplate  = "template"
 
 	// Generic secret keys
 	GenericSecretUser  = "user"
@@ -22,8 +33,91 @@ const (
 
 	// Docker secret key
 	DockerSecretJSON = ".dockerconfigjson"
+
+	// Npm secret key
+	NpmrcKey = ".npmrc"
+
+	// Maven secret key
+	MavenSettingsKey = "settings.xml"
+
+	// Gradle secret key
+	GradlePropertiesKey = "gradle.properties"
+
+	// DefaultGradlePropertyPrefix is the default prefix of the gradle url, user and password properties
+	DefaultGradlePropertyPrefix = "artifactory"
+
+	// Netrc secret key
+	NetrcKey = ".netrc"
+
+	// Pip secret key
+	PipConfKey = "pip.conf"
+
+	// ArgocdSecretTypeLabel is the label Argo CD discovers its repository secrets by
+	ArgocdSecretTypeLabel = "argocd.argoproj.io/secret-type"
+
+	// Argo CD credential types, the values of the secret type label
+	ArgocdRepoCreds  = "repo-creds"
+	ArgocdRepository = "repository"
+
+	// Argo CD repository types
+	ArgocdRepositoryTypeHelm = "helm"
+	ArgocdRepositoryTypeOci  = "oci"
+
+	// Flux repository types
+	FluxRepositoryTypeHelm = "helm"
+	FluxRepositoryTypeOci  = "oci"
+
+	// Flux CA certificate keys, caFile is read by Flux versions before ca.crt was supported
+	FluxCAFileKey = "caFile"
+	FluxCACrtKey  = "ca.crt"
+
+	// FluxReconcileRequestAnnotation requests Flux to reconcile the annotated object
+	FluxReconcileRequestAnnotation = "reconcile.fluxcd.io/requestedAt"
 )
 
+// SupportedSecretTypes are the secret types the operator can generate
+var SupportedSecretTypes = []string{SecretTypeDocker, SecretTypeGeneric, SecretTypeBasicAuth, SecretTypeNpm, SecretTypeMaven, SecretTypeGradle, SecretTypeNetrc, SecretTypePip, SecretTypeArgocd, SecretTypeFlux, SecretTypeTemplate}
+
+// RenamableSecretKeys are the default data keys of the secret types whose keys can be renamed with dataKeys
+var RenamableSecretKeys = map[string][]string{
+	SecretTypeGeneric: {GenericSecretUser, GenericSecretToken},
+	SecretTypeNpm:     {NpmrcKey},
+	SecretTypeMaven:   {MavenSettingsKey},
+	SecretTypeGradle:  {GradlePropertiesKey},
+	SecretTypeNetrc:   {NetrcKey},
+	SecretTypePip:     {PipConfKey},
+}
+
+// Secret annotations reporting the token a generated secret holds
+const (
+	// LastRotationTimeAnnotation holds when the secret was last written with a new token
+	LastRotationTimeAnnotation = "secretrotator.jfrog.com/last-rotation-time"
+	// TokenExpiresAtAnnotation holds when the token the secret holds expires
+	TokenExpiresAtAnnotation = "secretrotator.jfrog.com/token-expires-at"
+	// TokenIDAnnotation holds the id of the token the secret holds
+	TokenIDAnnotation = "secretrotator.jfrog.com/token-id"
+	// ScopeAnnotation holds the scope the token the secret holds was issued with
+	ScopeAnnotation = "secretrotator.jfrog.com/scope"
+)
+
+// TokenAnnotations are the annotations reporting the token a generated secret holds
+var TokenAnnotations = []string{LastRotationTimeAnnotation, TokenExpiresAtAnnotation, TokenIDAnnotation, ScopeAnnotation}
+
+// ManagedAuthsAnnotation holds, per SecretRotator, the registries whose auths entries it owns in a merged docker config secret
+const ManagedAuthsAnnotation = "secretrotator.jfrog.com/managed-auths"
+
+// ImagePullSecretsAnnotation holds the secrets the operator added to the imagePullSecrets of a ServiceAccount
+const ImagePullSecretsAnnotation = "secretrotator.jfrog.com/image-pull-secrets"
+
+// SecretHashesAnnotation holds, per rotated secret, the hash of the secret data on the pod template of the restarted workloads
+const SecretHashesAnnotation = "secretrotator.jfrog.com/secret-hashes"
+
+// OptOutAnnotation on a namespace lists the comma separated SecretRotators, or * for all of them, refused to provision it
+const OptOutAnnotation = "secretrotator.jfrog.com/opt-out"
+
+// DefaultServiceAccountName is the ServiceAccount docker secrets are attached to when no ServiceAccounts are selected
+const DefaultServiceAccountName = "default"
+
 // AccessResponse JFrog token response
 type AccessResponse struct {
 	TokenId     string `json:"token_id"`
@@ -34,6 +128,12 @@ type AccessResponse struct {
 	Username    string `json:"username"`
 }
 
+// AccessRequest JFrog token request
+type AccessRequest struct {
+	ExpiresIn int32  `json:"expires_in"`
+	Scope     string `json:"scope,omitempty"`
+}
+
 // TokenDetails holding resource object token details
 type TokenDetails struct {
 	// SecretName is optional in 2.x and will be depreciate in next upcoming releases
@@ -44,8 +144,7 @@ type TokenDetails struct {
 	ProvisionedNamespaces          []string
 	TTLInSeconds                   float64
 	SecretManagedByNamespaces      map[string][]string
-	Username                       string
-	Token                          string
+	Tokens                         map[string]*AccessResponse
 	ArtifactoryUrl                 string
 	NamespaceSelector              labels.Selector
 	RequeueInterval                time.Duration
@@ -54,6 +153,82 @@ type TokenDetails struct {
 	RoleMaxSessionDuration         *int32
 	IAMRoleAwsRegion               string
 	AuthType                       string
+	SecretsOutdated                bool
+	IssuedAt                       metav1.Time
+	SecretStatuses                 []v1alpha1.SecretStatus
+	// RotatedSecrets are the secrets written by the rotation by namespace, their consuming workloads are restarted once
+	RotatedSecrets map[string][]string
+	// Credentials are the ArtifactoryCredentials approved by the credentialPolicy
+	Credentials []v1alpha1.ArtifactoryCredential
+	// CredentialTokens are the tokens issued separately for each approved ArtifactoryCredential, keyed by the requested scope
+	CredentialTokens map[types.NamespacedName]map[string]*AccessResponse
+	// CredentialErrors hold why the tokens of an approved ArtifactoryCredential could not be issued
+	CredentialErrors map[types.NamespacedName]error
+}
+
+// RequestedScopes returns the distinct scopes requested by the generated secrets of the SecretRotator, in order of appearance
+func (t *TokenDetails) RequestedScopes() []string {
+	return distinctScopes(t.GeneratedSecrets)
+}
+
+// CredentialScopes returns the distinct scopes requested by the ArtifactoryCredential, in order of appearance
+func CredentialScopes(credential *v1alpha1.ArtifactoryCredential) []string {
+	return distinctScopes(credential.Spec.GeneratedSecrets)
+}
+
+// distinctScopes returns the distinct scopes of the generated secrets, in order of appearance
+func distinctScopes(gSecrets []v1alpha1.GeneratedSecret) []string {
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, gSecret := range gSecrets {
+		if seen[gSecret.Scope] {
+			continue
+		}
+		seen[gSecret.Scope] = true
+		scopes = append(scopes, gSecret.Scope)
+	}
+	return scopes
+}
+
+// ForCredential returns the token details the secrets of the ArtifactoryCredential are rendered with, holding only its own tokens
+func (t *TokenDetails) ForCredential(credential *v1alpha1.ArtifactoryCredential) *TokenDetails {
+	credentialTokenDetails := *t
+	credentialTokenDetails.Tokens = t.CredentialTokens[CredentialKey(credential)]
+	return &credentialTokenDetails
+}
+
+// CredentialKey returns the key of the ArtifactoryCredential in CredentialTokens and CredentialErrors
+func CredentialKey(credential *v1alpha1.ArtifactoryCredential) types.NamespacedName {
+	return types.NamespacedName{Namespace: credential.Namespace, Name: credential.Name}
+}
+
+// TokenForScope returns the token issued for the requested scope, or nil if none was issued
+func (t *TokenDetails) TokenForScope(scope string) *AccessResponse {
+	if t.Tokens == nil {
+		return nil
+	}
+	return t.Tokens[scope]
+}
+
+// IssuedScope returns the scope reported by Artifactory for the token issued for the requested scope, or the requested scope
+func (t *TokenDetails) IssuedScope(scope string) string {
+	if accessToken := t.TokenForScope(scope); accessToken != nil && accessToken.Scope != "" {
+		return accessToken.Scope
+	}
+	return scope
+}
+
+// TokenExpiresAt returns when the issued token expires, falling back to the token TTL when Artifactory did not report an expiry
+func (t *TokenDetails) TokenExpiresAt(accessToken *AccessResponse) *metav1.Time {
+	expiresIn := float64(accessToken.ExpiresIn)
+	if expiresIn <= 0 {
+		expiresIn = t.TTLInSeconds
+	}
+	if expiresIn <= 0 {
+		return nil
+	}
+	expiresAt := metav1.NewTime(t.IssuedAt.Add(time.Duration(expiresIn * float64(time.Second))))
+	return &expiresAt
 }
 
 // ReconcileError reconcile error struct
@@ -74,6 +249,21 @@ const (
 	TypeAvailableSecretRotator = "Available"
 	// TypeDegradedSecretRotator represents the status used when the custom resource is deleted and the finalizer operations are must to occur.
 	TypeDegradedSecretRotator = "Degraded"
+	// TypeCleanedUpSecretRotator represents whether the deletion policy was enforced, the finalizer is kept while it is false.
+	TypeCleanedUpSecretRotator = "CleanedUp"
+	// TypeApprovedArtifactoryCredential represents whether the credentialPolicy of the referenced SecretRotator approved the ArtifactoryCredential
+	TypeApprovedArtifactoryCredential = "Approved"
+	// TypeAvailableArtifactoryCredential represents whether the secrets requested by the ArtifactoryCredential were written
+	TypeAvailableArtifactoryCredential = "Available"
+)
+
+const (
+	// DeletionPolicyDelete deletes the generated secrets and revokes the live tokens when the SecretRotator is deleted
+	DeletionPolicyDelete = "Delete"
+	// DeletionPolicyRetain leaves the generated secrets and the live tokens untouched when the SecretRotator is deleted
+	DeletionPolicyRetain = "Retain"
+	// DeletionPolicyOrphan removes the SecretRotator owner references from the generated secrets, so they outlive the SecretRotator
+	DeletionPolicyOrphan = "Orphan"
 )
 
 const (
@@ -93,6 +283,18 @@ const (
 
 	// ServiceAccountExpirationSeconds is the default expiration time for service account tokens
 	ServiceAccountExpirationSeconds = 3600
+
+	// DefaultRevocationGracePeriod is the default time superseded tokens stay valid after every secret was rotated
+	DefaultRevocationGracePeriod = 5 * time.Minute
+
+	// DefaultRotateBeforePercentage is the default share of the token TTL left when tokens are rotated
+	DefaultRotateBeforePercentage = 25
+
+	// DefaultMaxRestartsPerMinute is the default number of workloads restarted per minute for a SecretRotator
+	DefaultMaxRestartsPerMinute = 10
+
+	// RestoreMinTokenValidity is the minimum validity left on a cached token to restore a drifted secret with it
+	RestoreMinTokenValidity = time.Minute
 )
 
 const (
@@ -104,8 +306,101 @@ const (
 
 	// AutoAuthType is the type of authentication used to get the AWS credentials automatically. If the Pod Identity is detected, it will use Pod Identity, otherwise it will use Web Identity.
 	AutoAuthType = "auto"
+
+	// KubernetesOidcAuthType is the type of authentication exchanging a projected ServiceAccount token through Artifactory's OIDC integration, no cloud provider is needed
+	KubernetesOidcAuthType = "kubernetesOidc"
+
+	// GcpWorkloadIdentityAuthType is the type of authentication exchanging a Google-signed identity token of the bound Google service account through Artifactory's OIDC integration
+	GcpWorkloadIdentityAuthType = "gcpWorkloadIdentity"
+
+	// AzureWorkloadIdentityAuthType is the type of authentication exchanging a Microsoft Entra ID token of the federated identity through Artifactory's OIDC integration
+	AzureWorkloadIdentityAuthType = "azureWorkloadIdentity"
+)
+
+const (
+	// GcpServiceAccountKey is the ServiceAccount annotation binding it to a Google service account
+	GcpServiceAccountKey = "iam.gke.io/gcp-service-account"
+
+	// AzureClientIDKey is the ServiceAccount annotation holding the client id of the federated Azure identity
+	AzureClientIDKey = "azure.workload.identity/client-id"
+
+	// AzureTenantIDKey is the ServiceAccount annotation holding the Microsoft Entra tenant id
+	AzureTenantIDKey = "azure.workload.identity/tenant-id"
+
+	// AzureTokenExchangeAudience is the audience of ServiceAccount tokens federated with Microsoft Entra ID
+	AzureTokenExchangeAudience = "api://AzureADTokenExchange"
 )
 
+// DefaultAuthPriority is the order in which the auto auth type detects identity providers
+var DefaultAuthPriority = []string{PodIdentityAuthType, WebIdentityAuthType}
+
+// AuthPriority returns the order in which the auto auth type detects identity providers
+func AuthPriority(secretRotator *v1alpha1.SecretRotator) []string {
+	if len(secretRotator.Spec.AuthPriority) > 0 {
+		return secretRotator.Spec.AuthPriority
+	}
+	return DefaultAuthPriority
+}
+
+// IsAwsAuthType checks if the auth type resolves AWS credentials and therefore needs an IAM role
+func IsAwsAuthType(authType string) bool {
+	return authType == WebIdentityAuthType || authType == PodIdentityAuthType
+}
+
+// RequiresAwsRole checks if the SecretRotator can only authenticate through AWS and therefore needs an IAM role
+func RequiresAwsRole(secretRotator *v1alpha1.SecretRotator) bool {
+	if secretRotator.Spec.AuthType != "" && secretRotator.Spec.AuthType != AutoAuthType {
+		return IsAwsAuthType(secretRotator.Spec.AuthType)
+	}
+	for _, authType := range AuthPriority(secretRotator) {
+		if !IsAwsAuthType(authType) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsOidcAuthType checks if the auth type exchanges an identity token through Artifactory's OIDC integration,
+// whose identity mapping defines the token scope
+func IsOidcAuthType(authType string) bool {
+	return authType == KubernetesOidcAuthType || authType == GcpWorkloadIdentityAuthType || authType == AzureWorkloadIdentityAuthType
+}
+
+// SupportsScopedTokens checks if every auth type the SecretRotator may use applies the requested token scope
+func SupportsScopedTokens(secretRotator *v1alpha1.SecretRotator) bool {
+	if secretRotator.Spec.AuthType != "" && secretRotator.Spec.AuthType != AutoAuthType {
+		return !IsOidcAuthType(secretRotator.Spec.AuthType)
+	}
+	for _, authType := range AuthPriority(secretRotator) {
+		if IsOidcAuthType(authType) {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	// DefaultOidcAudience is the default audience of ServiceAccount tokens exchanged with Artifactory
+	DefaultOidcAudience = "jfrog"
+
+	// OidcGrantType is the OAuth token exchange grant type expected by Artifactory
+	OidcGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	// OidcSubjectTokenType is the type of the exchanged subject token
+	OidcSubjectTokenType = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+// OidcTokenExchangeRequest JFrog OIDC token exchange request
+type OidcTokenExchangeRequest struct {
+	GrantType           string `json:"grant_type"`
+	SubjectTokenType    string `json:"subject_token_type"`
+	SubjectToken        string `json:"subject_token"`
+	ProviderName        string `json:"provider_name"`
+	IdentityMappingName string `json:"identity_mapping_name,omitempty"`
+	ProjectKey          string `json:"project_key,omitempty"`
+	ExpiresIn           int32  `json:"expires_in,omitempty"`
+}
+
 // CredentialsResponse is the response from the credentials endpoint
 type CredentialsResponse struct {
 	AccessKeyId     string `json:"AccessKeyId"`
@@ -0,0 +1,102 @@
+package resource
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fluxSourceGroup is the API group of the Flux sources
+const fluxSourceGroup = "source.toolkit.fluxcd.io"
+
+// fluxSourceKinds are the Flux sources reading registry credentials from their secretRef,
+// with the versions to list them by in order of preference
+var fluxSourceKinds = []struct {
+	kind     string
+	versions []string
+}{
+	{kind: "HelmRepository", versions: []string{"v1"}},
+	{kind: "OCIRepository", versions: []string{"v1", "v1beta2"}},
+}
+
+// fluxDetails returns the Flux source credential of the secret, nil for other secret types
+func fluxDetails(gSecret jfrogv1alpha1.GeneratedSecret) *jfrogv1alpha1.FluxSecretDetails {
+	if gSecret.SecretType != operations.SecretTypeFlux || gSecret.Flux == nil {
+		return nil
+	}
+	return gSecret.Flux
+}
+
+// loadCACertificate reads the CA certificate from the security certificate secret of the SecretRotator
+func loadCACertificate(ctx context.Context, secretRotator *jfrogv1alpha1.SecretRotator, k8sClient client.Client) ([]byte, error) {
+	security := secretRotator.Spec.Security
+	if !security.Enabled || security.CertificateSecretName == "" {
+		return nil, fmt.Errorf("the CA certificate is bundled from spec.security.certificateSecretName, which is not configured")
+	}
+	secret, err := GetSecret(ctx, security.SecretNamespace, security.CertificateSecretName, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the security certificate secret %s: %w", security.CertificateSecretName, err)
+	}
+	for _, key := range []string{jfrogv1alpha1.TlsCa, jfrogv1alpha1.CaPem} {
+		if caCertificate := secret.Data[strings.TrimPrefix(key, "/")]; len(caCertificate) > 0 {
+			return caCertificate, nil
+		}
+	}
+	return nil, fmt.Errorf("the security certificate secret %s holds neither ca.crt nor ca.pem", security.CertificateSecretName)
+}
+
+// requestFluxReconcile annotates the Flux sources in the namespace referencing the secret, so Flux reconciles them with the rotated token.
+// Sources whose CRDs are not installed are skipped.
+func requestFluxReconcile(ctx context.Context, k8sClient client.Client, namespace, secretName string, requestedAt time.Time) error {
+	var errs []error
+	for _, source := range fluxSourceKinds {
+		sources, err := listFluxSources(ctx, k8sClient, namespace, source.kind, source.versions)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list Flux %s sources in namespace %s: %w", source.kind, namespace, err))
+			continue
+		}
+		for i := range sources {
+			fluxSource := &sources[i]
+			if name, _, _ := unstructured.NestedString(fluxSource.Object, "spec", "secretRef", "name"); name != secretName {
+				continue
+			}
+			patch := client.MergeFrom(fluxSource.DeepCopy())
+			annotations := fluxSource.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[operations.FluxReconcileRequestAnnotation] = requestedAt.Format(time.RFC3339Nano)
+			fluxSource.SetAnnotations(annotations)
+			if err := k8sClient.Patch(ctx, fluxSource, patch); err != nil {
+				errs = append(errs, fmt.Errorf("failed to request the reconciliation of Flux %s %s/%s: %w", source.kind, namespace, fluxSource.GetName(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listFluxSources lists the Flux sources of a kind in the namespace by the first of the versions the cluster serves
+func listFluxSources(ctx context.Context, k8sClient client.Client, namespace, kind string, versions []string) ([]unstructured.Unstructured, error) {
+	mapping, err := k8sClient.RESTMapper().RESTMapping(schema.GroupKind{Group: fluxSourceGroup, Kind: kind}, versions...)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sources := &unstructured.UnstructuredList{}
+	sources.SetGroupVersionKind(mapping.GroupVersionKind.GroupVersion().WithKind(kind + "List"))
+	if err := k8sClient.List(ctx, sources, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	return sources.Items, nil
+}
@@ -0,0 +1,102 @@
+package resource
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	controller "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newFluxSource(kind, version, namespace, name, secretName string) *unstructured.Unstructured {
+	fluxSource := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"secretRef": map[string]interface{}{"name": secretName}},
+	}}
+	fluxSource.SetGroupVersionKind(schema.GroupVersionKind{Group: fluxSourceGroup, Version: version, Kind: kind})
+	fluxSource.SetNamespace(namespace)
+	fluxSource.SetName(name)
+	return fluxSource
+}
+
+// newFluxClient serves HelmRepository v1 and OCIRepository v1beta2 only, like a Flux release before OCIRepository v1
+func newFluxClient(objects ...client.Object) client.Client {
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(schema.GroupVersionKind{Group: fluxSourceGroup, Version: "v1", Kind: "HelmRepository"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Group: fluxSourceGroup, Version: "v1beta2", Kind: "OCIRepository"}, meta.RESTScopeNamespace)
+	restMapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	return fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(restMapper).WithObjects(objects...).Build()
+}
+
+func fluxReconcileRequest(t *testing.T, k8sClient client.Client, kind, version, namespace, name string) string {
+	fluxSource := &unstructured.Unstructured{}
+	fluxSource.SetGroupVersionKind(schema.GroupVersionKind{Group: fluxSourceGroup, Version: version, Kind: kind})
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: name}, fluxSource))
+	return fluxSource.GetAnnotations()[operations.FluxReconcileRequestAnnotation]
+}
+
+func TestRequestFluxReconcile_Success(t *testing.T) {
+	k8sClient := newFluxClient(
+		newFluxSource("HelmRepository", "v1", "flux-apps", "charts", "artifactory-creds"),
+		newFluxSource("HelmRepository", "v1", "flux-apps", "other-charts", "other-creds"),
+		newFluxSource("OCIRepository", "v1beta2", "flux-apps", "manifests", "artifactory-creds"),
+		newFluxSource("HelmRepository", "v1", "team-b", "charts", "artifactory-creds"),
+	)
+	requestedAt := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	require.NoError(t, requestFluxReconcile(context.Background(), k8sClient, "flux-apps", "artifactory-creds", requestedAt))
+
+	assert.Equal(t, "2025-01-02T03:04:05.000000006Z", fluxReconcileRequest(t, k8sClient, "HelmRepository", "v1", "flux-apps", "charts"))
+	assert.Equal(t, "2025-01-02T03:04:05.000000006Z", fluxReconcileRequest(t, k8sClient, "OCIRepository", "v1beta2", "flux-apps", "manifests"))
+	assert.Empty(t, fluxReconcileRequest(t, k8sClient, "HelmRepository", "v1", "flux-apps", "other-charts"))
+	assert.Empty(t, fluxReconcileRequest(t, k8sClient, "HelmRepository", "v1", "team-b", "charts"))
+}
+
+func TestCreateOrUpdateSecrets_FluxIncludeCA(t *testing.T) {
+	tokenDetails := newIssuedTokenDetails(metav1.Now())
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			Security: jfrogv1alpha1.SecurityDetails{Enabled: true, CertificateSecretName: "artifactory-certs", SecretNamespace: "jfrog-operator"},
+		},
+	}
+	k8sClient := newFluxClient(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "artifactory-certs", Namespace: "jfrog-operator"},
+			Data:       map[string][]byte{"tls.crt": []byte("server-cert"), "ca.crt": []byte("ca-cert")},
+		},
+		newFluxSource("HelmRepository", "v1", "flux-apps", "charts", "artifactory-creds"),
+	)
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "flux-apps"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "artifactory-creds", SecretType: operations.SecretTypeFlux,
+		Flux: &jfrogv1alpha1.FluxSecretDetails{IncludeCA: true, ReconcileSources: true}}
+
+	err, _ := CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	require.NoError(t, err)
+
+	secret, err := GetSecret(context.Background(), "flux-apps", "artifactory-creds", k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, corev1.SecretTypeOpaque, secret.Type)
+	assert.Equal(t, map[string][]byte{
+		corev1.BasicAuthUsernameKey: []byte("operator"),
+		corev1.BasicAuthPasswordKey: []byte("default-token"),
+		operations.FluxCAFileKey:    []byte("ca-cert"),
+		operations.FluxCACrtKey:     []byte("ca-cert"),
+	}, secret.Data)
+	assert.NotEmpty(t, fluxReconcileRequest(t, k8sClient, "HelmRepository", "v1", "flux-apps", "charts"))
+
+	// without the security certificate secret the CA can't be bundled
+	secretRotator.Spec.Security = jfrogv1alpha1.SecurityDetails{}
+	err, _ = CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	assert.Error(t, err)
+}
@@ -0,0 +1,376 @@
+package resource
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// renderInput holding what a secret renderer needs to write the issued token in the secret's format
+type renderInput struct {
+	TokenDetails    *operations.TokenDetails
+	SecretRotator   *jfrogv1alpha1.SecretRotator
+	GeneratedSecret jfrogv1alpha1.GeneratedSecret
+	AccessToken     *operations.AccessResponse
+	Namespace       string
+	// CACertificate of the security certificate secret, loaded for the secrets bundling it
+	CACertificate []byte
+}
+
+// secretRenderer renders the type and data of a generated secret from the issued token
+type secretRenderer func(input *renderInput) (corev1.SecretType, map[string][]byte, error)
+
+// secretRenderers are the renderers of the supported secret types
+var secretRenderers = map[string]secretRenderer{
+	operations.SecretTypeDocker:    renderDockerSecret,
+	operations.SecretTypeGeneric:   renderGenericSecret,
+	operations.SecretTypeBasicAuth: renderBasicAuthSecret,
+	operations.SecretTypeNpm:       renderNpmSecret,
+	operations.SecretTypeMaven:     renderMavenSecret,
+	operations.SecretTypeGradle:    renderGradleSecret,
+	operations.SecretTypeNetrc:     renderNetrcSecret,
+	operations.SecretTypePip:       renderPipSecret,
+	operations.SecretTypeArgocd:    renderArgocdSecret,
+	operations.SecretTypeFlux:      renderFluxSecret,
+	operations.SecretTypeTemplate:  renderTemplateSecret,
+}
+
+// renderSecret renders the type and data of the generated secret with the renderer of its secret type
+// and renames the data keys configured in dataKeys
+func renderSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	render, ok := secretRenderers[input.GeneratedSecret.SecretType]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported secret type '%s' of secret %s", input.GeneratedSecret.SecretType, input.GeneratedSecret.SecretName)
+	}
+	secretType, data, err := render(input)
+	if err != nil || len(input.GeneratedSecret.DataKeys) == 0 {
+		return secretType, data, err
+	}
+
+	renamed := make(map[string][]byte, len(data))
+	for key, value := range data {
+		if dataKey, ok := input.GeneratedSecret.DataKeys[key]; ok {
+			key = dataKey
+		}
+		renamed[key] = value
+	}
+	return secretType, renamed, nil
+}
+
+// renderDockerSecret renders a docker config json holding the token for the Artifactory url and subdomains
+func renderDockerSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	// Create base64-encoded auth string for Docker config
+	auth := fmt.Sprintf("%s:%s", input.AccessToken.Username, input.AccessToken.AccessToken)
+	tokenb64 := base64.StdEncoding.EncodeToString([]byte(auth))
+
+	// generateDockerConfigJSON creates a valid dockerconfig.json structure
+	// with the provided token and returns it as a byte slice
+	dockerConfigBytes, err := generateDockerConfigJSON(tokenb64, input.SecretRotator)
+	if err != nil {
+		return "", nil, err
+	}
+	return corev1.SecretTypeDockerConfigJson, map[string][]byte{
+		operations.DockerSecretJSON: dockerConfigBytes,
+	}, nil
+}
+
+// renderGenericSecret renders the username and token as separate keys
+func renderGenericSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	return corev1.SecretTypeOpaque, map[string][]byte{
+		operations.GenericSecretUser:  []byte(input.AccessToken.Username),
+		operations.GenericSecretToken: []byte(input.AccessToken.AccessToken),
+	}, nil
+}
+
+// renderBasicAuthSecret renders the username and token as a kubernetes.io/basic-auth secret
+func renderBasicAuthSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	return corev1.SecretTypeBasicAuth, map[string][]byte{
+		corev1.BasicAuthUsernameKey: []byte(input.AccessToken.Username),
+		corev1.BasicAuthPasswordKey: []byte(input.AccessToken.AccessToken),
+	}, nil
+}
+
+// renderNpmSecret renders an .npmrc authenticating the default registry and the package scope registries with the token
+func renderNpmSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	npmDetails := input.GeneratedSecret.Npm
+	if npmDetails == nil {
+		return "", nil, fmt.Errorf("npm secret %s has no npm registries configured", input.GeneratedSecret.SecretName)
+	}
+
+	lines := []string{}
+	authenticated := map[string]bool{}
+	addRegistry := func(prefix, repository string) {
+		registry := fmt.Sprintf("//%s/artifactory/api/npm/%s/", input.TokenDetails.ArtifactoryUrl, repository)
+		lines = append(lines, fmt.Sprintf("%sregistry=https:%s", prefix, registry))
+		if !authenticated[registry] {
+			authenticated[registry] = true
+			lines = append(lines, fmt.Sprintf("%s:_authToken=%s", registry, input.AccessToken.AccessToken))
+		}
+	}
+
+	if npmDetails.Repository != "" {
+		addRegistry("", npmDetails.Repository)
+	}
+	packageScopes := make([]string, 0, len(npmDetails.PackageScopes))
+	for packageScope := range npmDetails.PackageScopes {
+		packageScopes = append(packageScopes, packageScope)
+	}
+	sort.Strings(packageScopes)
+	for _, packageScope := range packageScopes {
+		addRegistry(packageScope+":", npmDetails.PackageScopes[packageScope])
+	}
+	lines = append(lines, "always-auth=true")
+
+	return corev1.SecretTypeOpaque, map[string][]byte{
+		operations.NpmrcKey: []byte(strings.Join(lines, "\n") + "\n"),
+	}, nil
+}
+
+// mavenSettings is the part of the Maven settings.xml written by the operator
+type mavenSettings struct {
+	XMLName        xml.Name          `xml:"settings"`
+	Xmlns          string            `xml:"xmlns,attr"`
+	Servers        []mavenServer     `xml:"servers>server"`
+	Mirrors        []mavenRepository `xml:"mirrors>mirror,omitempty"`
+	Profiles       []mavenProfile    `xml:"profiles>profile,omitempty"`
+	ActiveProfiles []string          `xml:"activeProfiles>activeProfile,omitempty"`
+}
+
+type mavenServer struct {
+	ID       string `xml:"id"`
+	Username string `xml:"username"`
+	Password string `xml:"password"`
+}
+
+type mavenRepository struct {
+	ID       string `xml:"id"`
+	Name     string `xml:"name,omitempty"`
+	URL      string `xml:"url"`
+	MirrorOf string `xml:"mirrorOf,omitempty"`
+}
+
+type mavenProfile struct {
+	ID                 string            `xml:"id"`
+	Repositories       []mavenRepository `xml:"repositories>repository"`
+	PluginRepositories []mavenRepository `xml:"pluginRepositories>pluginRepository"`
+}
+
+// renderMavenSecret renders a settings.xml with a server per repository holding the token,
+// the mirror repository as mirror of all repositories and the other repositories in the active artifactory profile
+func renderMavenSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	mavenDetails := input.GeneratedSecret.Maven
+	if mavenDetails == nil {
+		return "", nil, fmt.Errorf("maven secret %s has no maven repositories configured", input.GeneratedSecret.SecretName)
+	}
+
+	settings := mavenSettings{Xmlns: "http://maven.apache.org/SETTINGS/1.0.0"}
+	servers := map[string]bool{}
+	addServer := func(repository string) {
+		if servers[repository] {
+			return
+		}
+		servers[repository] = true
+		settings.Servers = append(settings.Servers, mavenServer{ID: repository, Username: input.AccessToken.Username, Password: input.AccessToken.AccessToken})
+	}
+
+	if mavenDetails.MirrorRepository != "" {
+		addServer(mavenDetails.MirrorRepository)
+		settings.Mirrors = append(settings.Mirrors, mavenRepository{
+			ID:       mavenDetails.MirrorRepository,
+			Name:     mavenDetails.MirrorRepository,
+			URL:      artifactoryRepositoryURL(input, mavenDetails.MirrorRepository),
+			MirrorOf: "*",
+		})
+	}
+	if len(mavenDetails.Repositories) > 0 {
+		profile := mavenProfile{ID: "artifactory"}
+		for _, repository := range mavenDetails.Repositories {
+			addServer(repository)
+			mavenRepo := mavenRepository{ID: repository, URL: artifactoryRepositoryURL(input, repository)}
+			profile.Repositories = append(profile.Repositories, mavenRepo)
+			profile.PluginRepositories = append(profile.PluginRepositories, mavenRepo)
+		}
+		settings.Profiles = append(settings.Profiles, profile)
+		settings.ActiveProfiles = append(settings.ActiveProfiles, profile.ID)
+	}
+
+	settingsBytes, err := xml.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal maven settings %w", err)
+	}
+	return corev1.SecretTypeOpaque, map[string][]byte{
+		operations.MavenSettingsKey: append([]byte(xml.Header), append(settingsBytes, '\n')...),
+	}, nil
+}
+
+// renderGradleSecret renders a gradle.properties with the url, user and password properties
+func renderGradleSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	prefix, url := operations.DefaultGradlePropertyPrefix, fmt.Sprintf("https://%s/artifactory", input.TokenDetails.ArtifactoryUrl)
+	if gradleDetails := input.GeneratedSecret.Gradle; gradleDetails != nil {
+		if gradleDetails.PropertyPrefix != "" {
+			prefix = gradleDetails.PropertyPrefix
+		}
+		if gradleDetails.Repository != "" {
+			url = artifactoryRepositoryURL(input, gradleDetails.Repository)
+		}
+	}
+
+	properties := fmt.Sprintf("%sUrl=%s\n%sUser=%s\n%sPassword=%s\n",
+		prefix, escapeProperty(url),
+		prefix, escapeProperty(input.AccessToken.Username),
+		prefix, escapeProperty(input.AccessToken.AccessToken))
+	return corev1.SecretTypeOpaque, map[string][]byte{
+		operations.GradlePropertiesKey: []byte(properties),
+	}, nil
+}
+
+// artifactoryRepositoryURL returns the url of an Artifactory repository
+func artifactoryRepositoryURL(input *renderInput, repository string) string {
+	return fmt.Sprintf("https://%s/artifactory/%s", input.TokenDetails.ArtifactoryUrl, repository)
+}
+
+// escapeProperty escapes the backslashes of a java properties value
+func escapeProperty(value string) string {
+	return strings.ReplaceAll(value, `\`, `\\`)
+}
+
+// renderNetrcSecret renders a .netrc with a machine entry holding the token for the Artifactory host and every subdomain
+func renderNetrcSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	hosts := append([]string{input.TokenDetails.ArtifactoryUrl}, input.SecretRotator.Spec.ArtifactorySubdomains...)
+
+	lines := []string{}
+	written := map[string]bool{}
+	for _, host := range hosts {
+		// netrc machines are bare host names, subdomains may be configured as urls
+		host = strings.SplitN(operations.ArtifactoryHost(host), "/", 2)[0]
+		if host == "" || written[host] {
+			continue
+		}
+		written[host] = true
+		lines = append(lines, fmt.Sprintf("machine %s login %s password %s", host, input.AccessToken.Username, input.AccessToken.AccessToken))
+	}
+
+	return corev1.SecretTypeOpaque, map[string][]byte{
+		operations.NetrcKey: []byte(strings.Join(lines, "\n") + "\n"),
+	}, nil
+}
+
+// renderPipSecret renders a pip.conf whose index-url embeds the token for the PyPI repository
+func renderPipSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	if input.GeneratedSecret.Pip == nil || input.GeneratedSecret.Pip.Repository == "" {
+		return "", nil, fmt.Errorf("pip secret %s has no pip repository configured", input.GeneratedSecret.SecretName)
+	}
+
+	indexURL := url.URL{
+		Scheme: "https",
+		User:   url.UserPassword(input.AccessToken.Username, input.AccessToken.AccessToken),
+		Host:   input.TokenDetails.ArtifactoryUrl,
+		Path:   fmt.Sprintf("/artifactory/api/pypi/%s/simple", input.GeneratedSecret.Pip.Repository),
+	}
+	return corev1.SecretTypeOpaque, map[string][]byte{
+		operations.PipConfKey: []byte(fmt.Sprintf("[global]\nindex-url = %s\n", indexURL.String())),
+	}, nil
+}
+
+// renderTemplateSecret renders every data key from its user defined template
+func renderTemplateSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	data := operations.SecretTemplateData{
+		Username:       input.AccessToken.Username,
+		Token:          input.AccessToken.AccessToken,
+		ArtifactoryURL: fmt.Sprintf("https://%s", input.TokenDetails.ArtifactoryUrl),
+		Subdomains:     input.SecretRotator.Spec.ArtifactorySubdomains,
+		Namespace:      input.Namespace,
+	}
+	if expiresAt := input.TokenDetails.TokenExpiresAt(input.AccessToken); expiresAt != nil {
+		data.ExpiresAt = expiresAt.UTC()
+	}
+
+	secretData, err := operations.RenderSecretTemplate(input.GeneratedSecret.Template, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to render template secret %s %w", input.GeneratedSecret.SecretName, err)
+	}
+	return corev1.SecretTypeOpaque, secretData, nil
+}
+
+// argocdDetails returns the Argo CD repository credential of the secret with its defaults
+func argocdDetails(gSecret jfrogv1alpha1.GeneratedSecret) jfrogv1alpha1.ArgocdSecretDetails {
+	details := jfrogv1alpha1.ArgocdSecretDetails{}
+	if gSecret.Argocd != nil {
+		details = *gSecret.Argocd
+	}
+	if details.CredentialType == "" {
+		details.CredentialType = operations.ArgocdRepoCreds
+	}
+	if details.RepositoryType == "" {
+		details.RepositoryType = operations.ArgocdRepositoryTypeHelm
+	}
+	return details
+}
+
+// renderArgocdSecret renders an Argo CD repository credential for the Artifactory helm or oci repositories
+func renderArgocdSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	details := argocdDetails(input.GeneratedSecret)
+
+	repositoryURL := fmt.Sprintf("https://%s/artifactory/api/helm", input.TokenDetails.ArtifactoryUrl)
+	if details.RepositoryType == operations.ArgocdRepositoryTypeOci {
+		// oci helm repositories are helm repositories with enableOCI and a url without scheme, the oci type and
+		// oci:// urls are only read by Argo CD 3.1 and later
+		repositoryURL = input.TokenDetails.ArtifactoryUrl
+	}
+	if details.Repository != "" {
+		repositoryURL = fmt.Sprintf("%s/%s", repositoryURL, details.Repository)
+	}
+
+	data := map[string][]byte{
+		"url":      []byte(repositoryURL),
+		"username": []byte(input.AccessToken.Username),
+		"password": []byte(input.AccessToken.AccessToken),
+		"type":     []byte(operations.ArgocdRepositoryTypeHelm),
+	}
+	if details.RepositoryType == operations.ArgocdRepositoryTypeOci {
+		data["enableOCI"] = []byte("true")
+	}
+	if details.Project != "" {
+		data["project"] = []byte(details.Project)
+	}
+	return corev1.SecretTypeOpaque, data, nil
+}
+
+// renderFluxSecret renders the credential Flux helm or oci sources read from their secretRef
+func renderFluxSecret(input *renderInput) (corev1.SecretType, map[string][]byte, error) {
+	fluxDetails := jfrogv1alpha1.FluxSecretDetails{}
+	if input.GeneratedSecret.Flux != nil {
+		fluxDetails = *input.GeneratedSecret.Flux
+	}
+	if fluxDetails.RepositoryType == operations.FluxRepositoryTypeOci {
+		return renderDockerSecret(input)
+	}
+
+	data := map[string][]byte{
+		corev1.BasicAuthUsernameKey: []byte(input.AccessToken.Username),
+		corev1.BasicAuthPasswordKey: []byte(input.AccessToken.AccessToken),
+	}
+	if fluxDetails.IncludeCA {
+		if len(input.CACertificate) == 0 {
+			return "", nil, fmt.Errorf("flux secret %s includes the CA but the security certificate secret holds no CA certificate", input.GeneratedSecret.SecretName)
+		}
+		data[operations.FluxCAFileKey] = input.CACertificate
+		data[operations.FluxCACrtKey] = input.CACertificate
+	}
+	return corev1.SecretTypeOpaque, data, nil
+}
+
+// secretTypeLabels returns the labels consumers discover the secrets of the secret type by
+func secretTypeLabels(gSecret jfrogv1alpha1.GeneratedSecret) map[string]string {
+	if gSecret.SecretType == operations.SecretTypeArgocd {
+		return map[string]string{operations.ArgocdSecretTypeLabel: argocdDetails(gSecret).CredentialType}
+	}
+	return nil
+}
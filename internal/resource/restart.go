@@ -0,0 +1,339 @@
+package resource
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kinds of the workloads restarted after their secrets were rotated
+const (
+	workloadKindDeployment  = "Deployment"
+	workloadKindStatefulSet = "StatefulSet"
+	workloadKindDaemonSet   = "DaemonSet"
+)
+
+// workloadRestart requests to restart a workload with the data of the secrets rotated in its namespace,
+// SecretNames is comma separated to keep the queue item comparable
+type workloadRestart struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	SecretNames string
+}
+
+// WorkloadRestarter restarts the workloads consuming rotated secrets, at most MaxRestartsPerMinute per SecretRotator.
+// Restarts are queued once per workload after a rotation wrote its secrets and run in the background, so a rotation
+// across many namespaces rolls the workloads gradually.
+type WorkloadRestarter struct {
+	client client.Client
+	// reader reads the rotated secrets from the API server, the cache may not hold the written data yet
+	reader client.Reader
+	log    logr.Logger
+	queue  workqueue.TypedDelayingInterface[workloadRestart]
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewWorkloadRestarter creates a WorkloadRestarter, it restarts workloads once started by the manager
+func NewWorkloadRestarter(k8sClient client.Client, reader client.Reader, log logr.Logger) *WorkloadRestarter {
+	return &WorkloadRestarter{
+		client:   k8sClient,
+		reader:   reader,
+		log:      log,
+		queue:    workqueue.NewTypedDelayingQueue[workloadRestart](),
+		limiters: map[string]*rate.Limiter{},
+	}
+}
+
+// Start restarts the queued workloads until the context is done
+func (w *WorkloadRestarter) Start(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		w.queue.ShutDown()
+	}()
+	for w.processNext(ctx) {
+	}
+	return nil
+}
+
+func (w *WorkloadRestarter) processNext(ctx context.Context) bool {
+	restart, shutdown := w.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.queue.Done(restart)
+	if err := w.restart(ctx, restart); err != nil {
+		w.log.Error(err, "Unable to restart workload", "kind", restart.Kind, "namespace", restart.Namespace, "name", restart.Name, "secrets", restart.SecretNames)
+	}
+	return true
+}
+
+// RestartConsumers queues a single restart of every workload in the namespace consuming some of the rotated secrets or selected by the restart policy
+func (w *WorkloadRestarter) RestartConsumers(ctx context.Context, secretRotator *jfrogv1alpha1.SecretRotator, namespace string, secretNames []string) error {
+	restartPolicy := secretRotator.Spec.RestartPolicy
+	if !restartPolicy.Enabled || len(secretNames) == 0 {
+		return nil
+	}
+	selector := labels.Nothing()
+	if restartPolicy.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(restartPolicy.Selector); err != nil {
+			return fmt.Errorf("invalid restartPolicy selector: %w", err)
+		}
+	}
+
+	// restart the workloads listed even if listing another kind failed
+	workloads, err := listWorkloads(ctx, w.client, namespace)
+	limiter := w.limiter(secretRotator)
+	for _, workload := range workloads {
+		consumed := secretNames
+		if !selector.Matches(labels.Set(workload.GetLabels())) {
+			consumed = slices.DeleteFunc(slices.Clone(secretNames), func(secretName string) bool {
+				return !ReferencesSecret(&podTemplate(workload).Spec, secretName)
+			})
+		}
+		if len(consumed) == 0 {
+			continue
+		}
+		restart := workloadRestart{Kind: workloadKind(workload), Namespace: namespace, Name: workload.GetName(), SecretNames: strings.Join(consumed, ",")}
+		w.queue.AddAfter(restart, limiter.Reserve().Delay())
+	}
+	return err
+}
+
+// Forget drops the restart rate limiter of a deleted SecretRotator
+func (w *WorkloadRestarter) Forget(secretRotator *jfrogv1alpha1.SecretRotator) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.limiters, limiterKey(secretRotator))
+}
+
+// limiter returns the restart rate limiter of the SecretRotator, updated to its current MaxRestartsPerMinute
+func (w *WorkloadRestarter) limiter(secretRotator *jfrogv1alpha1.SecretRotator) *rate.Limiter {
+	restartsPerMinute := secretRotator.Spec.RestartPolicy.MaxRestartsPerMinute
+	if restartsPerMinute <= 0 {
+		restartsPerMinute = operations.DefaultMaxRestartsPerMinute
+	}
+	limit := rate.Every(time.Minute / time.Duration(restartsPerMinute))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := limiterKey(secretRotator)
+	limiter, ok := w.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(limit, 1)
+		w.limiters[key] = limiter
+	} else if limiter.Limit() != limit {
+		limiter.SetLimit(limit)
+	}
+	return limiter
+}
+
+// limiterKey returns the rate limiter key of the SecretRotator
+func limiterKey(secretRotator *jfrogv1alpha1.SecretRotator) string {
+	return types.NamespacedName{Namespace: secretRotator.Namespace, Name: secretRotator.Name}.String()
+}
+
+// restart updates the hashes of the rotated secrets in the pod template annotations of the workload with a single patch,
+// unless it already holds them
+func (w *WorkloadRestarter) restart(ctx context.Context, restart workloadRestart) error {
+	hashes := map[string]string{}
+	for _, secretName := range strings.Split(restart.SecretNames, ",") {
+		secret := &corev1.Secret{}
+		if err := w.reader.Get(ctx, types.NamespacedName{Namespace: restart.Namespace, Name: secretName}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to get secret %s: %w", secretName, err)
+		}
+		hashes[secretName] = SecretDataHash(secret.Data)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	workload := newWorkload(restart.Kind)
+	if err := w.client.Get(ctx, types.NamespacedName{Namespace: restart.Namespace, Name: restart.Name}, workload); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get %s %s: %w", restart.Kind, restart.Name, err)
+	}
+
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	restarted, err := stampSecretHashes(podTemplate(workload), hashes)
+	if err != nil || !restarted {
+		return err
+	}
+	if err := w.client.Patch(ctx, workload, patch); err != nil {
+		return fmt.Errorf("failed to patch the pod template of %s %s: %w", restart.Kind, restart.Name, err)
+	}
+	w.log.Info("Restarted workload after its secret was rotated", "kind", restart.Kind, "namespace", restart.Namespace, "name", restart.Name, "secrets", restart.SecretNames)
+	return nil
+}
+
+// stampSecretHashes records the hashes of the secret data by secret name in the secret hashes annotation of the pod template.
+// It reports whether a hash changed, which rolls the pods of the workload.
+func stampSecretHashes(template *corev1.PodTemplateSpec, secretHashes map[string]string) (bool, error) {
+	hashes := map[string]string{}
+	if value := template.Annotations[operations.SecretHashesAnnotation]; value != "" {
+		if err := json.Unmarshal([]byte(value), &hashes); err != nil {
+			return false, fmt.Errorf("failed to parse the %s annotation: %w", operations.SecretHashesAnnotation, err)
+		}
+	}
+	changed := false
+	for secretName, hash := range secretHashes {
+		if hashes[secretName] != hash {
+			hashes[secretName] = hash
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	value, err := json.Marshal(hashes)
+	if err != nil {
+		return false, err
+	}
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[operations.SecretHashesAnnotation] = string(value)
+	return true, nil
+}
+
+// SecretDataHash returns the sha256 hash of the secret data
+func SecretDataHash(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%d:%s%d:", len(key), key, len(data[key]))
+		hash.Write(data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ReferencesSecret checks if the pod spec reads the secret through env, envFrom, volumes or imagePullSecrets
+func ReferencesSecret(podSpec *corev1.PodSpec, secretName string) bool {
+	for _, reference := range podSpec.ImagePullSecrets {
+		if reference.Name == secretName {
+			return true
+		}
+	}
+	for _, volume := range podSpec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == secretName {
+			return true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil && source.Secret.Name == secretName {
+					return true
+				}
+			}
+		}
+	}
+	containers := append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == secretName {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == secretName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listWorkloads lists the Deployments, StatefulSets and DaemonSets in the namespace
+func listWorkloads(ctx context.Context, k8sClient client.Client, namespace string) ([]client.Object, error) {
+	var errs []error
+	workloads := []client.Object{}
+	deployments := &appsv1.DeploymentList{}
+	if err := k8sClient.List(ctx, deployments, client.InNamespace(namespace)); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list deployments in namespace %s: %w", namespace, err))
+	}
+	for i := range deployments.Items {
+		workloads = append(workloads, &deployments.Items[i])
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := k8sClient.List(ctx, statefulSets, client.InNamespace(namespace)); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list statefulsets in namespace %s: %w", namespace, err))
+	}
+	for i := range statefulSets.Items {
+		workloads = append(workloads, &statefulSets.Items[i])
+	}
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := k8sClient.List(ctx, daemonSets, client.InNamespace(namespace)); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list daemonsets in namespace %s: %w", namespace, err))
+	}
+	for i := range daemonSets.Items {
+		workloads = append(workloads, &daemonSets.Items[i])
+	}
+	return workloads, errors.Join(errs...)
+}
+
+// newWorkload returns an empty workload of the kind
+func newWorkload(kind string) client.Object {
+	switch kind {
+	case workloadKindStatefulSet:
+		return &appsv1.StatefulSet{}
+	case workloadKindDaemonSet:
+		return &appsv1.DaemonSet{}
+	}
+	return &appsv1.Deployment{}
+}
+
+// workloadKind returns the kind of the workload
+func workloadKind(workload client.Object) string {
+	switch workload.(type) {
+	case *appsv1.StatefulSet:
+		return workloadKindStatefulSet
+	case *appsv1.DaemonSet:
+		return workloadKindDaemonSet
+	}
+	return workloadKindDeployment
+}
+
+// podTemplate returns the pod template of the workload
+func podTemplate(workload client.Object) *corev1.PodTemplateSpec {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		return &w.Spec.Template
+	case *appsv1.DaemonSet:
+		return &w.Spec.Template
+	case *appsv1.Deployment:
+		return &w.Spec.Template
+	}
+	return &corev1.PodTemplateSpec{}
+}
@@ -0,0 +1,102 @@
+package resource
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReferencesSecret_Success(t *testing.T) {
+	podSpec := &corev1.PodSpec{
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull"}},
+		Volumes: []corev1.Volume{
+			{Name: "npmrc", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "npmrc"}}},
+			{Name: "projected", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+				{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}},
+			}}}},
+		},
+		InitContainers: []corev1.Container{{EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}}}}}},
+		Containers: []corev1.Container{{Env: []corev1.EnvVar{{Name: "TOKEN", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "token"}, Key: "token"},
+		}}}}},
+	}
+
+	for _, secretName := range []string{"pull", "npmrc", "settings", "creds", "token"} {
+		assert.True(t, ReferencesSecret(podSpec, secretName), secretName)
+	}
+	assert.False(t, ReferencesSecret(podSpec, "other"))
+}
+
+func TestSecretDataHash_Success(t *testing.T) {
+	hash := SecretDataHash(map[string][]byte{"user": []byte("operator"), "token": []byte("one")})
+	assert.Equal(t, hash, SecretDataHash(map[string][]byte{"token": []byte("one"), "user": []byte("operator")}))
+	assert.NotEqual(t, hash, SecretDataHash(map[string][]byte{"user": []byte("operator"), "token": []byte("two")}))
+	assert.NotEqual(t, SecretDataHash(map[string][]byte{"ab": []byte("c")}), SecretDataHash(map[string][]byte{"a": []byte("bc")}))
+}
+
+func TestRestartConsumers_Success(t *testing.T) {
+	consumer := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: "team-a"}}
+	consumer.Spec.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "pull"}}
+	consumer.Spec.Template.Spec.Containers = []corev1.Container{{Name: "builder", EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "npmrc"}}}}}}
+	labelled := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "maven", Namespace: "team-a", Labels: map[string]string{"reads-credentials": "on-start"}}}
+	unrelated := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "team-a"}}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		consumer, labelled, unrelated,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "pull", Namespace: "team-a"}, Data: map[string][]byte{operations.DockerSecretJSON: []byte("{}")}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "npmrc", Namespace: "team-a"}, Data: map[string][]byte{".npmrc": []byte("registry")}},
+	).Build()
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{RestartPolicy: jfrogv1alpha1.RestartPolicyDetails{
+			Enabled:              true,
+			Selector:             &metav1.LabelSelector{MatchLabels: map[string]string{"reads-credentials": "on-start"}},
+			MaxRestartsPerMinute: 6000,
+		}},
+	}
+	restarter := NewWorkloadRestarter(k8sClient, k8sClient, logr.Discard())
+
+	// a workload consuming several rotated secrets is restarted once
+	require.NoError(t, restarter.RestartConsumers(context.Background(), secretRotator, "team-a", []string{"pull", "npmrc"}))
+	assert.True(t, restarter.processNext(context.Background()))
+	assert.True(t, restarter.processNext(context.Background()))
+	assert.Equal(t, 0, restarter.queue.Len())
+
+	hashes := map[string]string{
+		"pull":  SecretDataHash(map[string][]byte{operations.DockerSecretJSON: []byte("{}")}),
+		"npmrc": SecretDataHash(map[string][]byte{".npmrc": []byte("registry")}),
+	}
+	for _, workload := range []client.Object{consumer, labelled} {
+		require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(workload), workload))
+		stamped := map[string]string{}
+		require.NoError(t, json.Unmarshal([]byte(podTemplate(workload).Annotations[operations.SecretHashesAnnotation]), &stamped))
+		assert.Equal(t, hashes, stamped, workload.GetName())
+		assert.Equal(t, "1000", workload.GetResourceVersion(), "%s is patched once", workload.GetName())
+	}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKeyFromObject(unrelated), unrelated))
+	assert.Empty(t, unrelated.Spec.Template.Annotations)
+
+	// the workloads holding the hash of the secret data are not restarted again
+	restarted, err := stampSecretHashes(&consumer.Spec.Template, hashes)
+	require.NoError(t, err)
+	assert.False(t, restarted)
+
+	// nothing is restarted without the restart policy
+	secretRotator.Spec.RestartPolicy.Enabled = false
+	require.NoError(t, restarter.RestartConsumers(context.Background(), secretRotator, "team-a", []string{"pull"}))
+	assert.Equal(t, 0, restarter.queue.Len())
+
+	// the rate limiter is dropped with the SecretRotator
+	restarter.Forget(secretRotator)
+	assert.Empty(t, restarter.limiters)
+}
@@ -0,0 +1,41 @@
+package resource
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	controller "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// RestoreSecret re-renders a deleted or tampered secret of the SecretRotator in the namespace from the cached tokens,
+// without issuing a new token. It reports false when no valid token is cached, the SecretRotator then has to be reconciled.
+func RestoreSecret(ctx context.Context, secretRotator *jfrogv1alpha1.SecretRotator, namespace corev1.Namespace, gSecret jfrogv1alpha1.GeneratedSecret, tokenCache *operations.TokenCache, k8sClient client.Client, scheme *runtime.Scheme) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	existingSecret, err := GetSecret(ctx, namespace.Name, gSecret.SecretName, k8sClient)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return true, fmt.Errorf("failed to get %s secret %s: %w", gSecret.SecretType, gSecret.SecretName, err)
+	}
+	// a secret taken over by someone else is reported by the reconciliation of the SecretRotator
+	if err == nil && !IsSecretOwnedBy(existingSecret, secretRotator.Name) && !operations.IsMergedDockerSecret(gSecret) {
+		return true, nil
+	}
+
+	tokenDetails := &operations.TokenDetails{GeneratedSecrets: []jfrogv1alpha1.GeneratedSecret{gSecret}}
+	if !tokenCache.Load(secretRotator, tokenDetails, time.Now(), operations.RestoreMinTokenValidity) {
+		return false, nil
+	}
+	if apierrors.IsNotFound(err) {
+		logger.Info("Restoring deleted secret", "namespace", namespace.Name, "secret", gSecret.SecretName, "secretType", gSecret.SecretType)
+	}
+	err, _ = CreateOrUpdateSecrets(controller.Request{}, ctx, tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	return true, err
+}
@@ -0,0 +1,87 @@
+package resource
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRestoreSecret_Success(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"},
+		Spec:       jfrogv1alpha1.SecretRotatorSpec{ArtifactoryUrl: "acme.jfrog.io"},
+	}
+	tokenDetails := newIssuedTokenDetails(metav1.Now())
+	tokenDetails.ArtifactoryUrl = "acme.jfrog.io"
+	tokenCache := operations.NewTokenCache()
+	tokenCache.Store(secretRotator, tokenDetails)
+
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: operations.SecretTypeDocker}
+
+	// a deleted secret is restored from the cached token
+	restored, err := RestoreSecret(context.Background(), secretRotator, namespace, gSecret, tokenCache, k8sClient, scheme)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	secret, err := GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	require.NoError(t, err)
+	assert.True(t, IsSecretOwnedBy(secret, "test-rotator"))
+	assert.Equal(t, "default-id", secret.Annotations[operations.TokenIDAnnotation])
+	desired := secret.DeepCopy()
+
+	// a tampered secret is restored
+	secret.Data[operations.DockerSecretJSON] = []byte(`{"auths": {}}`)
+	require.NoError(t, k8sClient.Update(context.Background(), secret))
+	restored, err = RestoreSecret(context.Background(), secretRotator, namespace, gSecret, tokenCache, k8sClient, scheme)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	secret, err = GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, desired.Data, secret.Data)
+
+	// an up to date secret is not written again
+	restored, err = RestoreSecret(context.Background(), secretRotator, namespace, gSecret, tokenCache, k8sClient, scheme)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	unchanged, err := GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, secret.ResourceVersion, unchanged.ResourceVersion)
+}
+
+func TestRestoreSecret_NoCachedToken(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"}}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: operations.SecretTypeDocker}
+
+	restored, err := RestoreSecret(context.Background(), secretRotator, namespace, gSecret, operations.NewTokenCache(), k8sClient, scheme)
+	require.NoError(t, err)
+	assert.False(t, restored)
+	_, err = GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestRestoreSecret_NotOwned(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"}}
+	tokenCache := operations.NewTokenCache()
+	tokenCache.Store(secretRotator, newIssuedTokenDetails(metav1.Now()))
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(newManagedSecret("pull", "team-a", "other-rotator")).Build()
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: operations.SecretTypeDocker}
+
+	restored, err := RestoreSecret(context.Background(), secretRotator, namespace, gSecret, tokenCache, k8sClient, scheme)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	secret, err := GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	require.NoError(t, err)
+	assert.Empty(t, secret.Data)
+}
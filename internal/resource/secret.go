@@ -5,13 +5,15 @@ import (
 	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
 	"artifactory-secrets-rotator/internal/operations"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -28,6 +30,12 @@ func IsSecretOwnedBy(secret *v1.Secret, secretOperatorName string) bool {
 	return owner != nil && owner.APIVersion == jfrogv1alpha1.GroupVersion.String() && owner.Kind == jfrogv1alpha1.SecretKind && owner.Name == secretOperatorName
 }
 
+// IsSecretOwnedByCredential checks if the secret is controlled by the ArtifactoryCredential with the name
+func IsSecretOwnedByCredential(secret *v1.Secret, credentialName string) bool {
+	owner := metav1.GetControllerOf(secret)
+	return owner != nil && owner.APIVersion == jfrogv1alpha1.GroupVersion.String() && owner.Kind == jfrogv1alpha1.ArtifactoryCredentialKind && owner.Name == credentialName
+}
+
 // GetSecret retrieves the specified secret from the given namespace.
 func GetSecret(ctx context.Context, namespace, secretName string, k8sClient client.Client) (*v1.Secret, error) {
 	secret := &v1.Secret{}
@@ -76,6 +84,20 @@ func getRemovedNamespaces(currentNSs v1.NamespaceList, provisionedNSs []string)
 
 // DeleteSecret deletes a specific secret if it is owned by the SecretRotator.
 func DeleteSecret(ctx context.Context, secretName, secretRotatorName, namespace, secretType string, k8sClient client.Client) error {
+	return deleteSecret(ctx, secretName, secretRotatorName, namespace, secretType, k8sClient, func(secret *v1.Secret) bool {
+		return IsSecretOwnedBy(secret, secretRotatorName)
+	})
+}
+
+// DeleteCredentialSecret deletes a secret written for the ArtifactoryCredential, secrets it does not own are left untouched
+func DeleteCredentialSecret(ctx context.Context, credential *jfrogv1alpha1.ArtifactoryCredential, secretRotatorName, secretName string, k8sClient client.Client) error {
+	return deleteSecret(ctx, secretName, secretRotatorName, credential.Namespace, jfrogv1alpha1.ArtifactoryCredentialKind, k8sClient, func(secret *v1.Secret) bool {
+		return IsSecretOwnedByCredential(secret, credential.Name)
+	})
+}
+
+// deleteSecret deletes the secret if it is owned, merged docker secrets only lose the auths entries of the SecretRotator
+func deleteSecret(ctx context.Context, secretName, secretRotatorName, namespace, secretType string, k8sClient client.Client, owned func(secret *v1.Secret) bool) error {
 	existingSecret, err := GetSecret(ctx, namespace, secretName, k8sClient)
 	if err != nil {
 		// If the secret is not found, no action is needed
@@ -85,8 +107,21 @@ func DeleteSecret(ctx context.Context, secretName, secretRotatorName, namespace,
 		return fmt.Errorf("failed to get %s secret %s: %w", secretType, secretName, err)
 	}
 
-	// Skip deletion if the secret is not owned by the SecretRotator
-	if !IsSecretOwnedBy(existingSecret, secretRotatorName) {
+	// Remove only the auths entries the SecretRotator merged into a shared docker config,
+	// the secret is deleted once no entries remain and the SecretRotator owns it
+	merged, remainingAuths, err := operations.UnmergeDockerConfig(existingSecret, secretRotatorName)
+	if err != nil {
+		return fmt.Errorf("failed to unmerge %s secret %s in namespace %s: %w", secretType, secretName, namespace, err)
+	}
+	if merged && (remainingAuths > 0 || !owned(existingSecret)) {
+		if err := k8sClient.Update(ctx, existingSecret, &client.UpdateOptions{}); err != nil {
+			return fmt.Errorf("%s secret %s in namespace %s could not be unmerged: %w", secretType, secretName, namespace, err)
+		}
+		return nil
+	}
+
+	// Skip deletion if the secret is not owned by the SecretRotator or ArtifactoryCredential
+	if !owned(existingSecret) {
 		return nil
 	}
 
@@ -94,19 +129,124 @@ func DeleteSecret(ctx context.Context, secretName, secretRotatorName, namespace,
 	if err != nil {
 		return fmt.Errorf("%s secret %s in namespace %s could not be deleted: %w", secretType, secretName, namespace, err)
 	}
+
+	// Remove the references to the deleted secret the operator added to ServiceAccounts
+	if err := operations.DetachImagePullSecret(ctx, k8sClient, namespace, secretName); err != nil {
+		return fmt.Errorf("%s secret %s in namespace %s could not be detached from its service accounts: %w", secretType, secretName, namespace, err)
+	}
 	return nil
 }
 
+// DeleteManagedSecrets deletes every secret listed in the status that is owned by the SecretRotator.
+func DeleteManagedSecrets(ctx context.Context, secretRotator *jfrogv1alpha1.SecretRotator, k8sClient client.Client) []error {
+	logger := log.FromContext(ctx)
+	errs := []error{}
+	for _, namespace := range managedNamespaces(secretRotator) {
+		for _, secretName := range secretRotator.Status.SecretManagedByNamespaces[namespace] {
+			if err := DeleteSecret(ctx, secretName, secretRotator.Name, namespace, managedSecretType(secretRotator, secretName), k8sClient); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			logger.Info("Deleted managed secret", "secret", secretName, "namespace", namespace)
+		}
+	}
+	return errs
+}
+
+// OrphanManagedSecrets removes the SecretRotator owner reference from every secret listed in the status, so the secrets outlive the SecretRotator.
+func OrphanManagedSecrets(ctx context.Context, secretRotator *jfrogv1alpha1.SecretRotator, k8sClient client.Client) []error {
+	logger := log.FromContext(ctx)
+	errs := []error{}
+	for _, namespace := range managedNamespaces(secretRotator) {
+		for _, secretName := range secretRotator.Status.SecretManagedByNamespaces[namespace] {
+			secretType := managedSecretType(secretRotator, secretName)
+			existingSecret, err := GetSecret(ctx, namespace, secretName, k8sClient)
+			if err != nil {
+				if !apierrors.IsNotFound(err) {
+					errs = append(errs, fmt.Errorf("failed to get %s secret %s in namespace %s: %w", secretType, secretName, namespace, err))
+				}
+				continue
+			}
+			if !IsSecretOwnedBy(existingSecret, secretRotator.Name) {
+				continue
+			}
+
+			ownerReferences := []metav1.OwnerReference{}
+			for _, ownerReference := range existingSecret.OwnerReferences {
+				if ownerReference.APIVersion == jfrogv1alpha1.GroupVersion.String() && ownerReference.Kind == jfrogv1alpha1.SecretKind && ownerReference.Name == secretRotator.Name {
+					continue
+				}
+				ownerReferences = append(ownerReferences, ownerReference)
+			}
+			existingSecret.OwnerReferences = ownerReferences
+			if err := k8sClient.Update(ctx, existingSecret, &client.UpdateOptions{}); err != nil {
+				errs = append(errs, fmt.Errorf("%s secret %s in namespace %s could not be orphaned: %w", secretType, secretName, namespace, err))
+				continue
+			}
+			logger.Info("Orphaned managed secret", "secret", secretName, "namespace", namespace)
+		}
+	}
+	return errs
+}
+
+// managedNamespaces returns the namespaces listed in the status, sorted
+func managedNamespaces(secretRotator *jfrogv1alpha1.SecretRotator) []string {
+	namespaces := make([]string, 0, len(secretRotator.Status.SecretManagedByNamespaces))
+	for namespace := range secretRotator.Status.SecretManagedByNamespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// managedSecretType returns the type of a generated secret listed in the status
+func managedSecretType(secretRotator *jfrogv1alpha1.SecretRotator, secretName string) string {
+	for _, gSecret := range secretRotator.Status.GeneratedSecrets {
+		if gSecret.SecretName == secretName {
+			return gSecret.SecretType
+		}
+	}
+	return "managed"
+}
+
 // CreateOrUpdateSecrets creates or updates secrets in Kubernetes based on the specified secret type.
-func CreateOrUpdateSecrets(req controller.Request, ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, namespace corev1.Namespace, k8sClient client.Client, scheme *runtime.Scheme, secretName, secretType string) (error, bool) {
+func CreateOrUpdateSecrets(req controller.Request, ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, namespace corev1.Namespace, k8sClient client.Client, scheme *runtime.Scheme, gSecret jfrogv1alpha1.GeneratedSecret) (error, bool) {
+	return createOrUpdateSecret(req, ctx, tokenDetails, secretRotator, secretRotator, namespace, k8sClient, scheme, gSecret)
+}
+
+// CreateOrUpdateCredentialSecret creates or updates a secret requested by the ArtifactoryCredential in its namespace, owned by the ArtifactoryCredential
+// and holding the tokens issued for the ArtifactoryCredential.
+// An existing secret not owned by the ArtifactoryCredential is not overwritten, unless it is a merged docker secret.
+func CreateOrUpdateCredentialSecret(ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, credential *jfrogv1alpha1.ArtifactoryCredential, k8sClient client.Client, scheme *runtime.Scheme, gSecret jfrogv1alpha1.GeneratedSecret) error {
+	existingSecret, err := GetSecret(ctx, credential.Namespace, gSecret.SecretName, k8sClient)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get %s secret %s: %w", gSecret.SecretType, gSecret.SecretName, err)
+	}
+	if err == nil && !IsSecretOwnedByCredential(existingSecret, credential.Name) && !operations.IsMergedDockerSecret(gSecret) {
+		return fmt.Errorf("secret %s is not owned by this ArtifactoryCredential", gSecret.SecretName)
+	}
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: credential.Namespace}}
+	err, _ = createOrUpdateSecret(controller.Request{}, ctx, tokenDetails.ForCredential(credential), secretRotator, credential, namespace, k8sClient, scheme, gSecret)
+	return err
+}
+
+// createOrUpdateSecret renders the secret from the tokens issued for the SecretRotator, new secrets are controlled by the owner
+func createOrUpdateSecret(req controller.Request, ctx context.Context, tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, owner client.Object, namespace corev1.Namespace, k8sClient client.Client, scheme *runtime.Scheme, gSecret jfrogv1alpha1.GeneratedSecret) (error, bool) {
 	logger := log.FromContext(ctx)
+	secretName, secretType := gSecret.SecretName, gSecret.SecretType
+
+	// Use the token issued for the scope requested by this secret
+	accessToken := tokenDetails.TokenForScope(gSecret.Scope)
+	if accessToken == nil {
+		return fmt.Errorf("no artifactory token was issued for scope '%s' of %s secret %s", gSecret.Scope, secretType, secretName), false
+	}
 
 	// Common function to set up secret metadata
 	setSecretMetadata := func(secret *corev1.Secret) error {
 		secret.Namespace = namespace.Name
 		secret.Labels = secretRotator.Spec.SecretMetadata.Labels
 		secret.Annotations = secretRotator.Spec.SecretMetadata.Annotations
-		return controllerutil.SetControllerReference(secretRotator, secret, scheme)
+		return controllerutil.SetControllerReference(owner, secret, scheme)
 	}
 	secretObj := &v1.Secret{}
 	secretObj.Name = secretName
@@ -128,29 +268,59 @@ func CreateOrUpdateSecrets(req controller.Request, ctx context.Context, tokenDet
 			return fmt.Errorf("failed to get %s secret %s: %w", secretType, secretName, err), false
 		}
 	}
+	existing := secretObj.DeepCopy()
 
-	// Configure secret based on type
-	if secretType == operations.SecretTypeDocker {
-		// Create base64-encoded auth string for Docker config
-		auth := fmt.Sprintf("%s:%s", tokenDetails.Username, tokenDetails.Token)
-		tokenb64 := base64.StdEncoding.EncodeToString([]byte(auth))
-
-		// generateDockerConfigJSON creates a valid dockerconfig.json structure
-		// with the provided token and returns it as a byte slice
-		dockerConfigBytes, err := generateDockerConfigJSON(tokenb64, secretRotator)
-		if err != nil {
-			return err, false
+	// Flux secrets can bundle the CA certificate Artifactory is served with
+	var caCertificate []byte
+	if flux := fluxDetails(gSecret); flux != nil && flux.IncludeCA {
+		if caCertificate, err = loadCACertificate(ctx, secretRotator, k8sClient); err != nil {
+			return fmt.Errorf("failed to bundle the CA certificate in %s secret %s: %w", secretType, secretName, err), false
 		}
-		secretObj.Data = map[string][]byte{
-			operations.DockerSecretJSON: dockerConfigBytes,
+	}
+
+	// Render the secret in the format of its type
+	renderedType, data, err := renderSecret(&renderInput{
+		TokenDetails:    tokenDetails,
+		SecretRotator:   secretRotator,
+		GeneratedSecret: gSecret,
+		AccessToken:     accessToken,
+		Namespace:       namespace.Name,
+		CACertificate:   caCertificate,
+	})
+	if err != nil {
+		return err, false
+	}
+
+	// Merged docker secrets only own the auths entries of their registries in a shared docker config
+	managedAuths := ""
+	if operations.IsMergedDockerSecret(gSecret) {
+		if secretObj.ResourceVersion != "" && secretObj.Type != renderedType {
+			return fmt.Errorf("%s secret %s can't be merged into the existing secret of type %s", secretType, secretName, secretObj.Type), false
 		}
-		secretObj.Type = corev1.SecretTypeDockerConfigJson
-	} else if secretType == operations.SecretTypeGeneric {
-		secretObj.Data = map[string][]byte{
-			operations.GenericSecretUser:  []byte(tokenDetails.Username),
-			operations.GenericSecretToken: []byte(tokenDetails.Token),
+		data[operations.DockerSecretJSON], managedAuths, err = operations.MergeDockerConfig(secretObj.Data[operations.DockerSecretJSON], data[operations.DockerSecretJSON], secretObj.Annotations[operations.ManagedAuthsAnnotation], secretRotator.Name)
+		if err != nil {
+			return fmt.Errorf("failed to merge %s secret %s: %w", secretType, secretName, err), false
 		}
-		secretObj.Type = corev1.SecretTypeOpaque
+	}
+	secretObj.Data = data
+	secretObj.Type = renderedType
+
+	// merged docker secrets hold the tokens of several writers, token annotations would only describe the last one
+	if operations.IsMergedDockerSecret(gSecret) {
+		clearTokenAnnotations(secretObj)
+	} else {
+		stampTokenAnnotations(secretObj, tokenDetails, accessToken, gSecret.Scope)
+	}
+	stampSecretTypeLabels(secretObj, secretTypeLabels(gSecret))
+	if managedAuths != "" {
+		secretObj.Annotations[operations.ManagedAuthsAnnotation] = managedAuths
+	}
+
+	// Skip the write and its hooks when the secret already holds the rendered state, restoring drifted secrets does not loop
+	if secretObj.ResourceVersion != "" && equality.Semantic.DeepEqual(existing, secretObj) {
+		logger.V(1).Info("Secret is up to date", "namespace", namespace.Name, "secret", secretName, "secretType", secretType)
+		syncImagePullSecret(ctx, k8sClient, namespace.Name, gSecret)
+		return nil, false
 	}
 
 	// Update or create secret
@@ -168,9 +338,126 @@ func CreateOrUpdateSecrets(req controller.Request, ctx context.Context, tokenDet
 
 	logger.Info("Successfully created/updated secret", "namespace", namespace.Name, "secret", secretName, "secretType", secretType)
 
+	syncImagePullSecret(ctx, k8sClient, namespace.Name, gSecret)
+
+	// Let the Flux sources referencing the secret pick up the rotated token right away
+	if flux := fluxDetails(gSecret); flux != nil && flux.ReconcileSources {
+		if err := requestFluxReconcile(ctx, k8sClient, namespace.Name, secretName, time.Now()); err != nil {
+			logger.Error(err, "Unable to request the reconciliation of the Flux sources", "namespace", namespace.Name, "secret", secretName)
+		}
+	}
+
 	return nil, false
 }
 
+// syncImagePullSecret lets the selected ServiceAccounts pull images with the secret and removes the references the operator
+// added to the ones no longer selected. It runs on every rotation, also when the secret was up to date, so ServiceAccounts
+// created or relabelled since the last write are covered.
+func syncImagePullSecret(ctx context.Context, k8sClient client.Client, namespace string, gSecret jfrogv1alpha1.GeneratedSecret) {
+	logger := log.FromContext(ctx)
+	if serviceAccounts := operations.ImagePullServiceAccounts(gSecret); serviceAccounts != nil {
+		if err := operations.AttachImagePullSecret(ctx, k8sClient, namespace, gSecret.SecretName, serviceAccounts); err != nil {
+			logger.Error(err, "Unable to attach the secret to the imagePullSecrets of the service accounts", "namespace", namespace, "secret", gSecret.SecretName)
+		}
+		return
+	}
+	if err := operations.DetachImagePullSecret(ctx, k8sClient, namespace, gSecret.SecretName); err != nil {
+		logger.Error(err, "Unable to detach the secret from the imagePullSecrets of the service accounts", "namespace", namespace, "secret", gSecret.SecretName)
+	}
+}
+
+// stampTokenAnnotations annotates the secret with the rotation time, expiry, id and scope of the token it holds, so workloads can see its freshness
+func stampTokenAnnotations(secret *corev1.Secret, tokenDetails *operations.TokenDetails, accessToken *operations.AccessResponse, scope string) {
+	// copy the annotations, new secrets share them with the SecretRotator spec
+	annotations := make(map[string]string, len(secret.Annotations)+4)
+	for key, value := range secret.Annotations {
+		annotations[key] = value
+	}
+	annotations[operations.LastRotationTimeAnnotation] = tokenDetails.IssuedAt.UTC().Format(time.RFC3339)
+	delete(annotations, operations.TokenExpiresAtAnnotation)
+	if expiresAt := tokenDetails.TokenExpiresAt(accessToken); expiresAt != nil {
+		annotations[operations.TokenExpiresAtAnnotation] = expiresAt.UTC().Format(time.RFC3339)
+	}
+	delete(annotations, operations.TokenIDAnnotation)
+	if accessToken.TokenId != "" {
+		annotations[operations.TokenIDAnnotation] = accessToken.TokenId
+	}
+	annotations[operations.ScopeAnnotation] = tokenDetails.IssuedScope(scope)
+	secret.Annotations = annotations
+}
+
+// clearTokenAnnotations removes the token annotations from the secret
+func clearTokenAnnotations(secret *corev1.Secret) {
+	// copy the annotations, new secrets share them with the SecretRotator spec
+	annotations := make(map[string]string, len(secret.Annotations)+1)
+	for key, value := range secret.Annotations {
+		annotations[key] = value
+	}
+	for _, key := range operations.TokenAnnotations {
+		delete(annotations, key)
+	}
+	secret.Annotations = annotations
+}
+
+// stampSecretTypeLabels labels the secret with the labels its consumers discover it by
+func stampSecretTypeLabels(secret *corev1.Secret, typeLabels map[string]string) {
+	if len(typeLabels) == 0 {
+		return
+	}
+	// copy the labels, new secrets share them with the SecretRotator spec
+	labels := make(map[string]string, len(secret.Labels)+len(typeLabels))
+	for key, value := range secret.Labels {
+		labels[key] = value
+	}
+	for key, value := range typeLabels {
+		labels[key] = value
+	}
+	secret.Labels = labels
+}
+
+// NewSecretStatus reports the token a generated secret in the namespace holds after its rotation.
+// A failed rotation keeps the previously reported token, which the secret still holds, along with the error.
+func NewSecretStatus(tokenDetails *operations.TokenDetails, secretRotator *jfrogv1alpha1.SecretRotator, namespace string, gSecret jfrogv1alpha1.GeneratedSecret, rotationErr error) jfrogv1alpha1.SecretStatus {
+	return newSecretStatus(tokenDetails, secretRotator.Status.Secrets, namespace, gSecret, rotationErr)
+}
+
+// NewCredentialSecretStatus reports the token a secret requested by the ArtifactoryCredential holds, like NewSecretStatus
+func NewCredentialSecretStatus(tokenDetails *operations.TokenDetails, credential *jfrogv1alpha1.ArtifactoryCredential, gSecret jfrogv1alpha1.GeneratedSecret, rotationErr error) jfrogv1alpha1.SecretStatus {
+	return newSecretStatus(tokenDetails.ForCredential(credential), credential.Status.Secrets, credential.Namespace, gSecret, rotationErr)
+}
+
+// newSecretStatus reports the token the secret holds, a failed rotation keeps the previously reported token.
+// The rotation time only moves when the secret was written with another token.
+func newSecretStatus(tokenDetails *operations.TokenDetails, previousStatuses []jfrogv1alpha1.SecretStatus, namespace string, gSecret jfrogv1alpha1.GeneratedSecret, rotationErr error) jfrogv1alpha1.SecretStatus {
+	var previousStatus *jfrogv1alpha1.SecretStatus
+	for i := range previousStatuses {
+		if previousStatuses[i].Namespace == namespace && previousStatuses[i].SecretName == gSecret.SecretName {
+			previousStatus = &previousStatuses[i]
+			break
+		}
+	}
+	if rotationErr != nil {
+		secretStatus := jfrogv1alpha1.SecretStatus{Namespace: namespace, SecretName: gSecret.SecretName, Scope: gSecret.Scope}
+		if previousStatus != nil {
+			secretStatus = *previousStatus.DeepCopy()
+		}
+		secretStatus.LastError = rotationErr.Error()
+		return secretStatus
+	}
+
+	secretStatus := jfrogv1alpha1.SecretStatus{Namespace: namespace, SecretName: gSecret.SecretName, Scope: tokenDetails.IssuedScope(gSecret.Scope)}
+	if accessToken := tokenDetails.TokenForScope(gSecret.Scope); accessToken != nil {
+		lastRotationTime := tokenDetails.IssuedAt
+		if previousStatus != nil && previousStatus.LastRotationTime != nil && accessToken.TokenId != "" && previousStatus.TokenID == accessToken.TokenId {
+			lastRotationTime = *previousStatus.LastRotationTime
+		}
+		secretStatus.TokenID = accessToken.TokenId
+		secretStatus.LastRotationTime = &lastRotationTime
+		secretStatus.TokenExpiresAt = tokenDetails.TokenExpiresAt(accessToken)
+	}
+	return secretStatus
+}
+
 // HandleCerts copies certificates into the container.
 func HandleCerts(ctx context.Context, namespace, secretName string, secretRotatorName string, k8sClient client.Client) error {
 	logger := log.FromContext(ctx)
@@ -0,0 +1,319 @@
+package resource
+
+import (
+	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
+	"artifactory-secrets-rotator/internal/operations"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	controller "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	_ = jfrogv1alpha1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+}
+
+func newManagedSecret(name, namespace, owner string) *corev1.Secret {
+	controller := true
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "other-owner", UID: "other-uid"},
+				{APIVersion: jfrogv1alpha1.GroupVersion.String(), Kind: jfrogv1alpha1.SecretKind, Name: owner, UID: "rotator-uid", Controller: &controller},
+			},
+		},
+	}
+}
+
+func newManagedSecretRotator() *jfrogv1alpha1.SecretRotator {
+	return &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator"},
+		Status: jfrogv1alpha1.SecretRotatorStatus{
+			SecretManagedByNamespaces: map[string][]string{
+				"team-a": {"docker-secret", "foreign-secret"},
+				"team-b": {"docker-secret", "missing-secret"},
+			},
+		},
+	}
+}
+
+func TestDeleteManagedSecrets_Success(t *testing.T) {
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newManagedSecret("docker-secret", "team-a", "test-rotator"),
+		newManagedSecret("foreign-secret", "team-a", "other-rotator"),
+		newManagedSecret("docker-secret", "team-b", "test-rotator"),
+	).Build()
+
+	errs := DeleteManagedSecrets(context.Background(), newManagedSecretRotator(), k8sClient)
+	require.Empty(t, errs)
+
+	for _, namespace := range []string{"team-a", "team-b"} {
+		_, err := GetSecret(context.Background(), namespace, "docker-secret", k8sClient)
+		assert.True(t, apierrors.IsNotFound(err))
+	}
+	_, err := GetSecret(context.Background(), "team-a", "foreign-secret", k8sClient)
+	assert.NoError(t, err)
+}
+
+func TestOrphanManagedSecrets_Success(t *testing.T) {
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newManagedSecret("docker-secret", "team-a", "test-rotator"),
+		newManagedSecret("foreign-secret", "team-a", "other-rotator"),
+		newManagedSecret("docker-secret", "team-b", "test-rotator"),
+	).Build()
+
+	errs := OrphanManagedSecrets(context.Background(), newManagedSecretRotator(), k8sClient)
+	require.Empty(t, errs)
+
+	for _, namespace := range []string{"team-a", "team-b"} {
+		secret, err := GetSecret(context.Background(), namespace, "docker-secret", k8sClient)
+		require.NoError(t, err)
+		assert.False(t, IsSecretOwnedBy(secret, "test-rotator"))
+		require.Len(t, secret.OwnerReferences, 1)
+		assert.Equal(t, "other-owner", secret.OwnerReferences[0].Name)
+	}
+	foreign := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "team-a", Name: "foreign-secret"}, foreign))
+	assert.True(t, IsSecretOwnedBy(foreign, "other-rotator"))
+}
+
+func newIssuedTokenDetails(issuedAt metav1.Time) *operations.TokenDetails {
+	return &operations.TokenDetails{
+		IssuedAt:     issuedAt,
+		TTLInSeconds: 3600,
+		Tokens: map[string]*operations.AccessResponse{
+			"":                                     {TokenId: "default-id", AccessToken: "default-token", Username: "operator", ExpiresIn: 600},
+			"applied-permissions/groups:deployers": {TokenId: "deployers-id", AccessToken: "deployers-token", Username: "operator", Scope: "applied-permissions/groups:deployers"},
+		},
+	}
+}
+
+func TestCreateOrUpdateSecrets_StampsTokenAnnotations(t *testing.T) {
+	issuedAt := metav1.NewTime(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC))
+	tokenDetails := newIssuedTokenDetails(issuedAt)
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			SecretMetadata: jfrogv1alpha1.SecretMetadata{Annotations: map[string]string{"team": "platform"}},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "deploy", SecretType: operations.SecretTypeGeneric, Scope: "applied-permissions/groups:deployers"}
+
+	err, _ := CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	require.NoError(t, err)
+
+	secret, err := GetSecret(context.Background(), "team-a", "deploy", k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, "platform", secret.Annotations["team"])
+	assert.Equal(t, "2025-01-02T03:04:05Z", secret.Annotations[operations.LastRotationTimeAnnotation])
+	assert.Equal(t, "2025-01-02T04:04:05Z", secret.Annotations[operations.TokenExpiresAtAnnotation])
+	assert.Equal(t, "deployers-id", secret.Annotations[operations.TokenIDAnnotation])
+	assert.Equal(t, "applied-permissions/groups:deployers", secret.Annotations[operations.ScopeAnnotation])
+	// the SecretRotator spec annotations are not modified
+	assert.Equal(t, map[string]string{"team": "platform"}, secretRotator.Spec.SecretMetadata.Annotations)
+}
+
+func TestCreateOrUpdateSecrets_ArgocdLabels(t *testing.T) {
+	tokenDetails := newIssuedTokenDetails(metav1.Now())
+	tokenDetails.ArtifactoryUrl = "acme.jfrog.io"
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"},
+		Spec: jfrogv1alpha1.SecretRotatorSpec{
+			SecretMetadata: jfrogv1alpha1.SecretMetadata{Labels: map[string]string{"team": "platform"}},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argocd"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "artifactory-helm", SecretType: operations.SecretTypeArgocd}
+
+	err, _ := CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	require.NoError(t, err)
+
+	secret, err := GetSecret(context.Background(), "argocd", "artifactory-helm", k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "platform", operations.ArgocdSecretTypeLabel: operations.ArgocdRepoCreds}, secret.Labels)
+	assert.Equal(t, "https://acme.jfrog.io/artifactory/api/helm", string(secret.Data["url"]))
+	// the SecretRotator spec labels are not modified
+	assert.Equal(t, map[string]string{"team": "platform"}, secretRotator.Spec.SecretMetadata.Labels)
+}
+
+func TestCreateOrUpdateSecrets_MergesDockerConfig(t *testing.T) {
+	tokenDetails := newIssuedTokenDetails(metav1.Now())
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"},
+		Spec:       jfrogv1alpha1.SecretRotatorSpec{ArtifactoryUrl: "acme.jfrog.io"},
+	}
+	// the shared pull secret is created by another writer
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull", Namespace: "team-a"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{operations.DockerSecretJSON: []byte(`{"auths": {"ghcr.io": {"auth": "Z2g6dG9rZW4="}}}`)},
+	}).Build()
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: operations.SecretTypeDocker, Docker: &jfrogv1alpha1.DockerSecretDetails{Merge: true}}
+
+	err, _ := CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	require.NoError(t, err)
+
+	secret, err := GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"auths": {"ghcr.io": {"auth": "Z2g6dG9rZW4="}, "acme.jfrog.io": {"auth": "b3BlcmF0b3I6ZGVmYXVsdC10b2tlbg=="}}}`, string(secret.Data[operations.DockerSecretJSON]))
+	assert.JSONEq(t, `{"test-rotator": ["acme.jfrog.io"]}`, secret.Annotations[operations.ManagedAuthsAnnotation])
+	assert.False(t, IsSecretOwnedBy(secret, "test-rotator"))
+	// the shared secret holds the tokens of several writers, none of them is reported on it
+	for _, annotation := range operations.TokenAnnotations {
+		assert.NotContains(t, secret.Annotations, annotation)
+	}
+
+	// deleting the SecretRotator's secret only removes its entries from the shared secret
+	require.NoError(t, DeleteSecret(context.Background(), "pull", "test-rotator", "team-a", operations.SecretTypeDocker, k8sClient))
+	secret, err = GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"auths": {"ghcr.io": {"auth": "Z2g6dG9rZW4="}}}`, string(secret.Data[operations.DockerSecretJSON]))
+	assert.NotContains(t, secret.Annotations, operations.ManagedAuthsAnnotation)
+}
+
+func TestCreateOrUpdateSecrets_AttachesImagePullSecret(t *testing.T) {
+	tokenDetails := newIssuedTokenDetails(metav1.Now())
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"},
+		Spec:       jfrogv1alpha1.SecretRotatorSpec{ArtifactoryUrl: "acme.jfrog.io"},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "team-a"}},
+	).Build()
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: operations.SecretTypeDocker,
+		Docker: &jfrogv1alpha1.DockerSecretDetails{ServiceAccounts: &jfrogv1alpha1.ServiceAccountSelector{}}}
+
+	err, _ := CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	require.NoError(t, err)
+	serviceAccount := &corev1.ServiceAccount{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "team-a", Name: "default"}, serviceAccount))
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "pull"}}, serviceAccount.ImagePullSecrets)
+
+	// deleting the secret removes the reference again
+	require.NoError(t, DeleteSecret(context.Background(), "pull", "test-rotator", "team-a", operations.SecretTypeDocker, k8sClient))
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "team-a", Name: "default"}, serviceAccount))
+	assert.Empty(t, serviceAccount.ImagePullSecrets)
+}
+
+func TestCreateOrUpdateSecrets_SyncsImagePullSecretOfUpToDateSecret(t *testing.T) {
+	tokenDetails := newIssuedTokenDetails(metav1.Now())
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"},
+		Spec:       jfrogv1alpha1.SecretRotatorSpec{ArtifactoryUrl: "acme.jfrog.io"},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).Build()
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: operations.SecretTypeDocker,
+		Docker: &jfrogv1alpha1.DockerSecretDetails{ServiceAccounts: &jfrogv1alpha1.ServiceAccountSelector{}}}
+	err, _ := CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	require.NoError(t, err)
+	written, err := GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	require.NoError(t, err)
+
+	// the ServiceAccount is created after the secret was written, the next rotation attaches it without writing the secret
+	require.NoError(t, k8sClient.Create(context.Background(), &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "team-a"}}))
+	err, _ = CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	require.NoError(t, err)
+	secret, err := GetSecret(context.Background(), "team-a", "pull", k8sClient)
+	require.NoError(t, err)
+	assert.Equal(t, written.ResourceVersion, secret.ResourceVersion)
+	serviceAccount := &corev1.ServiceAccount{}
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "team-a", Name: "default"}, serviceAccount))
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "pull"}}, serviceAccount.ImagePullSecrets)
+
+	// removing the ServiceAccounts selection detaches the secret
+	gSecret.Docker.ServiceAccounts = nil
+	err, _ = CreateOrUpdateSecrets(controller.Request{}, context.Background(), tokenDetails, secretRotator, namespace, k8sClient, scheme, gSecret)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(context.Background(), client.ObjectKey{Namespace: "team-a", Name: "default"}, serviceAccount))
+	assert.Empty(t, serviceAccount.ImagePullSecrets)
+}
+
+func TestNewSecretStatus_Success(t *testing.T) {
+	issuedAt := metav1.NewTime(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC))
+	tokenDetails := newIssuedTokenDetails(issuedAt)
+	previousRotation := metav1.NewTime(issuedAt.Add(-time.Hour))
+	secretRotator := &jfrogv1alpha1.SecretRotator{
+		Status: jfrogv1alpha1.SecretRotatorStatus{Secrets: []jfrogv1alpha1.SecretStatus{
+			{Namespace: "team-b", SecretName: "pull", TokenID: "old-id", LastRotationTime: &previousRotation},
+		}},
+	}
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "pull", SecretType: operations.SecretTypeDocker}
+
+	rotated := NewSecretStatus(tokenDetails, secretRotator, "team-a", gSecret, nil)
+	assert.Equal(t, "team-a", rotated.Namespace)
+	assert.Equal(t, "default-id", rotated.TokenID)
+	assert.Equal(t, issuedAt, *rotated.LastRotationTime)
+	assert.Equal(t, issuedAt.Add(600*time.Second), rotated.TokenExpiresAt.Time)
+	assert.Empty(t, rotated.LastError)
+
+	// a failed rotation keeps reporting the token the secret still holds
+	failed := NewSecretStatus(tokenDetails, secretRotator, "team-b", gSecret, errors.New("update conflict"))
+	assert.Equal(t, "old-id", failed.TokenID)
+	assert.Equal(t, previousRotation, *failed.LastRotationTime)
+	assert.Equal(t, "update conflict", failed.LastError)
+
+	// the secret still holding the same token keeps its rotation time
+	secretRotator.Status.Secrets[0].TokenID = "default-id"
+	unchanged := NewSecretStatus(tokenDetails, secretRotator, "team-b", gSecret, nil)
+	assert.Equal(t, "default-id", unchanged.TokenID)
+	assert.Equal(t, previousRotation, *unchanged.LastRotationTime)
+}
+
+func TestCreateOrUpdateCredentialSecret_Success(t *testing.T) {
+	secretRotator := &jfrogv1alpha1.SecretRotator{ObjectMeta: metav1.ObjectMeta{Name: "test-rotator", UID: "rotator-uid"}}
+	credential := &jfrogv1alpha1.ArtifactoryCredential{ObjectMeta: metav1.ObjectMeta{Name: "team-credential", Namespace: "team-a", UID: "credential-uid"}}
+	// the secrets of the ArtifactoryCredential hold its own tokens, not the SecretRotator ones
+	tokenDetails := newIssuedTokenDetails(metav1.Now())
+	tokenDetails.CredentialTokens = map[types.NamespacedName]map[string]*operations.AccessResponse{
+		operations.CredentialKey(credential): {
+			"applied-permissions/groups:deployers": {TokenId: "credential-id", AccessToken: "credential-token", Username: "operator"},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(newManagedSecret("foreign-secret", "team-a", "test-rotator")).Build()
+	gSecret := jfrogv1alpha1.GeneratedSecret{SecretName: "deploy", SecretType: operations.SecretTypeGeneric, Scope: "applied-permissions/groups:deployers"}
+
+	// the secret is written in the namespace of the ArtifactoryCredential and controlled by it
+	require.NoError(t, CreateOrUpdateCredentialSecret(context.Background(), tokenDetails, secretRotator, credential, k8sClient, scheme, gSecret))
+	secret, err := GetSecret(context.Background(), "team-a", "deploy", k8sClient)
+	require.NoError(t, err)
+	assert.True(t, IsSecretOwnedByCredential(secret, "team-credential"))
+	assert.False(t, IsSecretOwnedBy(secret, "test-rotator"))
+	assert.Equal(t, "credential-id", secret.Annotations[operations.TokenIDAnnotation])
+
+	// secrets the ArtifactoryCredential does not own are not overwritten
+	err = CreateOrUpdateCredentialSecret(context.Background(), tokenDetails, secretRotator, credential, k8sClient, scheme,
+		jfrogv1alpha1.GeneratedSecret{SecretName: "foreign-secret", SecretType: operations.SecretTypeGeneric})
+	assert.ErrorContains(t, err, "not owned by this ArtifactoryCredential")
+
+	// only owned secrets are deleted
+	require.NoError(t, DeleteCredentialSecret(context.Background(), credential, "test-rotator", "foreign-secret", k8sClient))
+	_, err = GetSecret(context.Background(), "team-a", "foreign-secret", k8sClient)
+	require.NoError(t, err)
+	require.NoError(t, DeleteCredentialSecret(context.Background(), credential, "test-rotator", "deploy", k8sClient))
+	_, err = GetSecret(context.Background(), "team-a", "deploy", k8sClient)
+	assert.True(t, apierrors.IsNotFound(err))
+}
@@ -19,6 +19,8 @@ package main
 import (
 	jfrogv1alpha1 "artifactory-secrets-rotator/api/v1alpha1"
 	"artifactory-secrets-rotator/controllers"
+	"artifactory-secrets-rotator/internal/operations"
+	"artifactory-secrets-rotator/internal/resource"
 	"flag"
 	"os"
 
@@ -34,6 +36,7 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	//+kubebuilder:scaffold:imports
@@ -93,16 +96,40 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Restarts the workloads consuming rotated secrets in the background, rate limited per SecretRotator
+	restarter := resource.NewWorkloadRestarter(mgr.GetClient(), mgr.GetAPIReader(), mgr.GetLogger().WithName("restarter"))
+	if err := mgr.Add(restarter); err != nil {
+		setupLog.Error(err, "unable to set up workload restarter")
+		os.Exit(1)
+	}
+
+	// The tokens last issued per SecretRotator restore drifted secrets, a full reconciliation is requested when none is cached
+	tokenCache := operations.NewTokenCache()
+	rotationRequests := make(chan event.GenericEvent, 100)
+
 	if err = (&controllers.SecretRotatorReconciler{
-		Log:             mgr.GetLogger(),
-		Client:          mgr.GetClient(),
-		Scheme:          mgr.GetScheme(),
-		Recorder:        mgr.GetEventRecorderFor("SecretRotator-controller"),
-		RequeueInterval: time.Hour,
+		Log:              mgr.GetLogger(),
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		Recorder:         mgr.GetEventRecorderFor("SecretRotator-controller"),
+		RequeueInterval:  time.Hour,
+		Restarter:        restarter,
+		TokenCache:       tokenCache,
+		RotationRequests: rotationRequests,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "SecretRotator")
 		os.Exit(1)
 	}
+	if err = (&controllers.ManagedSecretReconciler{
+		Log:              mgr.GetLogger().WithName("managedsecret"),
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		TokenCache:       tokenCache,
+		RotationRequests: rotationRequests,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ManagedSecret")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
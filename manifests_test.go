@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// manifestDirs hold the plain manifests applied with kubectl, the chart templates are rendered by helm
+var manifestDirs = []string{"config", "charts/jfrog-registry-operator/examples"}
+
+// TestManifests_Parse checks every document of the manifests is a Kubernetes object without duplicate keys,
+// and the role bindings reference roles of the same file
+func TestManifests_Parse(t *testing.T) {
+	for _, dir := range manifestDirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !strings.HasSuffix(path, ".yaml") {
+				return err
+			}
+			t.Run(path, func(t *testing.T) {
+				file, err := os.Open(path)
+				require.NoError(t, err)
+				defer file.Close()
+
+				clusterRoles := map[string]bool{}
+				roleRefs := []string{}
+				reader := utilyaml.NewYAMLReader(bufio.NewReader(file))
+				for {
+					document, err := reader.Read()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					require.NoError(t, err)
+					object := &unstructured.Unstructured{}
+					// a document separator glued to the previous line merges two documents into one with duplicate keys
+					require.NoError(t, yaml.UnmarshalStrict(document, &object.Object))
+					if len(object.Object) == 0 {
+						continue
+					}
+					require.NotEmpty(t, object.GetAPIVersion(), "document without apiVersion")
+					require.NotEmpty(t, object.GetKind(), "document without kind")
+					switch object.GetKind() {
+					case "ClusterRole":
+						_, hasRoleRef := object.Object["roleRef"]
+						assert.False(t, hasRoleRef, "ClusterRole %s has a roleRef", object.GetName())
+						clusterRoles[object.GetName()] = true
+					case "ClusterRoleBinding":
+						roleRef, _, _ := unstructured.NestedString(object.Object, "roleRef", "name")
+						roleRefs = append(roleRefs, roleRef)
+					}
+				}
+				for _, roleRef := range roleRefs {
+					assert.True(t, clusterRoles[roleRef], "ClusterRoleBinding references unknown ClusterRole %s", roleRef)
+				}
+			})
+			return nil
+		})
+		require.NoError(t, err)
+	}
+}